@@ -57,6 +57,10 @@ func generatePalette(params *cli.CmdParams) []*cobra.Command {
 	versionUtil := cli.NewDesktopCleanerCMD(cli_util.NewVersion(params)).Root
 	upgradeUtil := cli.NewDesktopCleanerCMD(cli_util.NewUpgrade(params)).Root
 	organize := cli.NewDesktopCleanerCMD(fs.NewOrganize(params)).Root
+	undo := cli.NewDesktopCleanerCMD(fs.NewUndo(params)).Root
+	trash := cli.NewDesktopCleanerCMD(fs.NewTrash(params)).Root
+	dupes := cli.NewDesktopCleanerCMD(fs.NewDupes(params)).Root
+	similarImages := cli.NewDesktopCleanerCMD(fs.NewSimilarImages(params)).Root
 	workspace := cli.NewDesktopCleanerCMD(workspace.NewWorkspace(params)).Root
 
 	// Add commands here
@@ -66,6 +70,10 @@ func generatePalette(params *cli.CmdParams) []*cobra.Command {
 		versionUtil,
 		upgradeUtil,
 		organize,
+		undo,
+		trash,
+		dupes,
+		similarImages,
 		workspace,
 	}
 }
@@ -8,6 +8,7 @@ require (
 	github.com/ZanzyTHEbar/assert-lib v1.0.0
 	github.com/ZanzyTHEbar/errbuilder-go v1.2.0
 	github.com/briandowns/spinner v1.23.1
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/charmbracelet/glamour v0.7.0
 	github.com/charmbracelet/glow v1.5.1
 	github.com/charmbracelet/lipgloss v1.0.0
@@ -20,6 +21,7 @@ require (
 	github.com/spf13/viper v1.19.0
 	github.com/stretchr/testify v1.9.0
 	github.com/tursodatabase/go-libsql v0.0.0-20241011135853-3effbb6dea5c
+	golang.org/x/sys v0.27.0
 	golang.org/x/term v0.22.0
 	gonum.org/v1/gonum v0.15.1
 )
@@ -79,7 +81,6 @@ require (
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/exp v0.0.0-20241108190413-2d47ceb2692f // indirect
 	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/sys v0.27.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
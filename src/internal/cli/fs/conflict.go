@@ -0,0 +1,67 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"desktop-cleaner/internal/terminal"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// askConflict prompts for every conflict of the ask strategy, showing the sizes and dates of both
+// files and how they differ. An empty answer skips the file.
+func askConflict(params *cli.CmdParams) deskfs.ConflictPrompt {
+	return func(info deskfs.ConflictInfo) deskfs.ConflictResolutionType {
+		resume := suspendProgress()
+		defer resume()
+
+		params.Term.OutputWarning("%s already exists", info.Destination)
+		fmt.Printf("  existing  %10s  %s\n", formatBytes(info.DestinationSize), info.DestinationModTime.Format(time.DateTime))
+		fmt.Printf("  incoming  %10s  %s  %s\n", formatBytes(info.SourceSize), info.SourceModTime.Format(time.DateTime), info.Source)
+		fmt.Printf("  incoming is %s and %s\n", sizeDifference(info), dateDifference(info))
+
+		for {
+			fmt.Print(terminal.ColorHiBlue.Render("🔵 [o]verwrite, [k]eep both or [s]kip: "))
+			var response string
+			fmt.Scanln(&response)
+
+			switch strings.ToLower(response) {
+			case "o", "overwrite":
+				return deskfs.Overwrite
+			case "k", "keep":
+				return deskfs.RenameSuffix
+			case "s", "skip", "":
+				return deskfs.Skip
+			}
+		}
+	}
+}
+
+func sizeDifference(info deskfs.ConflictInfo) string {
+	switch delta := info.SourceSize - info.DestinationSize; {
+	case delta > 0:
+		return formatBytes(delta) + " larger"
+	case delta < 0:
+		return formatBytes(-delta) + " smaller"
+	}
+	return "the same size"
+}
+
+func dateDifference(info deskfs.ConflictInfo) string {
+	switch delta := info.SourceModTime.Sub(info.DestinationModTime).Round(time.Second); {
+	case delta > 0:
+		return formatAge(delta) + " newer"
+	case delta < 0:
+		return formatAge(-delta) + " older"
+	}
+	return "from the same time"
+}
+
+// formatAge counts days past two of them, hours are hard to read at that scale.
+func formatAge(d time.Duration) string {
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.String()
+}
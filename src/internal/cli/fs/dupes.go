@@ -0,0 +1,167 @@
+package fs
+
+import (
+	"context"
+	"desktop-cleaner/internal/cli"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+type DupesCMD struct {
+	Dupes *cobra.Command
+}
+
+var dupesParams = deskfs.NewFilePathParams()
+
+var (
+	dupesAction string
+	dupesKeep   string
+	dupesJSON   bool
+	dupesForce  bool
+)
+
+func NewDupes(params *cli.CmdParams) *cobra.Command {
+	dupesCmd := &cobra.Command{
+		Use:     "dupes",
+		Aliases: []string{"dup"},
+		Short:   "Find duplicate files and optionally trash or hardlink the extra copies",
+		Long: `Dupes finds the files with the same content below a directory. Files are compared by size, then by a hash of their first and last bytes, and only then hashed whole with SHA-256. The hashes are cached in the workspace database, so unchanged files are not read again on the next run.
+
+	Every action but report keeps one file per group, picked by --keep, and plans what happens to the others: trash-extras moves them to the trash, hardlink replaces them with hardlinks to the kept file. keep-newest, keep-oldest and keep-shortest-path are short for trash-extras with that --keep. The plan is shown first and only applied after confirmation, and undo reverses it.
+	`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := findDupes(params); err != nil {
+				params.Term.OutputErrorAndExit("Error finding duplicates: %v", err)
+			}
+		},
+	}
+
+	dupesCmd.Flags().StringVarP(&dupesParams.SourceDir, "srcDir", "d", "", "Directory to search, defaults to the current working directory")
+	dupesCmd.Flags().IntVarP(&dupesParams.MaxDepth, "max-depth", "x", -1, "Maximum depth for recursion, -1 for no limit")
+	dupesCmd.Flags().IntVarP(&dupesParams.Jobs, "jobs", "j", deskfs.DefaultJobs, "Number of files hashed at once")
+	dupesCmd.Flags().BoolVar(&dupesParams.Reindex, "reindex", false, "Rescan every directory, instead of reusing the index of the directories unchanged since the last run")
+	dupesCmd.Flags().StringVar(&dupesAction, "action", string(deskfs.DupesReport), "What to do with the duplicates: report, trash-extras, hardlink, keep-newest, keep-oldest or keep-shortest-path")
+	dupesCmd.Flags().StringVar(&dupesKeep, "keep", string(deskfs.KeepShortestPath), "File kept in every group: newest, oldest or shortest-path")
+	dupesCmd.Flags().BoolVarP(&dupesParams.DryRun, "dryrun", "n", false, "Only show the plan of the action")
+	dupesCmd.Flags().BoolVarP(&dupesForce, "yes", "y", false, "Apply the plan without asking, required to apply with --json")
+	dupesCmd.Flags().BoolVar(&dupesJSON, "json", false, "Print the report, and the plan of the action, as JSON")
+
+	return dupesCmd
+}
+
+func findDupes(params *cli.CmdParams) error {
+	keep, err := deskfs.ParseKeepPolicy(dupesKeep)
+	if err != nil {
+		return err
+	}
+	action, keep, err := deskfs.ParseDuplicateAction(dupesAction, keep)
+	if err != nil {
+		return err
+	}
+
+	if dupesParams.SourceDir == "" {
+		dupesParams.SourceDir = params.DeskFS.Cwd
+	}
+	if dupesParams.SourceDir, err = filepath.Abs(dupesParams.SourceDir); err != nil {
+		return err
+	}
+	dupesParams.TargetDir = dupesParams.SourceDir
+
+	hasher, err := params.DeskFS.OpenHasher(dupesParams.SourceDir)
+	if err != nil {
+		return err
+	}
+	report, err := params.DeskFS.FindDuplicates(params.DeskFS.InstanceConfig, dupesParams, hasher)
+	hasher.Close()
+	if err != nil {
+		return err
+	}
+	plan := params.DeskFS.PlanDuplicates(report, action, keep)
+
+	// Without someone to confirm it, a JSON plan is only applied with --yes
+	apply := action != deskfs.DupesReport && !dupesParams.DryRun && (dupesForce || !dupesJSON)
+
+	if dupesJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if action == deskfs.DupesReport {
+			return encoder.Encode(report)
+		}
+		if err := encoder.Encode(struct {
+			Report *deskfs.DuplicateReport `json:"report"`
+			Plan   *deskfs.OrganizePlan    `json:"plan"`
+		}{report, plan}); err != nil {
+			return err
+		}
+	} else {
+		if err := printDupes(params, report); err != nil {
+			return err
+		}
+		if action == deskfs.DupesReport || len(report.Groups) == 0 {
+			return nil
+		}
+
+		fmt.Println()
+		if err := plan.WriteTable(os.Stdout); err != nil {
+			return err
+		}
+		counts := plan.Counts()
+		params.Term.OutputInfo("%d to trash, %d to hardlink, %d skipped",
+			counts[deskfs.OpTrash], counts[deskfs.OpHardlink], counts[deskfs.OpSkip])
+	}
+
+	if !apply || len(plan.Operations) == 0 {
+		return nil
+	}
+	if !dupesForce && !params.Term.ConfirmYesNo("Apply this plan?") {
+		return nil
+	}
+
+	if err := params.DeskFS.ApplyPlan(context.Background(), plan, deskfs.ApplyOptions{Jobs: dupesParams.Jobs}); err != nil {
+		return err
+	}
+	if !dupesJSON {
+		params.Term.OutputSuccess("Duplicates resolved, undo -d %s restores them.", dupesParams.SourceDir)
+	}
+	return nil
+}
+
+// printDupes prints the groups as a table, one row per file, the group columns on its first file.
+func printDupes(params *cli.CmdParams, report *deskfs.DuplicateReport) error {
+	if len(report.Groups) == 0 {
+		params.Term.OutputInfo("No duplicates among %d files", report.Scanned)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tRECLAIMABLE\tPATH")
+	for i, group := range report.Groups {
+		if i > 0 {
+			fmt.Fprintln(tw, "\t\t")
+		}
+		for j, file := range group.Files {
+			size, reclaimable := "", ""
+			if j == 0 {
+				size, reclaimable = formatBytes(group.Size), formatBytes(group.Reclaimable)
+			}
+			path := file.Path
+			if file.LinkedTo != "" {
+				path += " (hardlink)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", size, reclaimable, path)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	params.Term.OutputInfo("%d groups of duplicates among %d files, %s reclaimable", len(report.Groups), report.Scanned, formatBytes(report.Reclaimable))
+	return nil
+}
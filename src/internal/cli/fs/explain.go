@@ -0,0 +1,92 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func explainFiles(params *cli.CmdParams, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("--explain needs at least one path")
+	}
+
+	// Traces compare paths, the default directories are absolute like the explained paths
+	setDefaultDirs(params)
+
+	traces := make([]*deskfs.ExplainTrace, 0, len(paths))
+	for _, path := range paths {
+		trace, err := params.DeskFS.Explain(params.DeskFS.InstanceConfig, fileParams, path)
+		if err != nil {
+			return err
+		}
+		traces = append(traces, trace)
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(traces)
+	}
+
+	for _, trace := range traces {
+		printTrace(params, trace)
+	}
+	return nil
+}
+
+func printTrace(params *cli.CmdParams, trace *deskfs.ExplainTrace) {
+	params.Term.OutputInfo("%s", trace.Path)
+
+	for _, configFile := range trace.ConfigFiles {
+		fmt.Printf("  rules:       %s\n", configFile)
+	}
+	if trace.IgnoredBy != "" {
+		fmt.Printf("  ignored by:  %s\n", trace.IgnoredBy)
+	}
+	if trace.Project != "" {
+		fmt.Printf("  project:     %s\n", trace.Project)
+	}
+	if trace.MimeType != "" {
+		fmt.Printf("  content:     %s\n", trace.MimeType)
+	}
+
+	for _, candidate := range trace.Candidates {
+		marker := " "
+		if candidate.Winner {
+			marker = "*"
+		}
+		rule := candidate.Rule
+		if len(candidate.Predicates) > 0 {
+			rule += " [" + strings.Join(candidate.Predicates, ", ") + "]"
+		}
+		fmt.Printf("  candidate: %s %s via %s (priority %d, specificity %d, depth %d, order %d)\n",
+			marker, candidate.Category, rule, candidate.Priority, candidate.Specificity, candidate.Depth, candidate.Order)
+	}
+
+	if trace.Fallback != "" {
+		fmt.Printf("  fallback:    %s\n", trace.Fallback)
+	}
+	if trace.Category != "" {
+		fmt.Printf("  category:    %s\n", trace.Category)
+	}
+	if trace.Conflict != "" {
+		fmt.Printf("  conflict:    destination exists, resolved by %s\n", trace.Conflict)
+	}
+	if trace.Destination != "" {
+		fmt.Printf("  destination: %s\n", trace.Destination)
+	}
+
+	if trace.Action == "skip" {
+		params.Term.OutputWarning("Skipped: %s", trace.Reason)
+		return
+	}
+	if trace.Action == "trash" {
+		params.Term.OutputWarning("Would move to the trash: %s", trace.Reason)
+		return
+	}
+	params.Term.OutputSuccess("Would %s to %s", trace.Action, trace.Destination)
+}
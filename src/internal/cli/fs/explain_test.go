@@ -0,0 +1,120 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	"desktop-cleaner/internal/db"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"desktop-cleaner/internal/terminal"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newExplainParams returns command params for a source directory holding a single report, and
+// points the organize flags at it for the duration of the test.
+func newExplainParams(t *testing.T) (*cli.CmdParams, string) {
+	t.Setenv("HOME", t.TempDir())
+	centralDB, err := db.NewCentralDBProvider()
+	if err != nil {
+		t.Fatalf("failed to open the central database: %v", err)
+	}
+	t.Cleanup(func() { centralDB.Close() })
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.toml")
+	files := map[string]string{
+		configPath: `file_types = { "Docs" = [".pdf"], "Docs/Reports" = ["glob:report*"] }`,
+		filepath.Join(dir, "source", "report.pdf"): "%PDF-1.7\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	term := terminal.NewTerminal()
+	dfs := deskfs.NewDesktopFS(term, centralDB)
+	dfs.InitConfig(configPath)
+
+	previous, previousJSON := fileParams, jsonOutput
+	t.Cleanup(func() { fileParams, jsonOutput = previous, previousJSON })
+	fileParams = deskfs.NewFilePathParams()
+	fileParams.SourceDir = filepath.Join(dir, "source")
+	fileParams.TargetDir = filepath.Join(dir, "target")
+
+	return &cli.CmdParams{Term: term, DeskFS: dfs, CentralDB: centralDB}, dir
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	fnErr := fn()
+	os.Stdout = stdout
+	w.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, fnErr)
+	return string(out)
+}
+
+func TestExplainFilesText(t *testing.T) {
+	params, dir := newExplainParams(t)
+	report := filepath.Join(dir, "source", "report.pdf")
+
+	out := captureStdout(t, func() error { return explainFiles(params, []string{report}) })
+	assert.Contains(t, out, report)
+	assert.Contains(t, out, "content:     application/pdf")
+	assert.Contains(t, out, "candidate: * Docs/Reports via glob:report*")
+	assert.Contains(t, out, "candidate:   Docs via .pdf")
+	assert.Contains(t, out, "category:    Docs/Reports")
+	assert.Contains(t, out, "destination: "+filepath.Join(dir, "target", "Docs", "Reports", "report.pdf"))
+	assert.Contains(t, out, "Would move to")
+
+	out = captureStdout(t, func() error { return explainFiles(params, []string{filepath.Join(dir, "config.toml")}) })
+	assert.Contains(t, out, "Skipped: no mapping found")
+	assert.NotContains(t, out, "destination:")
+}
+
+func TestExplainFilesJSON(t *testing.T) {
+	params, dir := newExplainParams(t)
+	jsonOutput = true
+
+	out := captureStdout(t, func() error {
+		return explainFiles(params, []string{filepath.Join(dir, "source", "report.pdf")})
+	})
+
+	var traces []deskfs.ExplainTrace
+	if err := json.Unmarshal([]byte(out), &traces); err != nil {
+		t.Fatalf("explain did not print JSON: %v\n%s", err, out)
+	}
+	if assert.Len(t, traces, 1) {
+		trace := traces[0]
+		assert.Equal(t, "move", trace.Action)
+		assert.Equal(t, "glob:report*", trace.Rule)
+		assert.Equal(t, "Docs/Reports", trace.Category)
+		assert.Len(t, trace.Candidates, 2)
+	}
+
+	// Fields without a value are left out
+	var raw []map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(out), &raw))
+	assert.NotContains(t, raw[0], "reason")
+	assert.NotContains(t, raw[0], "ignored_by")
+	assert.Contains(t, raw[0], "candidates")
+}
@@ -1,11 +1,16 @@
 package fs
 
 import (
+	"context"
 	"desktop-cleaner/internal/cli"
 	deskfs "desktop-cleaner/internal/deskfs"
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 type OrganizeCMD struct {
@@ -14,13 +19,55 @@ type OrganizeCMD struct {
 
 var fileParams *deskfs.FilePathParams = deskfs.NewFilePathParams()
 
+var (
+	explain      bool
+	jsonOutput   bool
+	copyStrategy string
+	symlinks     string
+	conflict     string
+)
+
 func NewOrganize(params *cli.CmdParams) *cobra.Command {
 	organizeCmd := &cobra.Command{
-		Use:     "organize",
+		Use:     "organize [paths to explain...]",
 		Aliases: []string{"o"},
 		Short:   "Organize files in the specified directory, based on the configuration",
 		Long:    `Organize files based on the configuration. Optionally specify a destination directory. If not provided, the current working directory is used.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			if err := params.DeskFS.InstanceConfig.CheckRules(); err != nil {
+				params.Term.OutputErrorAndExit("Invalid config: %v", err)
+			}
+
+			strategy, err := deskfs.ParseCopyStrategy(copyStrategy)
+			if err != nil {
+				params.Term.OutputErrorAndExit("Error: %v", err)
+			}
+			fileParams.CopyStrategy = strategy
+
+			policy, err := deskfs.ParseSymlinkPolicy(symlinks)
+			if err != nil {
+				params.Term.OutputErrorAndExit("Error: %v", err)
+			}
+			fileParams.Symlinks = policy
+
+			resolution, err := deskfs.ParseConflictResolution(conflict)
+			if err != nil {
+				params.Term.OutputErrorAndExit("Error: %v", err)
+			}
+			fileParams.ConflictResolution = resolution
+
+			if explain {
+				if err := explainFiles(params, args); err != nil {
+					params.Term.OutputErrorAndExit("Error explaining files: %v", err)
+				}
+				return
+			}
+			if fileParams.NamesOnly || fileParams.DryRun {
+				if err := previewPlan(params); err != nil {
+					params.Term.OutputErrorAndExit("Error planning organize: %v", err)
+				}
+				return
+			}
 			if err := organizeFiles(params); err != nil {
 				params.Term.OutputErrorAndExit("Error organizing files: %v", err)
 			}
@@ -29,20 +76,33 @@ func NewOrganize(params *cli.CmdParams) *cobra.Command {
 
 	// Define flags and configuration settings
 	organizeCmd.Flags().BoolVar(&fileParams.RemoveAfter, "remove", false, "Remove files after organizing")
-	organizeCmd.Flags().BoolVar(&fileParams.NamesOnly, "names-only", false, "Organize by names only")
-	organizeCmd.Flags().BoolVar(&fileParams.ForceSkipIgnore, "force-skip-ignore", false, "Force skip ignored files")
+	organizeCmd.Flags().BoolVar(&fileParams.NamesOnly, "names-only", false, "List planned destinations from file names only, without reading metadata or moving files")
+	organizeCmd.Flags().BoolVar(&fileParams.ForceSkipIgnore, "force-skip-ignore", false, "Bypass .desktop-cleaner-ignore files and organize ignored files too")
 	organizeCmd.Flags().BoolVarP(&fileParams.Recursive, "recursive", "r", false, "Recursively organize files")
 	organizeCmd.Flags().BoolVarP(&fileParams.DryRun, "dryrun", "n", false, "Dry run to simulate organization")
-	organizeCmd.Flags().IntVarP(&fileParams.MaxDepth, "max-depth", "x", -1, "Maximum depth for recursion")
+	organizeCmd.Flags().IntVarP(&fileParams.MaxDepth, "max-depth", "x", -1, "Maximum depth for recursion, -1 for no limit")
+	organizeCmd.Flags().IntVarP(&fileParams.Jobs, "jobs", "j", deskfs.DefaultJobs, "Number of file operations run in parallel, split across source/target device pairs")
+	organizeCmd.Flags().IntVar(&fileParams.ScanJobs, "scan-jobs", deskfs.DefaultScanJobs, "Number of directories read at once while indexing")
+	organizeCmd.Flags().BoolVar(&fileParams.Reindex, "reindex", false, "Rescan every directory, instead of reusing the index of the directories unchanged since the last run")
+	organizeCmd.Flags().StringVar(&progressMode, "progress", progressAuto, "Progress output: auto, rich, json (NDJSON events on stdout) or none")
+	organizeCmd.Flags().StringVar(&copyStrategy, "copy-strategy", string(deskfs.CopyAuto), "How copies are written: auto, clone, hardlink, sparse or full")
+	organizeCmd.Flags().BoolVar(&fileParams.AllowHardlinks, "hardlink", false, "Let the auto copy strategy hardlink files when source and target share a file system")
+	organizeCmd.Flags().StringVar(&symlinks, "symlinks", string(deskfs.SymlinksPreserve), "Symbolic links: skip them, preserve them as links, or follow them")
+	organizeCmd.Flags().StringVar(&conflict, "conflict", string(deskfs.RenameSuffix), "Conflict resolution for categories without a conflict= entry: rename, skip, overwrite, dedupe, newer-wins, larger-wins, keep-both-timestamp or ask")
 	organizeCmd.Flags().BoolVarP(&fileParams.GitEnabled, "git-enabled", "g", false, "Enable Git operations")
 	organizeCmd.Flags().BoolVarP(&fileParams.CopyFiles, "copy", "c", false, "Enable move as Copy operation, required when moving files across partitions. If not enabled, will default to copy when move is not possible.")
 	organizeCmd.Flags().StringVarP(&fileParams.SourceDir, "srcDir", "d", "", "Destination directory to organize files from")
 	organizeCmd.Flags().StringVarP(&fileParams.TargetDir, "target", "t", "", "Target directory to organize files into")
+	organizeCmd.Flags().BoolVar(&explain, "explain", false, "Explain how the given paths would be organized, without touching the disk")
+	organizeCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the --explain trace or the planned operations of --dryrun and --names-only as JSON")
+	organizeCmd.Flags().BoolVar(&resumeRuns, "resume", false, "Resume the interrupted runs of the source directory without asking, then organize")
+	organizeCmd.Flags().BoolVar(&rollbackRuns, "rollback", false, "Roll back the interrupted runs of the source directory without asking, then organize")
+	organizeCmd.MarkFlagsMutuallyExclusive("resume", "rollback")
 
 	return organizeCmd
 }
 
-func organizeFiles(params *cli.CmdParams) error {
+func setDefaultDirs(params *cli.CmdParams) {
 	// Set default directories if not provided
 	if fileParams.SourceDir == "" {
 		var err error
@@ -56,29 +116,128 @@ func organizeFiles(params *cli.CmdParams) error {
 		fileParams.TargetDir = fileParams.SourceDir
 	}
 
-	params.Term.ToggleSpinner(true, "Organizing files...")
+	// The journal records absolute paths, so a run can be recovered from any working directory
+	var err error
+	if fileParams.SourceDir, err = filepath.Abs(fileParams.SourceDir); err != nil {
+		params.Term.OutputErrorAndExit("Error resolving source directory: %v", err)
+	}
+	if fileParams.TargetDir, err = filepath.Abs(fileParams.TargetDir); err != nil {
+		params.Term.OutputErrorAndExit("Error resolving target directory: %v", err)
+	}
+}
+
+func organizeFiles(params *cli.CmdParams) error {
+	setDefaultDirs(params)
+
+	// The NDJSON stream owns stdout, warnings and summaries are left out of it
+	human := !jsonOutputProgress()
+
+	if human {
+		for _, overlap := range params.DeskFS.InstanceConfig.Validate() {
+			params.Term.OutputWarning("Overlapping rules: %s", overlap)
+		}
+	} else {
+		// Recovery logs too, before the progress stream starts
+		logToStderr()
+	}
+
+	if err := recoverInterruptedRuns(params, human); err != nil {
+		params.Term.OutputErrorAndExit("Error recovering interrupted runs: %v", err)
+	}
 
 	// Initialize Git if Git is enabled and repository is not already initialized
 	if fileParams.GitEnabled {
 		if !params.DeskFS.IsGitRepo(fileParams.SourceDir) {
-			params.Term.OutputInfo("Git operations enabled, but no Git repository detected. Initializing Git repository.")
+			if human {
+				params.Term.OutputInfo("Git operations enabled, but no Git repository detected. Initializing Git repository.")
+			}
 			if err := params.DeskFS.InitGitRepo(fileParams.SourceDir); err != nil {
 				params.Term.OutputErrorAndExit("Error initializing Git repository: %v", err)
 			}
-		} else {
+		} else if human {
 			params.Term.OutputInfo("Git repository detected.")
 		}
-	} else {
+	} else if human {
 		params.Term.OutputWarning("Git operations disabled. Proceeding without Git.")
 	}
 
+	// Conflicts of the ask strategy need someone at the terminal
+	if human && term.IsTerminal(int(os.Stdin.Fd())) {
+		params.DeskFS.AskConflict = askConflict(params)
+	}
+
+	stopProgress, err := startProgress(params)
+	if err != nil {
+		return err
+	}
+
 	// Execute the organization logic with EnhancedOrganize
-	if err := params.DeskFS.EnhancedOrganize(params.DeskFS.InstanceConfig, fileParams); err != nil {
+	err = params.DeskFS.EnhancedOrganize(params.DeskFS.InstanceConfig, fileParams)
+	stopProgress()
+	if err != nil {
 		params.Term.OutputErrorAndExit("Error organizing files: %v", err)
 	}
 
-	params.Term.ToggleSpinner(false, "")
-	params.Term.OutputSuccess("Files organized successfully.")
+	if human {
+		params.Term.OutputSuccess("Files organized successfully.")
+		printUnmatched(params)
+	}
+
+	return nil
+}
+
+// previewPlan prints the operations organize would perform. The plan goes through the applier
+// in dry run mode, exactly as a real run minus the side effects.
+func previewPlan(params *cli.CmdParams) error {
+	setDefaultDirs(params)
+
+	for _, overlap := range params.DeskFS.InstanceConfig.Validate() {
+		params.Term.OutputWarning("Overlapping rules: %s", overlap)
+	}
+
+	plan, err := params.DeskFS.BuildPlan(params.DeskFS.InstanceConfig, fileParams)
+	if err != nil {
+		return err
+	}
+
+	if err := params.DeskFS.ApplyPlan(context.Background(), plan, deskfs.ApplyOptions{DryRun: true}); err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
 
+	if err := plan.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+
+	counts := plan.Counts()
+	params.Term.OutputInfo("%d to move, %d to copy, %d to rename, %d to trash, %d directories to create, %d skipped",
+		counts[deskfs.OpMove], counts[deskfs.OpCopy], counts[deskfs.OpRename], counts[deskfs.OpTrash], counts[deskfs.OpCreateDir], counts[deskfs.OpSkip])
+	printUnmatched(params)
 	return nil
 }
+
+// printUnmatched summarizes the extensions no rule matched, as a starting point for new file_types entries.
+func printUnmatched(params *cli.CmdParams) {
+	unmatched := params.DeskFS.Unmatched.Extensions()
+	if len(unmatched) == 0 {
+		return
+	}
+
+	params.Term.OutputWarning("Files matching no rule, by extension:")
+	for _, entry := range unmatched {
+		extension := entry.Extension
+		if extension == "" {
+			extension = "(no extension)"
+		}
+		line := fmt.Sprintf("  %-16s %d", extension, entry.Count)
+		if entry.FallenBack > 0 {
+			line += fmt.Sprintf(" (%d sent to a fallback category)", entry.FallenBack)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,310 @@
+package fs
+
+import (
+	"context"
+	"desktop-cleaner/internal/cli"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"desktop-cleaner/internal/terminal"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	progressAuto = "auto" // Rich display on a terminal, the spinner otherwise
+	progressRich = "rich"
+	progressJSON = "json"
+	progressNone = "none" // Spinner only
+)
+
+var progressMode string
+
+// jsonOutputProgress reports whether stdout carries the NDJSON event stream, in which case nothing
+// else may be printed there.
+func jsonOutputProgress() bool {
+	return progressMode == progressJSON
+}
+
+// suspendProgress hands the terminal over to a prompt until the returned function is called.
+var suspendProgress = noSuspend
+
+func noSuspend() func() { return func() {} }
+
+// startProgress installs the reporter selected by --progress and returns the function that stops it.
+func startProgress(params *cli.CmdParams) (func(), error) {
+	mode := progressMode
+	if mode == progressAuto {
+		mode = progressNone
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			mode = progressRich
+		}
+	}
+
+	switch mode {
+	case progressRich:
+		display := newProgressDisplay(params.Term)
+		params.DeskFS.SetProgress(display)
+		suspendProgress = display.pause
+		display.start()
+		return func() {
+			display.stop()
+			params.DeskFS.SetProgress(nil)
+			suspendProgress = noSuspend
+		}, nil
+	case progressJSON:
+		logToStderr()
+		params.DeskFS.SetProgress(newJSONProgress(os.Stdout))
+		return func() { params.DeskFS.SetProgress(nil) }, nil
+	case progressNone:
+		params.Term.ToggleSpinner(true, "Organizing files...")
+		suspendProgress = func() func() {
+			params.Term.ToggleSpinner(false, "")
+			return func() { params.Term.ToggleSpinner(true, "Organizing files...") }
+		}
+		return func() {
+			params.Term.ToggleSpinner(false, "")
+			suspendProgress = noSuspend
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown progress output %q, expected auto, rich, json or none", progressMode)
+}
+
+// logToStderr moves the log lines off stdout, keeping the level of the configured logger.
+func logToStderr() {
+	level := slog.LevelError
+	for _, candidate := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn} {
+		if slog.Default().Enabled(context.Background(), candidate) {
+			level = candidate
+			break
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// jsonProgress writes every event as a line of JSON, for scripts wrapping organize.
+type jsonProgress struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func newJSONProgress(w io.Writer) *jsonProgress {
+	return &jsonProgress{encoder: json.NewEncoder(w)}
+}
+
+func (p *jsonProgress) Report(event deskfs.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_ = p.encoder.Encode(event)
+}
+
+// progressDisplay renders the events as a few lines redrawn in place: files and bytes done,
+// throughput and the estimated time left.
+type progressDisplay struct {
+	term *terminal.Terminal
+
+	mu         sync.Mutex
+	startedAt  time.Time
+	appliedAt  time.Time // First transfer, throughput is measured from there
+	dirs       int
+	indexed    int
+	planned    bool
+	totalFiles int
+	totalBytes int64
+	files      int
+	bytes      int64
+	copying    map[string]int64 // Bytes written so far by the copies in flight
+	errors     int
+	current    string
+
+	drawMu sync.Mutex // Held while drawing, so a prompt never interleaves with a redraw
+	drawn  int
+	paused bool
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+func newProgressDisplay(t *terminal.Terminal) *progressDisplay {
+	return &progressDisplay{
+		term:      t,
+		startedAt: time.Now(),
+		copying:   make(map[string]int64),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (d *progressDisplay) Report(event deskfs.ProgressEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch event.Type {
+	case deskfs.EventDirIndexed:
+		d.dirs++
+		d.indexed += event.Files
+		d.current = event.Path
+	case deskfs.EventPlanReady:
+		d.planned = true
+		d.totalFiles = event.Files
+		d.totalBytes = event.Bytes
+	case deskfs.EventBytesCopied:
+		d.markApplying(event.Time)
+		d.copying[event.Path] += event.Bytes
+		d.current = event.Path
+	case deskfs.EventFileMoved:
+		d.markApplying(event.Time)
+		delete(d.copying, event.Path)
+		d.files++
+		d.bytes += event.Bytes
+		d.current = event.Path
+	case deskfs.EventError:
+		d.errors++
+	}
+}
+
+func (d *progressDisplay) markApplying(at time.Time) {
+	if d.appliedAt.IsZero() {
+		d.appliedAt = at
+	}
+}
+
+func (d *progressDisplay) start() {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.draw()
+			case <-d.quit:
+				d.draw()
+				return
+			}
+		}
+	}()
+}
+
+func (d *progressDisplay) stop() {
+	close(d.quit)
+	<-d.done
+}
+
+// pause stops redrawing until the returned function is called, the display then starts over below
+// whatever was printed meanwhile.
+func (d *progressDisplay) pause() func() {
+	d.drawMu.Lock()
+	d.paused = true
+	d.drawMu.Unlock()
+
+	return func() {
+		d.drawMu.Lock()
+		d.paused = false
+		d.drawn = 0
+		d.drawMu.Unlock()
+	}
+}
+
+func (d *progressDisplay) draw() {
+	d.drawMu.Lock()
+	defer d.drawMu.Unlock()
+	if d.paused {
+		return
+	}
+
+	d.mu.Lock()
+	lines := d.lines(time.Now())
+	d.mu.Unlock()
+
+	if d.drawn > 0 {
+		d.term.MoveCursorUpLines(d.drawn)
+	}
+	for _, line := range lines {
+		d.term.ClearCurrentLine()
+		fmt.Println(line)
+	}
+	d.drawn = len(lines)
+}
+
+// lines renders the state of the run as of now.
+func (d *progressDisplay) lines(now time.Time) []string {
+	if !d.planned {
+		return []string{
+			terminal.ColorHiBlue.Render(fmt.Sprintf("Scanning   %d directories, %d files", d.dirs, d.indexed)),
+			fmt.Sprintf("Current    %s", truncatePath(d.current)),
+		}
+	}
+
+	bytes := d.bytes
+	for _, written := range d.copying {
+		bytes += written
+	}
+
+	percent := 100.0
+	if d.totalFiles > 0 {
+		percent = float64(d.files) * 100 / float64(d.totalFiles)
+	}
+
+	throughput, eta := "-", "-"
+	if !d.appliedAt.IsZero() {
+		elapsed := now.Sub(d.appliedAt).Seconds()
+		if elapsed > 0 {
+			rate := float64(bytes) / elapsed
+			throughput = formatBytes(int64(rate)) + "/s"
+
+			// Estimate from bytes when sizes are known, from the file count otherwise
+			switch {
+			case d.totalBytes > 0 && rate > 0:
+				eta = formatDuration(float64(d.totalBytes-bytes) / rate)
+			case d.files > 0:
+				eta = formatDuration(float64(d.totalFiles-d.files) * elapsed / float64(d.files))
+			}
+		}
+	}
+
+	status := fmt.Sprintf("Files      %d/%d (%.0f%%)", d.files, d.totalFiles, percent)
+	if d.errors > 0 {
+		status += terminal.ColorHiRed.Render(fmt.Sprintf("  %d errors", d.errors))
+	}
+
+	return []string{
+		terminal.ColorHiBlue.Render(status),
+		fmt.Sprintf("Bytes      %s/%s  %s  ETA %s", formatBytes(bytes), formatBytes(d.totalBytes), throughput, eta),
+		fmt.Sprintf("Current    %s", truncatePath(d.current)),
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	return (time.Duration(seconds) * time.Second).Round(time.Second).String()
+}
+
+// truncatePath keeps the end of long paths, which carries the file name.
+func truncatePath(path string) string {
+	const max = 60
+	if len(path) <= max {
+		return path
+	}
+	return "..." + path[len(path)-max+3:]
+}
@@ -0,0 +1,107 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"desktop-cleaner/internal/terminal"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONProgress(t *testing.T) {
+	var out bytes.Buffer
+	progress := newJSONProgress(&out)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	progress.Report(deskfs.ProgressEvent{Type: deskfs.EventPlanReady, Time: at, Files: 2, Bytes: 2048})
+	progress.Report(deskfs.ProgressEvent{Type: deskfs.EventFileMoved, Time: at, Path: "/src/a.txt", Destination: "/dst/a.txt", Bytes: 1024})
+	progress.Report(deskfs.ProgressEvent{Type: deskfs.EventFinished, Time: at, Error: "disk full"})
+
+	// One event per line, fields without a value are left out
+	var events []map[string]any
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var event map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if !assert.Len(t, events, 3) {
+		return
+	}
+
+	assert.Equal(t, map[string]any{"event": "plan_ready", "time": "2024-05-01T12:00:00Z", "files": 2.0, "bytes": 2048.0}, events[0])
+	assert.Equal(t, "file_moved", events[1]["event"])
+	assert.Equal(t, "/dst/a.txt", events[1]["destination"])
+	assert.NotContains(t, events[1], "files")
+	assert.Equal(t, map[string]any{"event": "finished", "time": "2024-05-01T12:00:00Z", "error": "disk full"}, events[2])
+}
+
+func TestProgressDisplayLines(t *testing.T) {
+	const mib = 1 << 20
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	display := newProgressDisplay(terminal.NewTerminal())
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventDirIndexed, Path: "/src", Files: 3})
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventDirIndexed, Path: "/src/deep", Files: 1})
+	lines := display.lines(start)
+	assert.Contains(t, lines[0], "Scanning   2 directories, 4 files")
+	assert.Equal(t, "Current    /src/deep", lines[1])
+
+	// Throughput counts the copies in flight, measured from the first transfer
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventPlanReady, Files: 4, Bytes: 4 * mib})
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventFileMoved, Time: start, Path: "/src/a", Bytes: mib})
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventBytesCopied, Time: start.Add(time.Second), Path: "/src/b", Bytes: mib})
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventError, Path: "/src/c"})
+	lines = display.lines(start.Add(2 * time.Second))
+	assert.Contains(t, lines[0], "Files      1/4 (25%)")
+	assert.Contains(t, lines[0], "1 errors")
+	assert.Equal(t, "Bytes      2.0 MiB/4.0 MiB  1.0 MiB/s  ETA 2s", lines[1])
+	assert.Equal(t, "Current    /src/b", lines[2])
+
+	// Once a copy is done its bytes count once
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventFileMoved, Time: start.Add(3 * time.Second), Path: "/src/b", Bytes: mib})
+	lines = display.lines(start.Add(4 * time.Second))
+	assert.Equal(t, "Bytes      2.0 MiB/4.0 MiB  512.0 KiB/s  ETA 4s", lines[1])
+}
+
+func TestProgressDisplayUnknownSizes(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Without sizes, e.g. with --names-only, the estimate follows the file count
+	display := newProgressDisplay(terminal.NewTerminal())
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventPlanReady, Files: 3})
+	assert.Equal(t, "Bytes      0 B/0 B  -  ETA -", display.lines(start)[1], "nothing applied yet")
+
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventFileMoved, Time: start, Path: "/src/a"})
+	lines := display.lines(start.Add(2 * time.Second))
+	assert.Contains(t, lines[0], "Files      1/3 (33%)")
+	assert.Equal(t, "Bytes      0 B/0 B  0 B/s  ETA 4s", lines[1])
+
+	// An empty plan is complete
+	display = newProgressDisplay(terminal.NewTerminal())
+	display.Report(deskfs.ProgressEvent{Type: deskfs.EventPlanReady})
+	assert.Contains(t, display.lines(start)[0], "Files      0/0 (100%)")
+}
+
+func TestFormatProgress(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(3<<19))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+
+	assert.Equal(t, "0s", formatDuration(-3))
+	assert.Equal(t, "1m30s", formatDuration(90))
+	assert.Equal(t, "1h0m0s", formatDuration(3600))
+
+	assert.Equal(t, "/short/path", truncatePath("/short/path"))
+	long := "/" + string(bytes.Repeat([]byte("d/"), 40)) + "file.txt"
+	truncated := truncatePath(long)
+	assert.Len(t, truncated, 60)
+	assert.Equal(t, "...", truncated[:3])
+	assert.Equal(t, "file.txt", truncated[len(truncated)-8:])
+}
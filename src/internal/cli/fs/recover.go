@@ -0,0 +1,66 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Recovery of the interrupted runs, set by --resume and --rollback.
+var (
+	resumeRuns   bool
+	rollbackRuns bool
+)
+
+// recoverInterruptedRuns resumes or rolls back the organize runs of the source directory that
+// stopped part way, e.g. on a crash or power loss, before a new run starts. --resume and
+// --rollback decide for every run, without them the user is asked. Nobody is asked with human
+// false, the NDJSON stream owns stdout, or without a terminal: an interrupted run is an error then.
+func recoverInterruptedRuns(params *cli.CmdParams, human bool) error {
+	runs, err := params.DeskFS.InterruptedRuns(fileParams.SourceDir)
+	if err != nil {
+		return err
+	}
+	interactive := human && term.IsTerminal(int(os.Stdin.Fd()))
+
+	for _, run := range runs {
+		done, total, err := params.DeskFS.RunProgress(fileParams.SourceDir, run.ID)
+		if err != nil {
+			return err
+		}
+
+		interrupted := fmt.Sprintf("run %s started %s was interrupted after %d of %d operations",
+			run.ID, run.StartedAt.Local().Format("2006-01-02 15:04:05"), done, total)
+		if !resumeRuns && !rollbackRuns && !interactive {
+			return fmt.Errorf("%s, organize again with --resume or --rollback", interrupted)
+		}
+		if human {
+			params.Term.OutputWarning("%s.", interrupted)
+		}
+
+		if resumeRuns || (!rollbackRuns && params.Term.ConfirmYesNo("Resume it?")) {
+			if err := params.DeskFS.ResumeRun(fileParams.SourceDir, run.ID); err != nil {
+				return fmt.Errorf("failed to resume run %s: %w", run.ID, err)
+			}
+			if human {
+				params.Term.OutputSuccess("Run %s resumed and completed.", run.ID)
+			}
+			continue
+		}
+
+		if rollbackRuns || params.Term.ConfirmYesNo("Roll it back?") {
+			if err := params.DeskFS.RollbackRun(fileParams.SourceDir, run.ID); err != nil {
+				return fmt.Errorf("failed to roll back run %s: %w", run.ID, err)
+			}
+			if human {
+				params.Term.OutputSuccess("Run %s rolled back.", run.ID)
+			}
+			continue
+		}
+
+		params.Term.OutputInfo("Run %s left as is, you will be asked again next time.", run.ID)
+	}
+	return nil
+}
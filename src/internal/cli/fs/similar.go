@@ -0,0 +1,111 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	deskfs "desktop-cleaner/internal/deskfs"
+	"desktop-cleaner/internal/filesystem/trees"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+type SimilarImagesCMD struct {
+	SimilarImages *cobra.Command
+}
+
+var similarParams = deskfs.NewFilePathParams()
+
+var (
+	similarAlgorithm string
+	similarThreshold int
+	similarJSON      bool
+)
+
+func NewSimilarImages(params *cli.CmdParams) *cobra.Command {
+	similarCmd := &cobra.Command{
+		Use:     "similar-images",
+		Aliases: []string{"similar"},
+		Short:   "Find images that look alike, such as resized or re-encoded copies",
+		Long: `Similar-images finds the JPEG, PNG and GIF images below a directory that look alike, even when their bytes differ: resized copies, re-encoded copies, screenshots saved twice. Every image gets a 64-bit perceptual hash, and images whose hashes differ in at most --threshold bits are grouped. The hashes are cached in the workspace database, so unchanged images are not decoded again on the next run.
+
+	phash, the default, compares the low frequencies of the image and also catches small edits and color changes; dhash compares the brightness of neighbouring pixels and is a little faster to compute. Nothing is changed, the groups are only reported, the largest image of each first.
+	`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := findSimilarImages(params); err != nil {
+				params.Term.OutputErrorAndExit("Error finding similar images: %v", err)
+			}
+		},
+	}
+
+	similarCmd.Flags().StringVarP(&similarParams.SourceDir, "srcDir", "d", "", "Directory to search, defaults to the current working directory")
+	similarCmd.Flags().IntVarP(&similarParams.MaxDepth, "max-depth", "x", -1, "Maximum depth for recursion, -1 for no limit")
+	similarCmd.Flags().IntVarP(&similarParams.Jobs, "jobs", "j", deskfs.DefaultJobs, "Number of images decoded at once")
+	similarCmd.Flags().BoolVar(&similarParams.Reindex, "reindex", false, "Rescan every directory, instead of reusing the index of the directories unchanged since the last run")
+	similarCmd.Flags().StringVar(&similarAlgorithm, "algorithm", string(trees.PerceptualPHash), "Perceptual hash images are compared by: phash or dhash")
+	similarCmd.Flags().IntVarP(&similarThreshold, "threshold", "t", deskfs.DefaultSimilarityThreshold, "Maximum number of differing bits, out of 64, between the hashes of similar images")
+	similarCmd.Flags().BoolVar(&similarJSON, "json", false, "Print the report as JSON")
+
+	return similarCmd
+}
+
+func findSimilarImages(params *cli.CmdParams) error {
+	algorithm, err := trees.ParsePerceptualAlgorithm(similarAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	if similarParams.SourceDir == "" {
+		similarParams.SourceDir = params.DeskFS.Cwd
+	}
+	if similarParams.SourceDir, err = filepath.Abs(similarParams.SourceDir); err != nil {
+		return err
+	}
+	similarParams.TargetDir = similarParams.SourceDir
+
+	hasher, err := params.DeskFS.OpenHasher(similarParams.SourceDir)
+	if err != nil {
+		return err
+	}
+	report, err := params.DeskFS.FindSimilarImages(params.DeskFS.InstanceConfig, similarParams, hasher.Images, algorithm, similarThreshold)
+	hasher.Close()
+	if err != nil {
+		return err
+	}
+
+	if similarJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return printSimilarImages(params, report)
+}
+
+// printSimilarImages prints the groups as a table, one row per image.
+func printSimilarImages(params *cli.CmdParams, report *deskfs.SimilarImagesReport) error {
+	if len(report.Groups) == 0 {
+		params.Term.OutputInfo("No similar images among %d images", report.Scanned)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DISTANCE\tPIXELS\tSIZE\tPATH")
+	for i, group := range report.Groups {
+		if i > 0 {
+			fmt.Fprintln(tw, "\t\t\t")
+		}
+		for _, image := range group.Images {
+			fmt.Fprintf(tw, "%d\t%dx%d\t%s\t%s\n", image.Distance, image.Width, image.Height, formatBytes(image.Size), image.Path)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	params.Term.OutputInfo("%d groups of similar images among %d images, %s up to %d bits apart", len(report.Groups), report.Scanned, report.Algorithm, report.Threshold)
+	return nil
+}
@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type TrashCMD struct {
+	Trash *cobra.Command
+}
+
+var (
+	olderThan  string
+	emptyForce bool
+)
+
+func NewTrash(params *cli.CmdParams) *cobra.Command {
+	trashCmd := &cobra.Command{
+		Use:     "trash",
+		Aliases: []string{"t"},
+		Short:   "List, restore and empty the trashed files",
+		Long: `Trash manages the trash shared with the desktop file managers, following the FreeDesktop.org Trash specification: the home trash in $XDG_DATA_HOME/Trash, and the .Trash-$uid directory at the top of other mounts.
+
+	Files organize trashes, such as the losers of a conflict, show up there, and so do the files trashed from a file manager.
+	`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the trashed files, most recent first",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := listTrash(params); err != nil {
+				params.Term.OutputErrorAndExit("Error listing the trash: %v", err)
+			}
+		},
+	}
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore <item>",
+		Short: "Restore a trashed file to its original path",
+		Long:  `Restore moves a trashed file or directory back to where it was. The item is its name in the trash, as listed by trash list, or its original path. A bare file name restores the most recently trashed file of that name.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := restoreTrash(params, args[0]); err != nil {
+				params.Term.OutputErrorAndExit("Error restoring %s: %v", args[0], err)
+			}
+		},
+	}
+
+	emptyCmd := &cobra.Command{
+		Use:   "empty",
+		Short: "Permanently delete trashed files",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := emptyTrash(params); err != nil {
+				params.Term.OutputErrorAndExit("Error emptying the trash: %v", err)
+			}
+		},
+	}
+	emptyCmd.Flags().StringVar(&olderThan, "older-than", "", "Only delete what was trashed longer ago than this, e.g. 36h, 30d or 2w")
+	emptyCmd.Flags().BoolVarP(&emptyForce, "yes", "y", false, "Do not ask for confirmation")
+
+	trashCmd.AddCommand(listCmd, restoreCmd, emptyCmd)
+	return trashCmd
+}
+
+func listTrash(params *cli.CmdParams) error {
+	items, err := params.DeskFS.TrashItems()
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		params.Term.OutputInfo("The trash is empty")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRASHED\tNAME\tORIGINAL PATH")
+	for _, item := range items {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", item.DeletedAt.Format("2006-01-02 15:04:05"), item.Name, item.OriginalPath)
+	}
+	return tw.Flush()
+}
+
+func restoreTrash(params *cli.CmdParams, query string) error {
+	item, err := params.DeskFS.FindTrashItem(query)
+	if err != nil {
+		return err
+	}
+	if err := params.DeskFS.RestoreTrashItem(item); err != nil {
+		return err
+	}
+	params.Term.OutputSuccess("Restored %s", item.OriginalPath)
+	return nil
+}
+
+func emptyTrash(params *cli.CmdParams) error {
+	var age time.Duration
+	question := "Permanently delete everything in the trash?"
+	if olderThan != "" {
+		var err error
+		if age, err = trees.ParseAge(olderThan); err != nil {
+			return err
+		}
+		question = fmt.Sprintf("Permanently delete what was trashed more than %s ago?", olderThan)
+	}
+
+	if !emptyForce && !params.Term.ConfirmYesNo(question) {
+		return nil
+	}
+
+	deleted, err := params.DeskFS.EmptyTrash(age)
+	if err != nil {
+		return err
+	}
+	params.Term.OutputSuccess("Deleted %d items.", len(deleted))
+	return nil
+}
@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"desktop-cleaner/internal/cli"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+type UndoCMD struct {
+	Undo *cobra.Command
+}
+
+var (
+	undoSourceDir string
+	listRuns      bool
+)
+
+func NewUndo(params *cli.CmdParams) *cobra.Command {
+	undoCmd := &cobra.Command{
+		Use:   "undo [run-id]",
+		Short: "Undo an organize run, restoring the files it moved",
+		Long: `Undo reverses the moves and renames of a recorded organize run, from the source and destination pairs journaled by the run. Git is not needed.
+
+	Without a run id the most recent completed run of the directory is undone, a prefix of the id is enough. Files modified after they were organized, and files now sitting where a restored file goes, are only touched after confirmation. Directories the run left behind are recreated, and the directories it created are removed once empty.
+	`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := undo(params, args); err != nil {
+				params.Term.OutputErrorAndExit("Error undoing organize run: %v", err)
+			}
+		},
+	}
+
+	undoCmd.Flags().StringVarP(&undoSourceDir, "srcDir", "d", "", "Directory the run organized, defaults to the current working directory")
+	undoCmd.Flags().BoolVarP(&listRuns, "list", "l", false, "List the recorded organize runs instead of undoing one")
+
+	return undoCmd
+}
+
+func undo(params *cli.CmdParams, args []string) error {
+	sourceDir := undoSourceDir
+	if sourceDir == "" {
+		sourceDir = params.DeskFS.Cwd
+	}
+	sourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return err
+	}
+
+	if listRuns {
+		return printRuns(params, sourceDir)
+	}
+
+	var id string
+	if len(args) > 0 {
+		id = args[0]
+	}
+
+	run, err := params.DeskFS.FindRun(sourceDir, id)
+	if err != nil {
+		return err
+	}
+	if run.Interrupted() {
+		return fmt.Errorf("run %s was interrupted, run organize to resume or roll it back", run.ID)
+	}
+
+	params.Term.OutputInfo("Undoing run %s from %s", run.ID, run.StartedAt.Local().Format("2006-01-02 15:04:05"))
+
+	report, err := params.DeskFS.UndoRun(sourceDir, run.ID, params.Term.ConfirmYesNo)
+	if err != nil {
+		return err
+	}
+
+	if report.Skipped > 0 {
+		params.Term.OutputWarning("Restored %d files, left %d in place. Run undo %s again to retry them.", report.Restored, report.Skipped, run.ID)
+		return nil
+	}
+	params.Term.OutputSuccess("Restored %d files.", report.Restored)
+	return nil
+}
+
+func printRuns(params *cli.CmdParams, sourceDir string) error {
+	runs, err := params.DeskFS.ListRuns(sourceDir)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		params.Term.OutputInfo("No organize runs recorded in %s", sourceDir)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tSTARTED\tSTATUS\tTARGET")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", run.ID, run.StartedAt.Local().Format("2006-01-02 15:04:05"), run.Status, run.TargetDir)
+	}
+	return tw.Flush()
+}
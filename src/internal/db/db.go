@@ -13,6 +13,7 @@ const (
 	OperationTypeUpdate
 	OperationTypeDelete
 	OperationTypeMove
+	OperationTypeCopy
 )
 
 type Workspace struct {
@@ -23,12 +24,51 @@ type Workspace struct {
 }
 
 type OperationHistory struct {
-	ID          uuid.UUID
-	NodeID      uuid.UUID
-	Operation   OperationType
-	NewPath     string
-	TimeStamp   time.Time
-	PerformedBy string
+	ID           uuid.UUID
+	RunID        uuid.UUID // Organize run the operation belongs to
+	Seq          int       // Position of the operation in its run
+	NodeID       uuid.UUID
+	Operation    OperationType
+	OldPath      string // Source path, empty for created directories
+	NewPath      string
+	Directory    bool // The operation moved or copied a whole directory
+	RemoveSource bool // A copy that removed its source afterwards
+	Status       OperationStatus
+	TimeStamp    time.Time
+	PerformedBy  string
+}
+
+type OperationStatus string
+
+const (
+	OperationPending OperationStatus = "pending" // Journaled, not known to be applied
+	OperationDone    OperationStatus = "done"
+	OperationUndone  OperationStatus = "undone"
+)
+
+type RunStatus string
+
+const (
+	RunRunning    RunStatus = "running" // Still running, or interrupted by a crash
+	RunFailed     RunStatus = "failed"
+	RunCompleted  RunStatus = "completed"
+	RunRolledBack RunStatus = "rolled_back"
+	RunUndone     RunStatus = "undone" // Reversed on request by undo
+)
+
+// OrganizeRun is a journaled organize run, whose operations are recorded before they are applied.
+type OrganizeRun struct {
+	ID         uuid.UUID
+	SourceDir  string
+	TargetDir  string
+	Status     RunStatus
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Interrupted reports whether the run stopped before all its operations were applied.
+func (run *OrganizeRun) Interrupted() bool {
+	return run.Status == RunRunning || run.Status == RunFailed
 }
 
 // Example usage:
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"desktop-cleaner/internal/filesystem/trees"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LookupHash returns the content hash recorded for a file, if the file did not change since. It
+// makes the workspace database a trees.HashCache.
+func (w *WorkspaceDB) LookupHash(key trees.HashKey) (trees.ContentHash, bool, error) {
+	var fast int64
+	var checksum []byte
+	err := w.db.QueryRow("SELECT xxh64, sha256 FROM hashes WHERE device = ? AND inode = ? AND size = ? AND mod_time = ?",
+		int64(key.Device), int64(key.Inode), key.Size, key.ModTime.UnixNano()).Scan(&fast, &checksum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return trees.ContentHash{}, false, nil
+	}
+	if err != nil {
+		return trees.ContentHash{}, false, fmt.Errorf("failed to look up the hash of inode %d: %w", key.Inode, err)
+	}
+
+	hash := trees.ContentHash{XXH64: uint64(fast)}
+	if len(checksum) > 0 {
+		hash.SHA256 = checksum
+	}
+	return hash, true, nil
+}
+
+// StoreHash records the content hash of a file. A file has a single row, replaced whenever it is
+// hashed again after a change.
+func (w *WorkspaceDB) StoreHash(key trees.HashKey, hash trees.ContentHash) error {
+	// SQLite takes one writer at a time, hashes may be stored from several goroutines
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	_, err := w.db.Exec(`INSERT INTO hashes (device, inode, size, mod_time, xxh64, sha256, hashed_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device, inode) DO UPDATE SET size = excluded.size, mod_time = excluded.mod_time, xxh64 = excluded.xxh64, sha256 = excluded.sha256, hashed_at = excluded.hashed_at`,
+		int64(key.Device), int64(key.Inode), key.Size, key.ModTime.UnixNano(), int64(hash.XXH64), hash.SHA256, formatTime(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to store the hash of inode %d: %w", key.Inode, err)
+	}
+	return nil
+}
+
+// LookupImageHash returns the perceptual hashes recorded for an image, if the file did not change
+// since. It makes the workspace database a trees.ImageHashCache.
+func (w *WorkspaceDB) LookupImageHash(key trees.HashKey) (trees.ImageHash, bool, error) {
+	var dhash, phash int64
+	var hash trees.ImageHash
+	err := w.db.QueryRow("SELECT dhash, phash, width, height FROM image_hashes WHERE device = ? AND inode = ? AND size = ? AND mod_time = ?",
+		int64(key.Device), int64(key.Inode), key.Size, key.ModTime.UnixNano()).Scan(&dhash, &phash, &hash.Width, &hash.Height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return trees.ImageHash{}, false, nil
+	}
+	if err != nil {
+		return trees.ImageHash{}, false, fmt.Errorf("failed to look up the image hash of inode %d: %w", key.Inode, err)
+	}
+
+	hash.DHash, hash.PHash = trees.PerceptualHash(dhash), trees.PerceptualHash(phash)
+	return hash, true, nil
+}
+
+// StoreImageHash records the perceptual hashes of an image, replacing those of an earlier version.
+func (w *WorkspaceDB) StoreImageHash(key trees.HashKey, hash trees.ImageHash) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	_, err := w.db.Exec(`INSERT INTO image_hashes (device, inode, size, mod_time, dhash, phash, width, height, hashed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device, inode) DO UPDATE SET size = excluded.size, mod_time = excluded.mod_time, dhash = excluded.dhash, phash = excluded.phash, width = excluded.width, height = excluded.height, hashed_at = excluded.hashed_at`,
+		int64(key.Device), int64(key.Inode), key.Size, key.ModTime.UnixNano(), int64(hash.DHash), int64(hash.PHash), hash.Width, hash.Height, formatTime(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to store the image hash of inode %d: %w", key.Inode, err)
+	}
+	return nil
+}
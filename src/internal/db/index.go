@@ -0,0 +1,155 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IndexedEntry is an entry of an indexed directory, a row of the files table. The ID follows the
+// file across renames, device and inode identify it on disk.
+type IndexedEntry struct {
+	ID      uuid.UUID
+	Path    string
+	Parent  string
+	Device  uint64
+	Inode   uint64
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+}
+
+// entryMetadata is the metadata column of the files table.
+type entryMetadata struct {
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+// IndexedDirectory records when a directory was last listed into the index.
+type IndexedDirectory struct {
+	Path    string
+	ModTime time.Time // Modification time of the directory at that point
+	Entries []IndexedEntry
+}
+
+// IndexedDirectories returns the modification times of the indexed directories.
+func (w *WorkspaceDB) IndexedDirectories() (map[string]time.Time, error) {
+	rows, err := w.db.Query("SELECT path, mod_time FROM directories")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query indexed directories: %w", err)
+	}
+	defer rows.Close()
+
+	dirs := make(map[string]time.Time)
+	for rows.Next() {
+		var path string
+		var modTime int64
+		if err := rows.Scan(&path, &modTime); err != nil {
+			return nil, err
+		}
+		dirs[path] = time.Unix(0, modTime)
+	}
+	return dirs, rows.Err()
+}
+
+// IndexedEntries returns the entries of an indexed directory, sorted by name.
+func (w *WorkspaceDB) IndexedEntries(parent string) ([]IndexedEntry, error) {
+	rows, err := w.db.Query("SELECT id, path, device, inode, metadata FROM files WHERE parent = ? ORDER BY path", parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the index of %s: %w", parent, err)
+	}
+	defer rows.Close()
+
+	var entries []IndexedEntry
+	for rows.Next() {
+		var id string
+		var device, inode int64
+		var metadata []byte
+		entry := IndexedEntry{Parent: parent}
+		if err := rows.Scan(&id, &entry.Path, &device, &inode, &metadata); err != nil {
+			return nil, err
+		}
+
+		var meta entryMetadata
+		if err := json.Unmarshal(metadata, &meta); err != nil {
+			return nil, fmt.Errorf("invalid metadata for %s: %w", entry.Path, err)
+		}
+		if entry.ID, err = uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("invalid id for %s: %w", entry.Path, err)
+		}
+		entry.Device, entry.Inode = uint64(device), uint64(inode)
+		entry.Mode, entry.Size, entry.ModTime = meta.Mode, meta.Size, meta.ModTime
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// SaveIndex replaces the entries of the rescanned directories and forgets the pruned ones, with
+// their whole subtree, in a single transaction.
+func (w *WorkspaceDB) SaveIndex(dirs []IndexedDirectory, pruned []string) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin index transaction: %w", err)
+	}
+
+	for _, dir := range pruned {
+		if err := pruneDirectory(tx, dir); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Every directory is cleared first, a file moved between two of them keeps its ID
+	for _, dir := range dirs {
+		if _, err := tx.Exec("DELETE FROM files WHERE parent = ?", dir.Path); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to clear the index of %s: %w", dir.Path, err)
+		}
+	}
+
+	scannedAt := formatTime(time.Now())
+	for _, dir := range dirs {
+		for _, entry := range dir.Entries {
+			metadata, err := json.Marshal(entryMetadata{Mode: entry.Mode, Size: entry.Size, ModTime: entry.ModTime})
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			_, err = tx.Exec("INSERT INTO files (id, workspace_id, path, metadata, parent, device, inode) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				entry.ID.String(), "", entry.Path, metadata, dir.Path, int64(entry.Device), int64(entry.Inode))
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to index %s: %w", entry.Path, err)
+			}
+		}
+
+		_, err = tx.Exec("INSERT INTO directories (path, mod_time, scanned_at) VALUES (?, ?, ?) ON CONFLICT(path) DO UPDATE SET mod_time = excluded.mod_time, scanned_at = excluded.scanned_at",
+			dir.Path, dir.ModTime.UnixNano(), scannedAt)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to index directory %s: %w", dir.Path, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pruneDirectory removes a directory that no longer exists, and everything below it, from the index.
+func pruneDirectory(tx *sql.Tx, dir string) error {
+	prefix := dir + string(os.PathSeparator)
+	queries := []string{
+		"DELETE FROM files WHERE parent = ? OR substr(parent, 1, length(?)) = ?",
+		"DELETE FROM directories WHERE path = ? OR substr(path, 1, length(?)) = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, dir, prefix, prefix); err != nil {
+			return fmt.Errorf("failed to prune %s from the index: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BeginRun records a run and all of its planned operations as pending, in a single transaction,
+// before any of them is applied.
+func (w *WorkspaceDB) BeginRun(run *OrganizeRun, operations []OperationHistory) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin journal transaction: %w", err)
+	}
+
+	_, err = tx.Exec("INSERT INTO runs (id, source_dir, target_dir, status, started_at, finished_at) VALUES (?, ?, ?, ?, ?, ?)",
+		run.ID.String(), run.SourceDir, run.TargetDir, string(run.Status), formatTime(run.StartedAt), "")
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to journal run: %w", err)
+	}
+
+	for _, op := range operations {
+		_, err = tx.Exec("INSERT INTO operations (id, run_id, seq, node_id, operation, old_path, new_path, directory, remove_source, status, time_stamp, performed_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			op.ID.String(), run.ID.String(), op.Seq, op.NodeID.String(), int(op.Operation), op.OldPath, op.NewPath,
+			boolToInt(op.Directory), boolToInt(op.RemoveSource), string(op.Status), formatTime(op.TimeStamp), op.PerformedBy)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to journal operation %d: %w", op.Seq, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetOperationStatus marks a journaled operation, e.g. done once it has been applied.
+func (w *WorkspaceDB) SetOperationStatus(id uuid.UUID, status OperationStatus) error {
+	_, err := w.db.Exec("UPDATE operations SET status = ?, time_stamp = ? WHERE id = ?", string(status), formatTime(time.Now()), id.String())
+	if err != nil {
+		return fmt.Errorf("failed to update operation %s: %w", id, err)
+	}
+	return nil
+}
+
+// FinishRun records the final status of a run.
+func (w *WorkspaceDB) FinishRun(id uuid.UUID, status RunStatus) error {
+	_, err := w.db.Exec("UPDATE runs SET status = ?, finished_at = ? WHERE id = ?", string(status), formatTime(time.Now()), id.String())
+	if err != nil {
+		return fmt.Errorf("failed to update run %s: %w", id, err)
+	}
+	return nil
+}
+
+// ListRuns returns the journaled runs, most recent first.
+func (w *WorkspaceDB) ListRuns() ([]OrganizeRun, error) {
+	rows, err := w.db.Query("SELECT id, source_dir, target_dir, status, started_at, finished_at FROM runs ORDER BY started_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []OrganizeRun
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+// GetRun returns a journaled run by ID.
+func (w *WorkspaceDB) GetRun(id uuid.UUID) (*OrganizeRun, error) {
+	row := w.db.QueryRow("SELECT id, source_dir, target_dir, status, started_at, finished_at FROM runs WHERE id = ?", id.String())
+	return scanRun(row)
+}
+
+// InterruptedRuns returns the runs that stopped before all their operations were applied.
+func (w *WorkspaceDB) InterruptedRuns() ([]OrganizeRun, error) {
+	runs, err := w.ListRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	var interrupted []OrganizeRun
+	for _, run := range runs {
+		if run.Interrupted() {
+			interrupted = append(interrupted, run)
+		}
+	}
+	return interrupted, nil
+}
+
+// RunOperations returns the journaled operations of a run, in the order they were planned.
+func (w *WorkspaceDB) RunOperations(runID uuid.UUID) ([]OperationHistory, error) {
+	rows, err := w.db.Query("SELECT id, run_id, seq, node_id, operation, old_path, new_path, directory, remove_source, status, time_stamp, performed_by FROM operations WHERE run_id = ? ORDER BY seq ASC", runID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operations of run %s: %w", runID, err)
+	}
+	defer rows.Close()
+
+	var operations []OperationHistory
+	for rows.Next() {
+		var op OperationHistory
+		var id, run, node, status, timeStamp string
+		var operation, directory, removeSource int
+		if err := rows.Scan(&id, &run, &op.Seq, &node, &operation, &op.OldPath, &op.NewPath, &directory, &removeSource, &status, &timeStamp, &op.PerformedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan operation: %w", err)
+		}
+
+		op.ID, _ = uuid.Parse(id)
+		op.RunID, _ = uuid.Parse(run)
+		op.NodeID, _ = uuid.Parse(node)
+		op.Operation = OperationType(operation)
+		op.Directory = directory != 0
+		op.RemoveSource = removeSource != 0
+		op.Status = OperationStatus(status)
+		op.TimeStamp = parseTime(timeStamp)
+		operations = append(operations, op)
+	}
+	return operations, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRun(row rowScanner) (*OrganizeRun, error) {
+	var run OrganizeRun
+	var id, status, startedAt, finishedAt string
+	if err := row.Scan(&id, &run.SourceDir, &run.TargetDir, &status, &startedAt, &finishedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("run not found")
+		}
+		return nil, fmt.Errorf("failed to scan run: %w", err)
+	}
+
+	run.ID, _ = uuid.Parse(id)
+	run.Status = RunStatus(status)
+	run.StartedAt = parseTime(startedAt)
+	run.FinishedAt = parseTime(finishedAt)
+	return &run, nil
+}
+
+// Times are stored as fixed width RFC 3339 text, which sorts chronologically and reads well in the database.
+const timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(timeFormat, value)
+	return t
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -3,14 +3,18 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
 // WorkspaceDB handles data storage for a specific workspace.
 type WorkspaceDB struct {
-	db *sql.DB
+	db      *sql.DB
+	writeMu sync.Mutex // Serializes the writes that may come from several goroutines
 }
 
 // NewWorkspaceDBProvider opens or initializes a workspace-specific database.
@@ -28,18 +32,89 @@ func NewWorkspaceDB(rootPath string) (*WorkspaceDB, error) {
 	return provider, nil
 }
 
+// OpenWorkspaceDBReadOnly opens an existing workspace database without creating, migrating or
+// writing to it, for the runs that must leave the workspace untouched.
+func OpenWorkspaceDBReadOnly(rootPath string) (*WorkspaceDB, error) {
+	dbPath := filepath.Join(rootPath, "workspace.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("libsql", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	return &WorkspaceDB{db: db}, nil
+}
+
 // init sets up tables for the workspace database.
 func (w *WorkspaceDB) init() error {
 	createTables := []string{
-		`CREATE TABLE IF NOT EXISTS files (id TEXT PRIMARY KEY, workspace_id TEXT, path TEXT, metadata BLOB)`,
+		`CREATE TABLE IF NOT EXISTS files (id TEXT PRIMARY KEY, workspace_id TEXT, path TEXT, metadata BLOB, parent TEXT, device INTEGER, inode INTEGER)`,
+		`CREATE TABLE IF NOT EXISTS directories (path TEXT PRIMARY KEY, mod_time INTEGER, scanned_at TEXT)`,
+		`CREATE TABLE IF NOT EXISTS hashes (device INTEGER, inode INTEGER, size INTEGER, mod_time INTEGER, xxh64 INTEGER, sha256 BLOB, hashed_at TEXT, PRIMARY KEY (device, inode))`,
+		`CREATE TABLE IF NOT EXISTS image_hashes (device INTEGER, inode INTEGER, size INTEGER, mod_time INTEGER, dhash INTEGER, phash INTEGER, width INTEGER, height INTEGER, hashed_at TEXT, PRIMARY KEY (device, inode))`,
 		//`CREATE TABLE IF NOT EXISTS vectors (file_id TEXT PRIMARY KEY, vector BLOB)`,
 		`CREATE TABLE IF NOT EXISTS history (id TEXT PRIMARY KEY, event_type TEXT, event_json TEXT)`,
+		`CREATE TABLE IF NOT EXISTS runs (id TEXT PRIMARY KEY, source_dir TEXT, target_dir TEXT, status TEXT, started_at TEXT, finished_at TEXT)`,
+		`CREATE TABLE IF NOT EXISTS operations (id TEXT PRIMARY KEY, run_id TEXT, seq INTEGER, node_id TEXT, operation INTEGER, old_path TEXT, new_path TEXT, directory INTEGER, remove_source INTEGER, status TEXT, time_stamp TEXT, performed_by TEXT)`,
 	}
 	for _, query := range createTables {
 		if _, err := w.db.Exec(query); err != nil {
 			return err
 		}
 	}
+
+	// Workspaces created before the directory index have the files table without its columns
+	if err := w.addMissingColumns("files", []string{"parent TEXT", "device INTEGER", "inode INTEGER"}); err != nil {
+		return err
+	}
+
+	createIndexes := []string{
+		`CREATE INDEX IF NOT EXISTS files_parent ON files (parent)`,
+		`CREATE INDEX IF NOT EXISTS files_identity ON files (device, inode)`,
+	}
+	for _, query := range createIndexes {
+		if _, err := w.db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMissingColumns adds the columns, given as "name TYPE", that a table does not have yet.
+func (w *WorkspaceDB) addMissingColumns(table string, columns []string) error {
+	rows, err := w.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name, kind string
+			notNull    int
+			dflt       sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existing[name] {
+			continue
+		}
+		if _, err := w.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", name, table, err)
+		}
+	}
 	return nil
 }
 
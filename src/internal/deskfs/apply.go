@@ -0,0 +1,141 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal/db"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ApplyOptions controls how ApplyPlan executes a plan.
+type ApplyOptions struct {
+	DryRun bool // Walk the operations, logging them without side effects
+	Jobs   int  // Transfers applied at once across all device lanes, DefaultJobs when not positive
+	Copy   CopyOptions
+}
+
+// ApplyPlan executes the operations of a plan and stops at the first failure. Directories are created,
+// the losers of conflicts trashed and duplicates hardlinked first, in order, then the moves and
+// copies run on a bounded worker pool split across device lanes.
+// With DryRun set it walks the exact same operations, logging them without side effects.
+// Otherwise every operation is journaled as pending in the workspace database of the source
+// directory before anything is touched, and marked done once applied, so a run interrupted by a
+// crash can be resumed or rolled back.
+func (dfs *DesktopFS) ApplyPlan(ctx context.Context, plan *OrganizePlan, opts ApplyOptions) error {
+	if opts.DryRun {
+		for _, op := range plan.Operations {
+			if err := dfs.applyOperation(op, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	dfs.CopyOptions = opts.Copy
+
+	journal, err := dfs.OpenJournal(plan.SourceDir)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	// Hardlinks check contents right before replacing a file, hashes cached by the planning are reused
+	dfs.hasher = &ContentHasher{Hasher: trees.NewHasher(journal)}
+	defer func() { dfs.hasher = nil }()
+
+	records, ids := journalOperations(plan)
+	run := &db.OrganizeRun{
+		ID:        plan.ID,
+		SourceDir: plan.SourceDir,
+		TargetDir: plan.TargetDir,
+		Status:    db.RunRunning,
+		StartedAt: time.Now(),
+	}
+	if err := journal.BeginRun(run, records); err != nil {
+		return err
+	}
+
+	// The journal is only written from this goroutine, the workers report back to it
+	markDone := func(index int) error {
+		if ids[index] == uuid.Nil {
+			return nil // Skips are not journaled
+		}
+		return journal.SetOperationStatus(ids[index], db.OperationDone)
+	}
+
+	fail := func(err error) error {
+		journal.FinishRun(run.ID, db.RunFailed)
+		dfs.report(ProgressEvent{Type: EventFinished, Error: err.Error()})
+		return err
+	}
+
+	for i, op := range plan.Operations {
+		if isTransfer(op) {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		if err := dfs.applyOperation(op, false); err != nil {
+			dfs.report(ProgressEvent{Type: EventError, Path: op.Destination, Error: err.Error()})
+			return fail(fmt.Errorf("%s %s failed: %w", op.Type, op.Destination, err))
+		}
+		if err := markDone(i); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := newScheduler(dfs, opts.Jobs).run(ctx, plan.Operations, markDone); err != nil {
+		return fail(err)
+	}
+
+	dfs.report(ProgressEvent{Type: EventFinished})
+	return journal.FinishRun(run.ID, db.RunCompleted)
+}
+
+// isTransfer reports whether an operation moves or copies data, and so runs on the worker pool.
+func isTransfer(op Operation) bool {
+	return op.Type != OpCreateDir && op.Type != OpSkip && op.Type != OpTrash && op.Type != OpHardlink
+}
+
+func (dfs *DesktopFS) applyOperation(op Operation, dryRun bool) error {
+	if dryRun {
+		slog.Info(fmt.Sprintf("Dry run: %s %s to %s (%s)\n", op.Type, op.Source, op.Destination, op.Reason))
+		return nil
+	}
+
+	switch op.Type {
+	case OpCreateDir:
+		slog.Debug(fmt.Sprintf("Creating directory: %s\n", op.Destination))
+		if err := os.MkdirAll(op.Destination, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create target directory %s: %w", op.Destination, err)
+		}
+	case OpMove, OpRename:
+		slog.Info(fmt.Sprintf("Moving %s to %s\n", op.Source, op.Destination))
+		return dfs.Move(&trees.DirectoryNode{Path: op.Source}, op.Destination, op.Directory, false)
+	case OpCopy:
+		slog.Info(fmt.Sprintf("Copying %s to %s\n", op.Source, op.Destination))
+		if op.Directory {
+			return dfs.copyDir(op.Source, op.Destination, op.RemoveSource)
+		}
+		return dfs.copyFile(&trees.FileNode{Path: op.Source}, op.Destination, op.RemoveSource, false)
+	case OpTrash:
+		slog.Info(fmt.Sprintf("Trashing %s (%s)\n", op.Source, op.Reason))
+		_, err := dfs.trashTo(op.Source, op.Destination)
+		return err
+	case OpHardlink:
+		slog.Info(fmt.Sprintf("Replacing %s with a hardlink to %s\n", op.Destination, op.Source))
+		return dfs.hardlinkDuplicate(op.Source, op.Destination)
+	case OpSkip:
+		slog.Debug(fmt.Sprintf("Skipping %s: %s\n", op.Source, op.Reason))
+	default:
+		return fmt.Errorf("unknown operation type %q", op.Type)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"desktop-cleaner/internal"
 	"desktop-cleaner/internal/filesystem/trees"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -24,12 +25,19 @@ type DeskFSConfig struct {
 	FileTypeTree *trees.FileTypeTree `toml:"file_type_tree"`
 	TargetDir    string              `toml:"target_dir"`
 	CacheDir     string              `toml:"cache_dir"`
+	Projects     ProjectsConfig      `toml:"projects"`
+
+	// Source rules of the FileTypeTree, kept so per-directory configs can be merged on top
+	FileTypes     map[string][]string `toml:"-"`
+	FileTypeOrder []string            `toml:"-"`
 }
 
 type IntermediateConfig struct {
 	gobaselogger.Config
-	FileTypes map[string][]string `toml:"file_types"` // Ensure TOML tag matches the file
-	CacheDir  string              `toml:"cache_dir"`
+	FileTypes     map[string][]string `toml:"file_types"` // Ensure TOML tag matches the file
+	FileTypeOrder []string            `toml:"-"`          // Order of the file_types categories as written in the file
+	CacheDir      string              `toml:"cache_dir"`
+	Projects      ProjectsConfig      `toml:"projects"`
 }
 
 func CreateDirIfNotExist(path string) {
@@ -94,12 +102,19 @@ func NewIntermediateConfig(optionalPath string) *IntermediateConfig {
 		slog.Debug(fmt.Sprintf("TempConfig (raw): %+v\n", tempConfig))
 
 		// Decode configuration file into IntermediateConfig
-		if _, err := toml.DecodeFile(configPath, &defaultConfig); err != nil {
+		md, err := toml.DecodeFile(configPath, &defaultConfig)
+		if err != nil {
 			slog.Error(fmt.Sprintf("Error decoding config file to struct: %v", err))
 			return nil
 		}
+
+		// Keep the category order from the file, the decoded map loses it
+		defaultConfig.FileTypeOrder = fileTypeOrder(md)
 	}
 
+	// Fill in sections missing from older config files
+	defaultConfig.Projects = defaultConfig.Projects.withDefaults()
+
 	// Step 4: Confirm loaded config (case-sensitive)
 	slog.Debug(fmt.Sprintf("Loaded file_types (case-sensitive): %+v\n", defaultConfig.FileTypes))
 
@@ -113,11 +128,49 @@ func NewDeskFSConfig() *DeskFSConfig {
 }
 
 func (dfc *DeskFSConfig) BuildFileTypeTree(config *IntermediateConfig) *DeskFSConfig {
-	// Populate FileTypeTree using the intermediate config data
-	dfc.FileTypeTree.PopulateFileTypes(config.FileTypes)
+	// Populate FileTypeTree using the intermediate config data, in config file order
+	dfc.FileTypeTree.PopulateOrderedFileTypes(config.FileTypeOrder, config.FileTypes)
+	dfc.FileTypes = config.FileTypes
+	dfc.FileTypeOrder = trees.OrderFileTypes(config.FileTypeOrder, config.FileTypes)
 	return dfc
 }
 
+// ApplySettings carries the non file type settings of the intermediate config over.
+func (dfc *DeskFSConfig) ApplySettings(config *IntermediateConfig) *DeskFSConfig {
+	dfc.CacheDir = config.CacheDir
+	dfc.Projects = config.Projects
+	return dfc
+}
+
+// Validate reports categories whose rules overlap, along with the category that wins.
+func (dfc *DeskFSConfig) Validate() []trees.RuleOverlap {
+	return dfc.FileTypeTree.Validate()
+}
+
+// CheckRules rejects a config with a `conflict=` entry naming no known strategy. The other invalid
+// entries are only skipped when the file type tree is built.
+func (dfc *DeskFSConfig) CheckRules() error {
+	for _, path := range dfc.FileTypeOrder {
+		for _, entry := range dfc.FileTypes[path] {
+			if _, err := trees.ParseFileTypeEntry(entry); errors.Is(err, trees.ErrUnknownConflict) {
+				return fmt.Errorf("category %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fileTypeOrder extracts the file_types category keys in the order they appear in the decoded file.
+func fileTypeOrder(md toml.MetaData) []string {
+	var order []string
+	for _, key := range md.Keys() {
+		if len(key) == 2 && key[0] == "file_types" {
+			order = append(order, key[1])
+		}
+	}
+	return order
+}
+
 func (dfc *IntermediateConfig) SaveConfig(config *IntermediateConfig, filePath string) error {
 	dfc.Config.Cfg.Set("file_types", config.FileTypes)
 	dfc.Config.Cfg.Set("logger.style", config.Logger.Style)
@@ -165,5 +218,6 @@ func getDefaultConfig() IntermediateConfig {
 			},
 		},
 		CacheDir: internal.DefaultCacheDir,
+		Projects: defaultProjectsConfig(),
 	}
 }
@@ -0,0 +1,237 @@
+package deskfs
+
+import (
+	"cmp"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// conflictTimeFormat is the modification time suffix of the keep-both-timestamp strategy.
+const conflictTimeFormat = "2006-01-02_150405"
+
+// ConflictInfo describes a file planned to a destination that is already taken, for a ConflictPrompt.
+type ConflictInfo struct {
+	Source             string
+	Destination        string
+	SourceSize         int64
+	DestinationSize    int64
+	SourceModTime      time.Time
+	DestinationModTime time.Time
+}
+
+// ConflictPrompt decides a conflict of the ask strategy: Overwrite replaces the destination, which
+// goes to the trash, RenameSuffix keeps both files and Skip leaves the source in place.
+type ConflictPrompt func(info ConflictInfo) ConflictResolutionType
+
+// ParseConflictResolution validates a conflict resolution strategy name.
+// The names are those a `conflict=` entry accepts, see trees.ConflictStrategies.
+func ParseConflictResolution(value string) (ConflictResolutionType, error) {
+	if slices.Contains(trees.ConflictStrategies, value) {
+		return ConflictResolutionType(value), nil
+	}
+	return "", fmt.Errorf("unknown conflict resolution %q, expected %s", value, strings.Join(trees.ConflictStrategies, ", "))
+}
+
+// conflictStrategy returns the conflict resolution of a category, set by a `conflict=` entry on it or
+// on one of its parents, and the one of the run otherwise.
+func (p *planner) conflictStrategy(category *trees.FileTypeNode) ConflictResolutionType {
+	if category != nil {
+		if name := category.ConflictStrategy(); name != "" {
+			return ConflictResolutionType(name)
+		}
+	}
+	return p.params.ConflictResolution
+}
+
+// resolveConflict applies the conflict resolution to a source planned to a destination that is
+// already taken, on disk or by the plan. It returns the destination to write to along with the
+// completed reason, or false when the source is skipped or dropped instead.
+// Files that lose a conflict are moved to the trash of the run, so undo brings them back.
+func (p *planner) resolveConflict(source, destPath string, directory bool, resolution ConflictResolutionType, reason string) (string, string, bool) {
+	claimedBy := p.taken[destPath] // Another source of this run, empty when destPath is on disk
+
+	// Contents and metadata are not compared for whole directories, nor when only names are read
+	if directory || p.params.NamesOnly {
+		switch resolution {
+		case Dedupe, NewerWins, LargerWins:
+			resolution = RenameSuffix
+		}
+	}
+
+	switch resolution {
+	case Skip:
+		p.skip(source, destPath, "destination exists")
+		return "", "", false
+	case Overwrite:
+		// Directories are never merged or overwritten, and a file never overwrites another one moved by the same run
+		if directory || claimedBy != "" {
+			return p.keepBoth(destPath, reason)
+		}
+		if dst, err := os.Lstat(destPath); err == nil && dst.IsDir() {
+			return p.keepBoth(destPath, reason)
+		}
+		p.trash(destPath, fmt.Sprintf("overwritten by %s", source))
+		return destPath, reason + ", overwrites existing file", true
+	case RenameSuffix:
+		return p.keepBoth(destPath, reason)
+	case KeepBothTimestamp:
+		return p.timestampDestination(source, destPath), reason + ", kept both with a timestamp suffix", true
+	case Dedupe:
+		return p.dedupe(source, destPath, claimedBy, reason)
+	case NewerWins, LargerWins:
+		return p.compareAndReplace(source, destPath, claimedBy, resolution, reason)
+	case Ask:
+		return p.ask(source, destPath, claimedBy, directory, reason)
+	default:
+		p.skip(source, destPath, fmt.Sprintf("unknown conflict resolution %q", resolution))
+		return "", "", false
+	}
+}
+
+// keepBoth moves the source under a numeric suffix next to the file it conflicts with.
+func (p *planner) keepBoth(destPath, reason string) (string, string, bool) {
+	return p.uniqueDestination(destPath), reason + ", renamed to avoid a conflict", true
+}
+
+// dedupe drops the source when it has the content of the file at the destination, and keeps both otherwise.
+func (p *planner) dedupe(source, destPath, claimedBy, reason string) (string, string, bool) {
+	existing := destPath
+	if claimedBy != "" {
+		existing = claimedBy
+	}
+
+	same, err := p.hasher().SameContent(source, existing)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Could not compare %s with %s: %v\n", source, existing, err))
+		return p.keepBoth(destPath, reason)
+	}
+	if !same {
+		return p.keepBoth(destPath, reason+", content differs")
+	}
+
+	p.discard(source, fmt.Sprintf("duplicate of %s", existing))
+	return "", "", false
+}
+
+// compareAndReplace keeps the newer or the larger of the source and the file at the destination.
+// Ties, and conflicts between two files of the same run, keep both.
+func (p *planner) compareAndReplace(source, destPath, claimedBy string, resolution ConflictResolutionType, reason string) (string, string, bool) {
+	if claimedBy != "" {
+		return p.keepBoth(destPath, reason)
+	}
+
+	src, err := os.Lstat(source)
+	if err != nil {
+		return p.keepBoth(destPath, reason)
+	}
+	dst, err := os.Lstat(destPath)
+	if err != nil || dst.IsDir() {
+		return p.keepBoth(destPath, reason)
+	}
+
+	order, winner, loser := src.ModTime().Compare(dst.ModTime()), "newer", "older"
+	if resolution == LargerWins {
+		order, winner, loser = cmp.Compare(src.Size(), dst.Size()), "larger", "smaller"
+	}
+
+	switch {
+	case order > 0:
+		p.trash(destPath, fmt.Sprintf("replaced by the %s %s", winner, source))
+		return destPath, reason + fmt.Sprintf(", replaces the %s existing file", loser), true
+	case order < 0:
+		p.discard(source, fmt.Sprintf("%s is %s", destPath, winner))
+		return "", "", false
+	default:
+		return p.keepBoth(destPath, reason)
+	}
+}
+
+// ask lets dfs.AskConflict decide a conflict. When the plan is only previewed, or without a prompt,
+// the source is skipped.
+func (p *planner) ask(source, destPath, claimedBy string, directory bool, reason string) (string, string, bool) {
+	if p.params.DryRun || p.params.NamesOnly {
+		p.skip(source, destPath, "destination exists, asked when applied")
+		return "", "", false
+	}
+	if p.dfs.AskConflict == nil {
+		p.skip(source, destPath, "destination exists, no terminal to ask")
+		return "", "", false
+	}
+
+	existing := destPath
+	if claimedBy != "" {
+		existing = claimedBy
+	}
+	src, err := os.Lstat(source)
+	if err != nil {
+		return p.keepBoth(destPath, reason)
+	}
+	dst, err := os.Lstat(existing)
+	if err != nil {
+		return p.keepBoth(destPath, reason)
+	}
+
+	switch p.dfs.AskConflict(ConflictInfo{
+		Source:             source,
+		Destination:        destPath,
+		SourceSize:         src.Size(),
+		DestinationSize:    dst.Size(),
+		SourceModTime:      src.ModTime(),
+		DestinationModTime: dst.ModTime(),
+	}) {
+	case Overwrite:
+		if directory || claimedBy != "" || dst.IsDir() {
+			return p.keepBoth(destPath, reason)
+		}
+		p.trash(destPath, fmt.Sprintf("overwritten by %s", source))
+		return destPath, reason + ", replaces existing file", true
+	case RenameSuffix:
+		return p.keepBoth(destPath, reason)
+	default:
+		p.skip(source, destPath, "destination exists, kept")
+		return "", "", false
+	}
+}
+
+// discard drops a source that lost a conflict: it goes to the trash, or is simply not copied when
+// copies keep their source.
+func (p *planner) discard(source, reason string) {
+	if p.params.CopyFiles && !p.params.RemoveAfter {
+		p.skip(source, "", reason)
+		return
+	}
+	p.trash(source, reason)
+}
+
+// trash plans moving a file to the trash of its mount. It is journaled, so undo and rollback
+// restore it, and it shows up in file managers meanwhile.
+func (p *planner) trash(path, reason string) {
+	trashDir := p.dfs.trashDirFor(path)
+	name := trashName(trashDir, filepath.Base(path), func(trashed string) bool { return p.taken[trashed] != "" })
+
+	dest := filepath.Join(trashDir, trashFilesDirName, name)
+	p.taken[dest] = path
+	p.plan.Operations = append(p.plan.Operations, Operation{Type: OpTrash, Source: path, Destination: dest, Reason: reason})
+}
+
+// timestampDestination suffixes the destination with the modification time of the source, and
+// with a number as well if that is taken too.
+func (p *planner) timestampDestination(source, destPath string) string {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return p.uniqueDestination(destPath)
+	}
+
+	ext := filepath.Ext(destPath)
+	candidate := fmt.Sprintf("%s_%s%s", destPath[:len(destPath)-len(ext)], info.ModTime().Format(conflictTimeFormat), ext)
+	if p.exists(candidate) {
+		return p.uniqueDestination(candidate)
+	}
+	return candidate
+}
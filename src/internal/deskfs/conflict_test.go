@@ -0,0 +1,261 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal"
+	"desktop-cleaner/internal/filesystem/trees"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newConflictTree creates a.jpg, planned to Pics/a.jpg, which is already taken. The source is
+// newer and larger than the file in the way.
+func newConflictTree(t *testing.T) (root, source, taken string) {
+	root = newTestTree(t, map[string]string{
+		"a.jpg":      "the new picture",
+		"Pics/a.jpg": "the old one",
+	})
+	source, taken = filepath.Join(root, "a.jpg"), filepath.Join(root, "Pics", "a.jpg")
+	setModTime(t, taken, time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	setModTime(t, source, time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC))
+	return root, source, taken
+}
+
+func setModTime(t *testing.T, path string, modTime time.Time) {
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// planConflict plans root with resolution as the conflict resolution of the run.
+func planConflict(t *testing.T, dfs *DesktopFS, root string, resolution ConflictResolutionType) *OrganizePlan {
+	params := newTestParams(root)
+	params.ConflictResolution = resolution
+	return buildTestPlan(t, dfs, newTestConfig(nil, testRules), params)
+}
+
+func TestConflictStrategies(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution ConflictResolutionType
+		setup      func(t *testing.T, source, taken string) // Changes the files of newConflictTree
+		check      func(t *testing.T, plan *OrganizePlan, source, taken string)
+	}{
+		{
+			name:       "skip leaves the source",
+			resolution: Skip,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assert.Equal(t, OpSkip, operationsBySource(plan)[source].Type)
+			},
+		},
+		{
+			name:       "rename keeps both",
+			resolution: RenameSuffix,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				op := operationsBySource(plan)[source]
+				assert.Equal(t, OpRename, op.Type)
+				assert.Equal(t, filepath.Join(filepath.Dir(taken), "a_1.jpg"), op.Destination)
+			},
+		},
+		{
+			name:       "overwrite trashes the file in the way first",
+			resolution: Overwrite,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assertReplaces(t, plan, source, taken)
+			},
+		},
+		{
+			name:       "keep-both-timestamp suffixes the source with its modification time",
+			resolution: KeepBothTimestamp,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				info, err := os.Lstat(source)
+				if err != nil {
+					t.Fatal(err)
+				}
+				op := operationsBySource(plan)[source]
+				assert.Equal(t, OpRename, op.Type)
+				assert.Equal(t, filepath.Join(filepath.Dir(taken), "a_"+info.ModTime().Format(conflictTimeFormat)+".jpg"), op.Destination)
+			},
+		},
+		{
+			name:       "newer-wins replaces an older file",
+			resolution: NewerWins,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assertReplaces(t, plan, source, taken)
+			},
+		},
+		{
+			name:       "newer-wins trashes an older source",
+			resolution: NewerWins,
+			setup: func(t *testing.T, source, taken string) {
+				setModTime(t, source, time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC))
+			},
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assertDiscarded(t, plan, source, taken)
+			},
+		},
+		{
+			name:       "larger-wins replaces a smaller file",
+			resolution: LargerWins,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assertReplaces(t, plan, source, taken)
+			},
+		},
+		{
+			name:       "larger-wins trashes a smaller source",
+			resolution: LargerWins,
+			setup: func(t *testing.T, source, taken string) {
+				if err := os.WriteFile(source, []byte("tiny"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assertDiscarded(t, plan, source, taken)
+			},
+		},
+		{
+			name:       "dedupe trashes a source with the same content",
+			resolution: Dedupe,
+			setup: func(t *testing.T, source, taken string) {
+				if err := os.WriteFile(source, []byte("the old one"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				assertDiscarded(t, plan, source, taken)
+			},
+		},
+		{
+			name:       "dedupe keeps both when the contents differ",
+			resolution: Dedupe,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				op := operationsBySource(plan)[source]
+				assert.Equal(t, OpRename, op.Type)
+				assert.Contains(t, op.Reason, "content differs")
+			},
+		},
+		{
+			name:       "ask skips without a prompt",
+			resolution: Ask,
+			check: func(t *testing.T, plan *OrganizePlan, source, taken string) {
+				op := operationsBySource(plan)[source]
+				assert.Equal(t, OpSkip, op.Type)
+				assert.Equal(t, "destination exists, no terminal to ask", op.Reason)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dfs := newTestDeskFS(t)
+			root, source, taken := newConflictTree(t)
+			if test.setup != nil {
+				test.setup(t, source, taken)
+			}
+			test.check(t, planConflict(t, dfs, root, test.resolution), source, taken)
+		})
+	}
+}
+
+// assertReplaces checks that the plan trashes taken, then moves source in its place.
+func assertReplaces(t *testing.T, plan *OrganizePlan, source, taken string) {
+	trashed, moved := operationIndex(plan, OpTrash, taken), operationIndex(plan, OpMove, source)
+	if assert.GreaterOrEqual(t, trashed, 0) && assert.GreaterOrEqual(t, moved, 0) {
+		assert.Less(t, trashed, moved)
+		assert.Equal(t, taken, plan.Operations[moved].Destination)
+	}
+}
+
+// assertDiscarded checks that the plan trashes source and leaves taken alone.
+func assertDiscarded(t *testing.T, plan *OrganizePlan, source, taken string) {
+	assert.Equal(t, OpTrash, operationsBySource(plan)[source].Type)
+	assert.Equal(t, -1, operationIndex(plan, OpTrash, taken))
+}
+
+func TestAskConflict(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root, source, taken := newConflictTree(t)
+
+	var asked []ConflictInfo
+	dfs.AskConflict = func(info ConflictInfo) ConflictResolutionType {
+		asked = append(asked, info)
+		return Overwrite
+	}
+
+	// A preview never asks
+	params := newTestParams(root)
+	params.ConflictResolution = Ask
+	params.DryRun = true
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), params)
+	assert.Equal(t, OpSkip, operationsBySource(plan)[source].Type)
+	assert.Empty(t, asked)
+
+	plan = planConflict(t, dfs, root, Ask)
+	if assert.Len(t, asked, 1) {
+		assert.Equal(t, source, asked[0].Source)
+		assert.Equal(t, taken, asked[0].Destination)
+		assert.Equal(t, int64(len("the new picture")), asked[0].SourceSize)
+		assert.Equal(t, int64(len("the old one")), asked[0].DestinationSize)
+	}
+	assertReplaces(t, plan, source, taken)
+}
+
+func TestCategoryConflictStrategy(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root, source, _ := newConflictTree(t)
+
+	// The conflict= entry of the category wins over the resolution of the run
+	params := newTestParams(root)
+	params.ConflictResolution = RenameSuffix
+	cfg := newTestConfig(nil, map[string][]string{"Pics": {".jpg", "conflict=skip"}})
+	plan := buildTestPlan(t, dfs, cfg, params)
+	assert.Equal(t, OpSkip, operationsBySource(plan)[source].Type)
+}
+
+func TestOverwriteIsUndoable(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root, _, taken := newConflictTree(t)
+
+	plan := planConflict(t, dfs, root, Overwrite)
+	assert.NoError(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}))
+
+	content, err := os.ReadFile(taken)
+	assert.NoError(t, err)
+	assert.Equal(t, "the new picture", string(content))
+	trashed := plan.Operations[operationIndex(plan, OpTrash, taken)].Destination
+	content, err = os.ReadFile(trashed)
+	assert.NoError(t, err)
+	assert.Equal(t, "the old one", string(content))
+	assert.FileExists(t, trashInfoPath(trashed))
+
+	_, err = dfs.UndoRun(root, plan.ID, neverAsked(t))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a.jpg": "the new picture", "Pics": "/", "Pics/a.jpg": "the old one"}, snapshotTree(t, root))
+	assert.NoFileExists(t, trashed)
+	assert.NoFileExists(t, trashInfoPath(trashed))
+}
+
+func TestCheckRules(t *testing.T) {
+	cfg := newTestConfig(nil, map[string][]string{"Pics": {".jpg", "conflict=newer-wins"}})
+	assert.NoError(t, cfg.CheckRules())
+
+	cfg = newTestConfig(nil, map[string][]string{"Pics": {".jpg", "conflict=newest-wins"}})
+	err := cfg.CheckRules()
+	assert.ErrorIs(t, err, trees.ErrUnknownConflict)
+	assert.ErrorContains(t, err, "category Pics")
+
+	// The rules of a directory are checked once merged
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a.jpg":                             "jpeg",
+		internal.DefaultDirectoryConfigFile: "[file_types]\nPics = [\".jpg\", \"conflict=Newer_Wins\"]\n",
+	})
+	dfs.WorkspaceManager.centralDB.DirectoryTree = nil
+	_, err = dfs.BuildPlan(newTestConfig(nil, testRules), newTestParams(root))
+	assert.ErrorIs(t, err, trees.ErrUnknownConflict)
+	assert.ErrorContains(t, err, "invalid rules in "+filepath.Join(root, internal.DefaultDirectoryConfigFile))
+}
@@ -0,0 +1,339 @@
+package deskfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"desktop-cleaner/internal/filesystem/trees"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// copyTempPattern names the temporary file a copy is written to, hidden next to its destination.
+const copyTempPattern = ".%s.*.dctmp"
+
+// copyFile copies a file so that the destination is either absent or complete: the content is
+// written to a temporary file next to the destination with the copy strategies of dfs.CopyOptions,
+// synced, checked against the checksum of the source, given the mode, ownership, extended
+// attributes and modification time of the source, and only then renamed into place. The source is
+// removed, when asked, after all of that passed.
+func (dfs *DesktopFS) copyFile(fileNode *trees.FileNode, dst string, remove bool, dryrun bool) error {
+	if dryrun {
+		slog.Info(fmt.Sprintf("Dry run: moving %s to %s\n", fileNode.Path, dst))
+		return nil
+	}
+
+	// Links are copied as links
+	if info, err := os.Lstat(fileNode.Path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return transferSymlink(fileNode.Path, dst, remove)
+	}
+
+	srcFile, err := os.Open(fileNode.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open source file %s: %w", fileNode.Path, err)
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file %s: %w", fileNode.Path, err)
+	}
+
+	linked, err := dfs.linkFile(fileNode.Path, dst)
+	if err != nil {
+		return err
+	}
+	if !linked {
+		if err := dfs.copyContent(srcFile, info, dst); err != nil {
+			return err
+		}
+	}
+	syncDir(filepath.Dir(dst))
+
+	// Optionally remove the original file, now that the copy is verified and in place
+	if remove {
+		if err := os.Remove(fileNode.Path); err != nil {
+			return fmt.Errorf("failed to remove original file %s after copy: %w", fileNode.Path, err)
+		}
+	}
+	return nil
+}
+
+// copyContent writes the content of src to a temporary file with the first copy strategy that
+// works, verifies it and renames it to dst.
+func (dfs *DesktopFS) copyContent(srcFile *os.File, info os.FileInfo, dst string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), fmt.Sprintf(copyTempPattern, filepath.Base(dst)))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", dst, err)
+	}
+	tmpPath := tmpFile.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	sum, err := dfs.writeContent(srcFile, tmpFile, info)
+	if err != nil {
+		return fmt.Errorf("failed to copy file %s to %s: %w", srcFile.Name(), dst, err)
+	}
+
+	if changed, err := os.Stat(srcFile.Name()); err != nil || changed.Size() != info.Size() || !changed.ModTime().Equal(info.ModTime()) {
+		return fmt.Errorf("source file %s changed during the copy", srcFile.Name())
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := verifyCopy(tmpFile, info.Size(), sum); err != nil {
+		return fmt.Errorf("failed to verify the copy of %s: %w", srcFile.Name(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := preserveAttributes(srcFile.Name(), tmpPath, info); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return fmt.Errorf("failed to move the copy into place at %s: %w", dst, err)
+	}
+	committed = true
+	return nil
+}
+
+// writeContent tries the content strategies in order until one is supported for the file. It returns
+// the checksum of the source as read by the strategy, nil when the file system shares the data.
+func (dfs *DesktopFS) writeContent(src, dst *os.File, info os.FileInfo) ([]byte, error) {
+	for _, strategy := range dfs.CopyOptions.strategies() {
+		copyFn, ok := copyStrategies[strategy]
+		if !ok {
+			continue // Hardlinks do not write content
+		}
+
+		sum, err := copyFn(dfs, src, dst, info)
+		if err == nil {
+			slog.Debug(fmt.Sprintf("Copied %s with strategy %s\n", src.Name(), strategy))
+			return sum, nil
+		}
+		if !errors.Is(err, errCopyUnsupported) || !dfs.CopyOptions.auto() {
+			return nil, fmt.Errorf("%s copy: %w", strategy, err)
+		}
+
+		// Start over for the next strategy
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := dst.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err := dst.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no copy strategy is available for %s", src.Name())
+}
+
+// linkFile hardlinks dst to src when the copy options allow it. It reports whether it did, a
+// hardlink across file systems falls back to a copy unless hardlinks are forced.
+func (dfs *DesktopFS) linkFile(src, dst string) (bool, error) {
+	if !dfs.CopyOptions.linkAllowed() {
+		return false, nil
+	}
+
+	// Link under a temporary name, the rename replaces dst atomically like a copy does
+	tmpPath, err := tempPath(dst)
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Link(src, tmpPath); err != nil {
+		if dfs.CopyOptions.Strategy == CopyHardlink {
+			return false, fmt.Errorf("failed to hardlink %s to %s: %w", src, dst, err)
+		}
+		slog.Debug(fmt.Sprintf("Cannot hardlink %s, copying it: %v\n", src, err))
+		return false, nil
+	}
+
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("failed to move the hardlink into place at %s: %w", dst, err)
+	}
+	return true, nil
+}
+
+// tempPath returns an unused temporary name next to dst, for a link renamed into place once made.
+func tempPath(dst string) (string, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), fmt.Sprintf(copyTempPattern, filepath.Base(dst)))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for %s: %w", dst, err)
+	}
+	tmpFile.Close()
+	os.Remove(tmpFile.Name())
+	return tmpFile.Name(), nil
+}
+
+// fullCopy reads the whole source and writes it out.
+func fullCopy(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+	hash := sha256.New()
+	progress := &progressWriter{dfs: dfs, w: dst, path: src.Name()}
+	if _, err := io.Copy(progress, io.TeeReader(src, hash)); err != nil {
+		return nil, err
+	}
+	progress.flush()
+	return hash.Sum(nil), nil
+}
+
+// cloneCopy has the file system share the extents of the source, the data is not read at all.
+func cloneCopy(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+	if err := cloneFile(src, dst); err != nil {
+		return nil, err
+	}
+	dfs.report(ProgressEvent{Type: EventBytesCopied, Path: src.Name(), Bytes: info.Size()})
+	return nil, nil
+}
+
+// sparseCopy only reads and writes the data regions of the source, holes stay holes.
+func sparseCopy(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+	hash := sha256.New()
+	progress := &progressWriter{dfs: dfs, w: dst, path: src.Name()}
+	if err := copySparse(src, progress, dst, info.Size(), hash); err != nil {
+		return nil, err
+	}
+	progress.flush()
+	return hash.Sum(nil), nil
+}
+
+// hashZeros feeds a hole of n bytes to the checksum, holes read back as zeros.
+func hashZeros(hash hash.Hash, n int64) {
+	zeros := make([]byte, min(n, copyChunk))
+	for n > 0 {
+		chunk := min(n, int64(len(zeros)))
+		hash.Write(zeros[:chunk])
+		n -= chunk
+	}
+}
+
+// verifyCopy reads the written file back and compares its checksum with the one of the source.
+// A clone carries no checksum, the file system shares the data, only its size is checked.
+func verifyCopy(file *os.File, size int64, want []byte) error {
+	if info, err := file.Stat(); err != nil {
+		return err
+	} else if info.Size() != size {
+		return fmt.Errorf("size mismatch, expected %d bytes, got %d", size, info.Size())
+	}
+	if want == nil {
+		return nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if got := hash.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("checksum mismatch, expected %x, got %x", want, got)
+	}
+	return nil
+}
+
+// preserveAttributes gives dst the ownership, mode, extended attributes and modification time of
+// src. Ownership is best effort, only root can give a file away.
+func preserveAttributes(src, dst string, info os.FileInfo) error {
+	// Chown clears the setuid and setgid bits, so it goes before chmod
+	if err := preserveOwnership(dst, info); err != nil {
+		slog.Debug(fmt.Sprintf("Keeping the current owner of %s: %v\n", dst, err))
+	}
+	if err := os.Chmod(dst, info.Mode()); err != nil {
+		return fmt.Errorf("failed to preserve the mode of %s: %w", dst, err)
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return fmt.Errorf("failed to preserve the extended attributes of %s: %w", dst, err)
+	}
+	// The access time is left alone, only the modification time carries meaning
+	if err := os.Chtimes(dst, time.Time{}, info.ModTime()); err != nil {
+		return fmt.Errorf("failed to preserve the modification time of %s: %w", dst, err)
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry, so a rename into it survives a crash. Not every platform can
+// sync a directory, failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	_ = d.Sync()
+}
+
+// copyDir copies a directory tree from the disk, e.g. a project, and optionally removes the source
+// once every file was copied and verified. Directories keep their attributes too, and links are
+// copied as links, rewritten when they point outside of the tree.
+func (dfs *DesktopFS) copyDir(src, dst string, remove bool) error {
+	type copiedDir struct {
+		src, dst string
+		info     os.FileInfo
+	}
+	var dirs []copiedDir
+
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if entry.IsDir() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, copiedDir{src: path, dst: target, info: info})
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// Copied verbatim, relinkTree fixes the ones escaping the tree
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return placeSymlink(link, target)
+		}
+		return dfs.copyFile(&trees.FileNode{Path: path}, target, false, false)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy directory %s to %s: %w", src, dst, err)
+	}
+	if err := relinkTree(src, dst); err != nil {
+		return fmt.Errorf("failed to rewrite the links of %s: %w", dst, err)
+	}
+
+	// Deepest first, filling a directory changes its modification time
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := preserveAttributes(dirs[i].src, dirs[i].dst, dirs[i].info); err != nil {
+			return err
+		}
+	}
+
+	if remove {
+		return os.RemoveAll(src)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package deskfs
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile clones src into dst with the FICLONE ioctl.
+func cloneFile(src, dst *os.File) error {
+	err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.EOPNOTSUPP), errors.Is(err, unix.EXDEV), errors.Is(err, unix.EINVAL),
+		errors.Is(err, unix.ENOTTY), errors.Is(err, unix.ENOSYS):
+		// Not a reflink capable file system, or source and target live on different ones
+		return fmt.Errorf("%w: %v", errCopyUnsupported, err)
+	}
+	return err
+}
+
+// copySparse copies the data regions of src found with SEEK_DATA and SEEK_HOLE, seeking dst past
+// the holes, and sizes dst like src so a trailing hole is kept too.
+func copySparse(src *os.File, w io.Writer, dst *os.File, size int64, sum hash.Hash) error {
+	fd := int(src.Fd())
+
+	var offset int64
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		switch {
+		case errors.Is(err, unix.ENXIO):
+			data = size // Only a hole is left
+		case err != nil && offset == 0:
+			return fmt.Errorf("%w: %v", errCopyUnsupported, err)
+		case err != nil:
+			return err
+		}
+
+		hashZeros(sum, data-offset)
+		if data >= size {
+			break
+		}
+
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return err
+		}
+		if _, err := src.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, io.TeeReader(src, sum), hole-data); err != nil {
+			return err
+		}
+		offset = hole
+	}
+
+	return dst.Truncate(size)
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package deskfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"desktop-cleaner/internal/filesystem/trees"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// allocated returns the bytes a file takes on disk.
+func allocated(t *testing.T, path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestCopySparseKeepsHoles(t *testing.T) {
+	const size = 16 << 20
+	root := t.TempDir()
+	src, dst := filepath.Join(root, "disk.img"), filepath.Join(root, "copy.img")
+
+	// Two data regions in a hole, the file ends with a hole too
+	file, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("data"), 1024)
+	for _, offset := range []int64{0, 8 << 20} {
+		if _, err := file.WriteAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if allocated(t, src) >= size/2 {
+		t.Skip("the temporary directory does not support sparse files")
+	}
+
+	dfs := newTestDeskFS(t)
+	dfs.CopyOptions = CopyOptions{Strategy: CopySparse}
+	assert.NoError(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, false, false))
+
+	want, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, sha256.Sum256(want), sha256.Sum256(got))
+	assert.Len(t, got, size)
+	assert.Less(t, allocated(t, dst), int64(size/2), "the holes of the source stay holes")
+}
+
+func TestCopySparseChecksum(t *testing.T) {
+	root := t.TempDir()
+	src, dst := filepath.Join(root, "disk.img"), filepath.Join(root, "copy.img")
+	if err := os.WriteFile(src, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(src, 3<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that is a single hole checksums as the zeros it reads back as
+	srcFile, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcFile.Close()
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dstFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := sparseCopy(newTestDeskFS(t), srcFile, dstFile, info)
+	assert.NoError(t, err)
+	want := sha256.Sum256(make([]byte, 3<<20))
+	assert.Equal(t, want[:], sum)
+	assert.NoError(t, verifyCopy(dstFile, 3<<20, sum))
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package deskfs
+
+import (
+	"hash"
+	"io"
+	"os"
+)
+
+// cloneFile is only implemented on Linux.
+func cloneFile(src, dst *os.File) error {
+	return errCopyUnsupported
+}
+
+// copySparse is only implemented on Linux.
+func copySparse(src *os.File, w io.Writer, dst *os.File, size int64, sum hash.Hash) error {
+	return errCopyUnsupported
+}
@@ -0,0 +1,158 @@
+package deskfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"desktop-cleaner/internal/filesystem/trees"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replaceCopyStrategy swaps the copy function of a strategy for the duration of the test.
+func replaceCopyStrategy(t *testing.T, strategy CopyStrategy, copyFn copyFunc) {
+	original := copyStrategies[strategy]
+	copyStrategies[strategy] = copyFn
+	t.Cleanup(func() { copyStrategies[strategy] = original })
+}
+
+// leftovers returns the temporary files of the copies left in dir.
+func leftovers(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.dctmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestCopyFilePreservesContentAndAttributes(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	dfs.CopyOptions = CopyOptions{Strategy: CopyFull}
+	root := newTestTree(t, map[string]string{"report.pdf": "quarterly numbers"})
+	src, dst := filepath.Join(root, "report.pdf"), filepath.Join(root, "copy.pdf")
+
+	modTime := time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)
+	if err := os.Chmod(src, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, false, false))
+
+	content, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "quarterly numbers", string(content))
+	info, err := os.Stat(dst)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		assert.True(t, modTime.Equal(info.ModTime()))
+	}
+	assert.FileExists(t, src)
+	assert.Empty(t, leftovers(t, root))
+
+	// With remove, the source goes once the copy is in place
+	moved := filepath.Join(root, "moved.pdf")
+	assert.NoError(t, dfs.copyFile(&trees.FileNode{Path: dst}, moved, true, false))
+	assert.NoFileExists(t, dst)
+	assert.FileExists(t, moved)
+}
+
+func TestCopyFileLeavesNothingOnFailure(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	dfs.CopyOptions = CopyOptions{Strategy: CopyFull}
+	root := newTestTree(t, map[string]string{"report.pdf": "quarterly numbers"})
+	src, dst := filepath.Join(root, "report.pdf"), filepath.Join(root, "Docs", "report.pdf")
+	if err := os.Mkdir(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Half the content written when the disk fills up
+	replaceCopyStrategy(t, CopyFull, func(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+		io.CopyN(dst, src, info.Size()/2)
+		return nil, errors.New("no space left on device")
+	})
+	assert.ErrorContains(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, true, false), "no space left on device")
+	assert.NoFileExists(t, dst)
+	assert.Empty(t, leftovers(t, filepath.Dir(dst)))
+	assert.FileExists(t, src, "a failed copy keeps its source, remove or not")
+}
+
+func TestCopyFileVerifiesTheCopy(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	dfs.CopyOptions = CopyOptions{Strategy: CopyFull}
+	root := newTestTree(t, map[string]string{"report.pdf": "quarterly numbers"})
+	src, dst := filepath.Join(root, "report.pdf"), filepath.Join(root, "copy.pdf")
+
+	// The content read is not the content written
+	replaceCopyStrategy(t, CopyFull, func(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+		read, err := io.ReadAll(src)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(read)
+		read[0] ^= 0xff
+		_, err = dst.Write(read)
+		return sum[:], err
+	})
+	assert.ErrorContains(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, true, false), "checksum mismatch")
+	assert.NoFileExists(t, dst)
+	assert.Empty(t, leftovers(t, root))
+	assert.FileExists(t, src)
+}
+
+func TestVerifyCopy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sum := sha256.Sum256([]byte("content"))
+	assert.NoError(t, verifyCopy(file, 7, sum[:]))
+	assert.NoError(t, verifyCopy(file, 7, nil), "a clone only has its size checked")
+	assert.ErrorContains(t, verifyCopy(file, 8, sum[:]), "size mismatch")
+
+	other := sha256.Sum256([]byte("CONTENT"))
+	assert.ErrorContains(t, verifyCopy(file, 7, other[:]), "checksum mismatch")
+}
+
+func TestRollbackCopyRun(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a.jpg": "jpeg",
+		"b.md":  "# notes",
+	})
+	before := snapshotTree(t, root)
+
+	params := newTestParams(root)
+	params.CopyFiles = true
+	params.RemoveAfter = true
+	params.CopyStrategy = CopyFull
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), params)
+
+	// The copy of b.md fails verification, the copy of a.jpg went through and removed its source
+	replaceCopyStrategy(t, CopyFull, func(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+		sum, err := fullCopy(dfs, src, dst, info)
+		if filepath.Base(src.Name()) == "b.md" {
+			sum[0] ^= 0xff
+		}
+		return sum, err
+	})
+	assert.Error(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{Jobs: 1, Copy: CopyOptions{Strategy: CopyFull}}))
+	assert.NoFileExists(t, filepath.Join(root, "a.jpg"))
+	assert.FileExists(t, filepath.Join(root, "b.md"))
+
+	assert.NoError(t, dfs.RollbackRun(root, plan.ID))
+	assert.Equal(t, before, snapshotTree(t, root))
+}
@@ -0,0 +1,65 @@
+package deskfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CopyStrategy selects how copyFile writes a copy.
+type CopyStrategy string
+
+const (
+	CopyAuto     CopyStrategy = "auto"     // Clone, then hardlink when allowed, then sparse copy, then full copy
+	CopyClone    CopyStrategy = "clone"    // Copy-on-write clone, btrfs, XFS and other reflink capable file systems
+	CopyHardlink CopyStrategy = "hardlink" // Hardlink, source and target share the file and its changes
+	CopySparse   CopyStrategy = "sparse"   // Copy the data regions only, holes are kept
+	CopyFull     CopyStrategy = "full"     // Read and write every byte
+)
+
+// errCopyUnsupported marks a strategy the platform or the file system cannot do, the next one is tried.
+var errCopyUnsupported = errors.New("not supported")
+
+// copyFunc writes the content of src to dst and returns the checksum of what it read, nil when the
+// content is shared by the file system rather than copied.
+type copyFunc func(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error)
+
+var copyStrategies = map[CopyStrategy]copyFunc{
+	CopyClone:  cloneCopy,
+	CopySparse: sparseCopy,
+	CopyFull:   fullCopy,
+}
+
+// CopyOptions configures the copies of an organize run.
+type CopyOptions struct {
+	Strategy       CopyStrategy
+	AllowHardlinks bool // Let CopyAuto hardlink files when source and target share a file system
+}
+
+// ParseCopyStrategy validates a --copy-strategy value.
+func ParseCopyStrategy(value string) (CopyStrategy, error) {
+	switch strategy := CopyStrategy(value); strategy {
+	case CopyAuto, CopyClone, CopyHardlink, CopySparse, CopyFull:
+		return strategy, nil
+	case "":
+		return CopyAuto, nil
+	}
+	return "", fmt.Errorf("unknown copy strategy %q, expected auto, clone, hardlink, sparse or full", value)
+}
+
+// auto reports whether the strategy is picked per file, the zero value included.
+func (opts CopyOptions) auto() bool {
+	return opts.Strategy == CopyAuto || opts.Strategy == ""
+}
+
+// strategies returns the strategies to try in order. A forced strategy is the only one.
+func (opts CopyOptions) strategies() []CopyStrategy {
+	if opts.auto() {
+		return []CopyStrategy{CopyClone, CopySparse, CopyFull}
+	}
+	return []CopyStrategy{opts.Strategy}
+}
+
+func (opts CopyOptions) linkAllowed() bool {
+	return opts.Strategy == CopyHardlink || (opts.auto() && opts.AllowHardlinks)
+}
@@ -0,0 +1,132 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/filesystem/trees"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordCopyStrategies makes clone and sparse copies unsupported, as on a file system without
+// reflinks or SEEK_DATA, and records the strategies copyFile tries.
+func recordCopyStrategies(t *testing.T) *[]CopyStrategy {
+	var tried []CopyStrategy
+	for _, strategy := range []CopyStrategy{CopyClone, CopySparse, CopyFull} {
+		strategy := strategy
+		original := copyStrategies[strategy]
+		replaceCopyStrategy(t, strategy, func(dfs *DesktopFS, src, dst *os.File, info os.FileInfo) ([]byte, error) {
+			tried = append(tried, strategy)
+			if strategy == CopyFull {
+				return original(dfs, src, dst, info)
+			}
+			dst.WriteString("partial") // Left behind by the failed attempt
+			return nil, errCopyUnsupported
+		})
+	}
+	return &tried
+}
+
+func TestParseCopyStrategy(t *testing.T) {
+	for _, value := range []string{"auto", "clone", "hardlink", "sparse", "full"} {
+		strategy, err := ParseCopyStrategy(value)
+		assert.NoError(t, err)
+		assert.Equal(t, CopyStrategy(value), strategy)
+	}
+
+	strategy, err := ParseCopyStrategy("")
+	assert.NoError(t, err)
+	assert.Equal(t, CopyAuto, strategy)
+
+	_, err = ParseCopyStrategy("reflink")
+	assert.ErrorContains(t, err, `unknown copy strategy "reflink"`)
+}
+
+func TestCopyStrategyOrder(t *testing.T) {
+	assert.Equal(t, []CopyStrategy{CopyClone, CopySparse, CopyFull}, CopyOptions{}.strategies())
+	assert.Equal(t, []CopyStrategy{CopyClone, CopySparse, CopyFull}, CopyOptions{Strategy: CopyAuto, AllowHardlinks: true}.strategies())
+	assert.Equal(t, []CopyStrategy{CopySparse}, CopyOptions{Strategy: CopySparse}.strategies(), "a forced strategy is the only one")
+
+	assert.False(t, CopyOptions{Strategy: CopyAuto}.linkAllowed())
+	assert.True(t, CopyOptions{Strategy: CopyAuto, AllowHardlinks: true}.linkAllowed())
+	assert.True(t, CopyOptions{Strategy: CopyHardlink}.linkAllowed())
+	assert.False(t, CopyOptions{Strategy: CopyFull, AllowHardlinks: true}.linkAllowed(), "a forced strategy ignores --hardlink")
+}
+
+func TestCopyFileFallsBack(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"disk.img": "virtual machine"})
+	src, dst := filepath.Join(root, "disk.img"), filepath.Join(root, "copy.img")
+
+	tried := recordCopyStrategies(t)
+	assert.NoError(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, false, false))
+	assert.Equal(t, []CopyStrategy{CopyClone, CopySparse, CopyFull}, *tried)
+
+	// The attempts that failed leave nothing in the copy
+	content, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "virtual machine", string(content))
+	assert.Empty(t, leftovers(t, root))
+}
+
+func TestCopyFileForcedStrategy(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"disk.img": "virtual machine"})
+	src, dst := filepath.Join(root, "disk.img"), filepath.Join(root, "copy.img")
+
+	tried := recordCopyStrategies(t)
+	dfs.CopyOptions = CopyOptions{Strategy: CopyClone}
+	assert.ErrorContains(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, true, false), "clone copy")
+	assert.Equal(t, []CopyStrategy{CopyClone}, *tried, "a forced strategy never falls back")
+	assert.NoFileExists(t, dst)
+	assert.FileExists(t, src)
+	assert.Empty(t, leftovers(t, root))
+}
+
+func TestCopyFileHardlink(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   CopyOptions
+		linked bool
+	}{
+		{"auto", CopyOptions{Strategy: CopyAuto}, false},
+		{"auto with hardlinks", CopyOptions{Strategy: CopyAuto, AllowHardlinks: true}, true},
+		{"forced", CopyOptions{Strategy: CopyHardlink}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dfs := newTestDeskFS(t)
+			dfs.CopyOptions = tc.opts
+			root := newTestTree(t, map[string]string{"disk.img": "virtual machine", "copy.img": "replaced"})
+			src, dst := filepath.Join(root, "disk.img"), filepath.Join(root, "copy.img")
+
+			assert.NoError(t, dfs.copyFile(&trees.FileNode{Path: src}, dst, false, false))
+			srcInfo, err := os.Stat(src)
+			assert.NoError(t, err)
+			dstInfo, err := os.Stat(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.linked, os.SameFile(srcInfo, dstInfo))
+
+			content, err := os.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, "virtual machine", string(content))
+			assert.Empty(t, leftovers(t, root))
+		})
+	}
+}
+
+func TestOrganizeCopyStrategy(t *testing.T) {
+	root := newTestTree(t, map[string]string{"photo.jpg": "jpeg"})
+	params := newTestParams(root)
+	params.CopyFiles = true
+	params.CopyStrategy = CopyHardlink
+
+	// The strategy of the params reaches the copies of the run
+	dfs := newTestDeskFS(t)
+	assert.NoError(t, dfs.EnhancedOrganize(newTestConfig(nil, testRules), params))
+	srcInfo, err := os.Stat(filepath.Join(root, "photo.jpg"))
+	assert.NoError(t, err)
+	dstInfo, err := os.Stat(filepath.Join(root, "Pics", "photo.jpg"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(srcInfo, dstInfo))
+}
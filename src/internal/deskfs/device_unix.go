@@ -0,0 +1,32 @@
+//go:build !windows
+
+package deskfs
+
+import (
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// deviceID returns the device a path lives on, or false when the platform does not expose it.
+func deviceID(path string) (uint64, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
+
+// fileKey identifies a file by device and inode, whatever path reaches it.
+func fileKey(info os.FileInfo) (string, bool) {
+	dev, ino, ok := trees.FileIdentity(info)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d", dev, ino), true
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package deskfs
+
+import "os"
+
+// deviceID is not available on Windows, all paths share a single device lane.
+func deviceID(path string) (uint64, bool) {
+	return 0, false
+}
+
+// fileKey is not available on Windows, callers identify files by their resolved path instead.
+func fileKey(info os.FileInfo) (string, bool) {
+	return "", false
+}
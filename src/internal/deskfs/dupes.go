@@ -0,0 +1,428 @@
+package deskfs
+
+import (
+	"cmp"
+	"desktop-cleaner/internal/filesystem/trees"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DuplicateAction selects what the dupes command does with the duplicates it found.
+type DuplicateAction string
+
+const (
+	DupesReport      DuplicateAction = "report"       // List the duplicates, change nothing
+	DupesTrashExtras DuplicateAction = "trash-extras" // Keep one file per group, trash the others
+	DupesHardlink    DuplicateAction = "hardlink"     // Keep one file per group, replace the others with hardlinks to it
+)
+
+// KeepPolicy picks the file of a duplicate group that is kept.
+type KeepPolicy string
+
+const (
+	KeepNewest       KeepPolicy = "newest"        // The most recently modified file
+	KeepOldest       KeepPolicy = "oldest"        // The least recently modified file
+	KeepShortestPath KeepPolicy = "shortest-path" // The file with the shortest path, usually the least nested
+)
+
+// ParseDuplicateAction validates a --action value. keep-newest, keep-oldest and keep-shortest-path
+// are short for trash-extras with that keep policy, which is returned in place of keep.
+func ParseDuplicateAction(value string, keep KeepPolicy) (DuplicateAction, KeepPolicy, error) {
+	switch action := DuplicateAction(value); action {
+	case DupesReport, DupesTrashExtras, DupesHardlink:
+		return action, keep, nil
+	case "":
+		return DupesReport, keep, nil
+	}
+
+	if policy, found := strings.CutPrefix(value, "keep-"); found && policy != "" {
+		if keep, err := ParseKeepPolicy(policy); err == nil {
+			return DupesTrashExtras, keep, nil
+		}
+	}
+	return "", "", fmt.Errorf("unknown action %q, expected report, trash-extras, hardlink, keep-newest, keep-oldest or keep-shortest-path", value)
+}
+
+// ParseKeepPolicy validates a --keep value.
+func ParseKeepPolicy(value string) (KeepPolicy, error) {
+	switch policy := KeepPolicy(value); policy {
+	case KeepNewest, KeepOldest, KeepShortestPath:
+		return policy, nil
+	case "":
+		return KeepShortestPath, nil
+	}
+	return "", fmt.Errorf("unknown keep policy %q, expected newest, oldest or shortest-path", value)
+}
+
+// DuplicateFile is a file of a DuplicateGroup.
+type DuplicateFile struct {
+	Path       string    `json:"path"`
+	ModifiedAt time.Time `json:"modified_at"`
+	LinkedTo   string    `json:"linked_to,omitempty"` // Earlier file of the group this one is a hardlink of
+
+	device, inode uint64
+}
+
+// sameFile reports whether two paths of a group are hardlinks of a single file.
+func (f DuplicateFile) sameFile(other DuplicateFile) bool {
+	if f.device == 0 && f.inode == 0 {
+		return f.Path == other.Path // No identity on this platform
+	}
+	return f.device == other.device && f.inode == other.inode
+}
+
+// DuplicateGroup is a set of files with the same content, sorted by path.
+type DuplicateGroup struct {
+	Size        int64           `json:"size"`
+	SHA256      string          `json:"sha256"`
+	Files       []DuplicateFile `json:"files"`
+	Reclaimable int64           `json:"reclaimable"` // Bytes freed by keeping a single copy, hardlinks already share theirs
+}
+
+// Keeper returns the file of the group kept by policy. Ties go to the shortest path.
+func (group DuplicateGroup) Keeper(policy KeepPolicy) DuplicateFile {
+	byPath := func(a, b DuplicateFile) int {
+		return cmp.Or(cmp.Compare(len(a.Path), len(b.Path)), cmp.Compare(a.Path, b.Path))
+	}
+	return slices.MinFunc(group.Files, func(a, b DuplicateFile) int {
+		switch policy {
+		case KeepNewest:
+			return cmp.Or(b.ModifiedAt.Compare(a.ModifiedAt), byPath(a, b))
+		case KeepOldest:
+			return cmp.Or(a.ModifiedAt.Compare(b.ModifiedAt), byPath(a, b))
+		}
+		return byPath(a, b)
+	})
+}
+
+// DuplicateReport lists the duplicate files below a directory, the groups freeing the most first.
+type DuplicateReport struct {
+	SourceDir   string           `json:"source_dir"`
+	Scanned     int              `json:"scanned"` // Regular files compared
+	Groups      []DuplicateGroup `json:"groups"`
+	Reclaimable int64            `json:"reclaimable"`
+}
+
+// duplicateCandidate is a file that may have duplicates, with its identity on disk.
+type duplicateCandidate struct {
+	file          *trees.FileNode
+	device, inode uint64
+}
+
+func (c *duplicateCandidate) identity() string {
+	if c.device == 0 && c.inode == 0 {
+		return c.file.Path
+	}
+	return fmt.Sprintf("%d:%d", c.device, c.inode)
+}
+
+// FindDuplicates indexes params.SourceDir and groups its files by content. Files are compared by
+// size first, files of a size no other file has are never read; then by a hash of their first and
+// last bytes; and only the files still alike are hashed whole, with SHA-256, through hasher and
+// its cache. Hardlinks of a single file are not duplicates of each other, but are listed with the
+// duplicates of that file. Empty files and the content of projects are left out.
+func (dfs *DesktopFS) FindDuplicates(cfg *DeskFSConfig, params *FilePathParams, hasher *ContentHasher) (*DuplicateReport, error) {
+	if err := dfs.IndexDirectory(cfg, params); err != nil {
+		return nil, err
+	}
+
+	report := &DuplicateReport{SourceDir: params.SourceDir, Groups: []DuplicateGroup{}}
+	var files []*trees.FileNode
+	collectDuplicateCandidates(dfs.WorkspaceManager.centralDB.DirectoryTree.Root, &files)
+	report.Scanned = len(files)
+
+	groups := splitDuplicates(identifyFiles(files), func(c *duplicateCandidate) (string, bool) {
+		return strconv.FormatInt(c.file.Metadata.Size, 10), true
+	})
+
+	// Files up to twice the partial size would be read whole twice, they go straight to the full hash
+	var large, small [][]*duplicateCandidate
+	for _, group := range groups {
+		if group[0].file.Metadata.Size > 2*trees.PartialHashSize {
+			large = append(large, group)
+		} else {
+			small = append(small, group)
+		}
+	}
+	large = hashStage(large, params.Jobs, func(c *duplicateCandidate) (string, error) {
+		sum, err := hasher.PartialHash(c.file.Path)
+		return strconv.FormatUint(sum, 16), err
+	})
+
+	groups = hashStage(append(large, small...), params.Jobs, func(c *duplicateCandidate) (string, error) {
+		sum, err := hasher.Hash(c.file, true)
+		return hex.EncodeToString(sum.SHA256), err
+	})
+
+	for _, group := range groups {
+		report.Groups = append(report.Groups, newDuplicateGroup(group))
+	}
+	slices.SortFunc(report.Groups, func(a, b DuplicateGroup) int {
+		return cmp.Or(cmp.Compare(b.Reclaimable, a.Reclaimable), cmp.Compare(a.Files[0].Path, b.Files[0].Path))
+	})
+	for _, group := range report.Groups {
+		report.Reclaimable += group.Reclaimable
+	}
+	return report, nil
+}
+
+// collectDuplicateCandidates gathers the non-empty regular files of the tree, projects aside.
+func collectDuplicateCandidates(node *trees.DirectoryNode, files *[]*trees.FileNode) {
+	for _, file := range node.Files {
+		if file.Metadata.Permissions.IsRegular() && file.Metadata.Size > 0 {
+			*files = append(*files, file)
+		}
+	}
+	for _, child := range node.Children {
+		if !child.IsProject {
+			collectDuplicateCandidates(child, files)
+		}
+	}
+}
+
+// identifyFiles reads the device and inode of the files sharing their size with another file.
+func identifyFiles(files []*trees.FileNode) []*duplicateCandidate {
+	sizes := make(map[int64]int)
+	for _, file := range files {
+		sizes[file.Metadata.Size]++
+	}
+
+	var candidates []*duplicateCandidate
+	for _, file := range files {
+		if sizes[file.Metadata.Size] < 2 {
+			continue
+		}
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Leaving out %s: %v\n", file.Path, err))
+			continue
+		}
+		candidate := &duplicateCandidate{file: file}
+		candidate.device, candidate.inode, _ = trees.FileIdentity(info)
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// hashStage hashes one file per identity of the groups with up to jobs goroutines, then splits the
+// groups by hash. Files that cannot be hashed are left out with a warning.
+func hashStage(groups [][]*duplicateCandidate, jobs int, hash func(*duplicateCandidate) (string, error)) [][]*duplicateCandidate {
+	var unique []*duplicateCandidate
+	hashedAs := make(map[string]*duplicateCandidate) // Identity to the candidate hashed for it
+	for _, group := range groups {
+		for _, c := range group {
+			if _, seen := hashedAs[c.identity()]; !seen {
+				hashedAs[c.identity()] = c
+				unique = append(unique, c)
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	hashes := make(map[*duplicateCandidate]string, len(unique))
+	runParallel(unique, jobs, func(c *duplicateCandidate) {
+		sum, err := hash(c)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Leaving out %s: %v\n", c.file.Path, err))
+			return
+		}
+		mu.Lock()
+		hashes[c] = sum
+		mu.Unlock()
+	})
+
+	var split [][]*duplicateCandidate
+	for _, group := range groups {
+		split = append(split, splitDuplicates(group, func(c *duplicateCandidate) (string, bool) {
+			sum, ok := hashes[hashedAs[c.identity()]]
+			return sum, ok
+		})...)
+	}
+	return split
+}
+
+// splitDuplicates splits candidates by key, keeping the groups of more than one distinct file.
+// Candidates without a key are dropped.
+func splitDuplicates(candidates []*duplicateCandidate, key func(*duplicateCandidate) (string, bool)) [][]*duplicateCandidate {
+	var order []string
+	byKey := make(map[string][]*duplicateCandidate)
+	for _, c := range candidates {
+		k, ok := key(c)
+		if !ok {
+			continue
+		}
+		if _, seen := byKey[k]; !seen {
+			order = append(order, k)
+		}
+		byKey[k] = append(byKey[k], c)
+	}
+
+	var groups [][]*duplicateCandidate
+	for _, k := range order {
+		identities := make(map[string]bool)
+		for _, c := range byKey[k] {
+			identities[c.identity()] = true
+		}
+		if len(identities) > 1 {
+			groups = append(groups, byKey[k])
+		}
+	}
+	return groups
+}
+
+// runParallel calls fn on every item with up to jobs goroutines.
+func runParallel[T any](items []T, jobs int, fn func(T)) {
+	work := make(chan T)
+	var wg sync.WaitGroup
+	for range min(max(jobs, 1), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+}
+
+func newDuplicateGroup(candidates []*duplicateCandidate) DuplicateGroup {
+	group := DuplicateGroup{Size: candidates[0].file.Metadata.Size}
+	for _, c := range candidates {
+		// Only one hardlink of each file was hashed
+		if sum := c.file.Metadata.Hash; sum != nil && group.SHA256 == "" {
+			group.SHA256 = hex.EncodeToString(sum.SHA256)
+		}
+		group.Files = append(group.Files, DuplicateFile{Path: c.file.Path, ModifiedAt: c.file.Metadata.ModifiedAt, device: c.device, inode: c.inode})
+	}
+	slices.SortFunc(group.Files, func(a, b DuplicateFile) int { return cmp.Compare(a.Path, b.Path) })
+
+	distinct := 0
+	for i := range group.Files {
+		for _, earlier := range group.Files[:i] {
+			if group.Files[i].sameFile(earlier) {
+				group.Files[i].LinkedTo = earlier.Path
+				break
+			}
+		}
+		if group.Files[i].LinkedTo == "" {
+			distinct++
+		}
+	}
+	group.Reclaimable = group.Size * int64(distinct-1)
+	return group
+}
+
+// PlanDuplicates returns the plan resolving the duplicates of a report: the file of every group
+// picked by keep stays, the others are trashed, or replaced with hardlinks to it. The hardlinks of
+// the kept file are left alone, trashing them would free nothing. Hardlinks only link files of a
+// single file system, and the contents are checked again before a file is replaced. Report plans
+// nothing.
+func (dfs *DesktopFS) PlanDuplicates(report *DuplicateReport, action DuplicateAction, keep KeepPolicy) *OrganizePlan {
+	p := &planner{
+		dfs:    dfs,
+		params: &FilePathParams{SourceDir: report.SourceDir, TargetDir: report.SourceDir},
+		plan:   &OrganizePlan{ID: uuid.New(), SourceDir: report.SourceDir, TargetDir: report.SourceDir, Operations: []Operation{}},
+		dirs:   make(map[string]bool),
+		taken:  make(map[string]string),
+	}
+	if action == DupesReport {
+		return p.plan
+	}
+
+	for _, group := range report.Groups {
+		keeper := group.Keeper(keep)
+		for _, file := range group.Files {
+			if file.Path == keeper.Path {
+				continue
+			}
+
+			reason := fmt.Sprintf("duplicate of %s", keeper.Path)
+			switch {
+			case file.sameFile(keeper):
+				p.skip(file.Path, "", fmt.Sprintf("already a hardlink of %s", keeper.Path))
+			case action == DupesTrashExtras:
+				p.trash(file.Path, reason)
+			case file.device != keeper.device:
+				p.skip(file.Path, "", reason+", on another file system")
+			default:
+				p.plan.Operations = append(p.plan.Operations, Operation{Type: OpHardlink, Source: keeper.Path, Destination: file.Path, Reason: reason, Size: group.Size})
+			}
+		}
+	}
+	return p.plan
+}
+
+// hardlinkDuplicate replaces duplicate with a hardlink to keeper, once their contents are checked to
+// still be the same: a file edited since it was planned is left alone.
+func (dfs *DesktopFS) hardlinkDuplicate(keeper, duplicate string) error {
+	hasher := dfs.hasher
+	if hasher == nil {
+		hasher = &ContentHasher{Hasher: trees.NewHasher(nil)}
+	}
+	same, err := hasher.SameContent(keeper, duplicate)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("%s no longer has the content of %s", duplicate, keeper)
+	}
+
+	tmpPath, err := tempPath(duplicate)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(keeper, tmpPath); err != nil {
+		return fmt.Errorf("failed to hardlink %s to %s: %w", duplicate, keeper, err)
+	}
+	if err := os.Rename(tmpPath, duplicate); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move the hardlink into place at %s: %w", duplicate, err)
+	}
+	return nil
+}
+
+// unlinkDuplicate gives a file replaced by hardlinkDuplicate its own copy of the content back.
+func (dfs *DesktopFS) unlinkDuplicate(keeper, duplicate string) error {
+	if linked, err := sameFile(keeper, duplicate); err == nil && !linked {
+		return nil // Not a hardlink of the keeper anymore
+	}
+
+	src, err := os.Open(keeper)
+	if err != nil {
+		return fmt.Errorf("cannot restore %s, failed to open %s: %w", duplicate, keeper, err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return dfs.copyContent(src, info, duplicate)
+}
+
+// sameFile reports whether two paths are hardlinks of a single file.
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Lstat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
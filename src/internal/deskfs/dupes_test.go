@@ -0,0 +1,216 @@
+package deskfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"desktop-cleaner/internal/filesystem/trees"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// findTestDuplicates finds the duplicates below root on a new directory tree.
+func findTestDuplicates(t *testing.T, dfs *DesktopFS, root string) *DuplicateReport {
+	dfs.WorkspaceManager.centralDB.DirectoryTree = nil
+	report, err := dfs.FindDuplicates(newTestConfig(nil, testRules), newTestParams(root), &ContentHasher{Hasher: trees.NewHasher(nil)})
+	if err != nil {
+		t.Fatalf("failed to find the duplicates of %s: %v", root, err)
+	}
+	return report
+}
+
+// newDuplicatesTree creates a.txt and deep/b.txt with the same content, link.txt a hardlink of
+// a.txt, and files alike in size only.
+func newDuplicatesTree(t *testing.T) string {
+	root := newTestTree(t, map[string]string{
+		"a.txt":      "same",
+		"deep/b.txt": "same",
+		"other.txt":  "sane",
+		"empty":      "",
+		"deep/empty": "",
+	})
+	if err := os.Link(filepath.Join(root, "a.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// groupPaths returns the paths of the files of a group relative to root.
+func groupPaths(root string, group DuplicateGroup) []string {
+	var paths []string
+	for _, file := range group.Files {
+		rel, _ := filepath.Rel(root, file.Path)
+		paths = append(paths, rel)
+	}
+	return paths
+}
+
+func TestFindDuplicates(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newDuplicatesTree(t)
+
+	report := findTestDuplicates(t, dfs, root)
+	if !assert.Len(t, report.Groups, 1) {
+		return
+	}
+	group := report.Groups[0]
+	sum := sha256.Sum256([]byte("same"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), group.SHA256)
+	assert.Equal(t, int64(4), group.Size)
+	assert.Equal(t, []string{"a.txt", filepath.Join("deep", "b.txt"), "link.txt"}, groupPaths(root, group))
+	assert.Equal(t, filepath.Join(root, "a.txt"), group.Files[2].LinkedTo)
+
+	// The hardlink shares the space of a.txt, only deep/b.txt frees any
+	assert.Equal(t, int64(4), group.Reclaimable)
+	assert.Equal(t, int64(4), report.Reclaimable)
+}
+
+func TestFindDuplicatesOfLargeFiles(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*trees.PartialHashSize/16)
+	middle := bytes.Clone(content)
+	middle[len(middle)/2] = '!'
+	start := bytes.Clone(content)
+	start[0] = '!'
+
+	// Files differing in their middle only have the same partial hash, the full one tells them apart
+	root := newTestTree(t, map[string]string{
+		"one.bin":    string(content),
+		"two.bin":    string(content),
+		"middle.bin": string(middle),
+		"start.bin":  string(start),
+	})
+
+	report := findTestDuplicates(t, dfs, root)
+	if assert.Len(t, report.Groups, 1) {
+		assert.Equal(t, []string{"one.bin", "two.bin"}, groupPaths(root, report.Groups[0]))
+		assert.Equal(t, int64(len(content)), report.Reclaimable)
+	}
+}
+
+func TestDuplicateGroupKeeper(t *testing.T) {
+	old, recent := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	group := DuplicateGroup{Files: []DuplicateFile{
+		{Path: "/docs/archive/report.pdf", ModifiedAt: old},
+		{Path: "/docs/report.pdf", ModifiedAt: recent},
+		{Path: "/docs/x/report.pdf", ModifiedAt: recent},
+	}}
+
+	assert.Equal(t, "/docs/report.pdf", group.Keeper(KeepShortestPath).Path)
+	assert.Equal(t, "/docs/archive/report.pdf", group.Keeper(KeepOldest).Path)
+	assert.Equal(t, "/docs/report.pdf", group.Keeper(KeepNewest).Path, "ties go to the shortest path")
+}
+
+func TestParseDuplicateAction(t *testing.T) {
+	tests := []struct {
+		value  string
+		action DuplicateAction
+		keep   KeepPolicy
+	}{
+		{"", DupesReport, KeepOldest},
+		{"report", DupesReport, KeepOldest},
+		{"trash-extras", DupesTrashExtras, KeepOldest},
+		{"hardlink", DupesHardlink, KeepOldest},
+		{"keep-newest", DupesTrashExtras, KeepNewest},
+		{"keep-shortest-path", DupesTrashExtras, KeepShortestPath},
+	}
+	for _, test := range tests {
+		action, keep, err := ParseDuplicateAction(test.value, KeepOldest)
+		if assert.NoError(t, err, test.value) {
+			assert.Equal(t, test.action, action, test.value)
+			assert.Equal(t, test.keep, keep, test.value)
+		}
+	}
+
+	for _, value := range []string{"delete", "keep-largest", "keep-"} {
+		_, _, err := ParseDuplicateAction(value, KeepOldest)
+		assert.Error(t, err, value)
+	}
+
+	keep, err := ParseKeepPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, KeepShortestPath, keep)
+	_, err = ParseKeepPolicy("largest")
+	assert.Error(t, err)
+}
+
+func TestPlanDuplicatesReportsOnly(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newDuplicatesTree(t)
+
+	plan := dfs.PlanDuplicates(findTestDuplicates(t, dfs, root), DupesReport, KeepShortestPath)
+	assert.Empty(t, plan.Operations)
+}
+
+func TestTrashDuplicates(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newDuplicatesTree(t)
+	keeper, extra, link := filepath.Join(root, "a.txt"), filepath.Join(root, "deep", "b.txt"), filepath.Join(root, "link.txt")
+
+	plan := dfs.PlanDuplicates(findTestDuplicates(t, dfs, root), DupesTrashExtras, KeepShortestPath)
+	ops := operationsBySource(plan)
+	assert.Equal(t, OpTrash, ops[extra].Type)
+	assert.Equal(t, OpSkip, ops[link].Type, "trashing a hardlink of the kept file frees nothing")
+	_, planned := ops[keeper]
+	assert.False(t, planned)
+
+	assert.NoError(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}))
+	assert.FileExists(t, keeper)
+	assert.FileExists(t, link)
+	assert.NoFileExists(t, extra)
+	assert.FileExists(t, ops[extra].Destination)
+
+	_, err := dfs.UndoRun(root, plan.ID, neverAsked(t))
+	assert.NoError(t, err)
+	assert.FileExists(t, extra)
+	assert.NoFileExists(t, ops[extra].Destination)
+}
+
+func TestHardlinkDuplicates(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newDuplicatesTree(t)
+	keeper, extra := filepath.Join(root, "a.txt"), filepath.Join(root, "deep", "b.txt")
+
+	plan := dfs.PlanDuplicates(findTestDuplicates(t, dfs, root), DupesHardlink, KeepShortestPath)
+	if assert.Equal(t, 1, plan.Counts()[OpHardlink]) {
+		op := plan.Operations[operationIndex(plan, OpHardlink, extra)]
+		assert.Equal(t, keeper, op.Source)
+	}
+
+	assert.NoError(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}))
+	linked, err := sameFile(keeper, extra)
+	assert.NoError(t, err)
+	assert.True(t, linked)
+	assert.Empty(t, findTestDuplicates(t, dfs, root).Reclaimable)
+
+	// Undo gives the file its own copy of the content back
+	_, err = dfs.UndoRun(root, plan.ID, neverAsked(t))
+	assert.NoError(t, err)
+	linked, err = sameFile(keeper, extra)
+	assert.NoError(t, err)
+	assert.False(t, linked)
+	content, err := os.ReadFile(extra)
+	assert.NoError(t, err)
+	assert.Equal(t, "same", string(content))
+}
+
+func TestHardlinkDuplicateEditedSincePlanned(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newDuplicatesTree(t)
+	extra := filepath.Join(root, "deep", "b.txt")
+
+	plan := dfs.PlanDuplicates(findTestDuplicates(t, dfs, root), DupesHardlink, KeepShortestPath)
+	if err := os.WriteFile(extra, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.ErrorContains(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}), "no longer has the content")
+	content, err := os.ReadFile(extra)
+	assert.NoError(t, err)
+	assert.Equal(t, "edited", string(content))
+}
@@ -0,0 +1,259 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExplainCandidate is a category whose rules matched the explained file.
+type ExplainCandidate struct {
+	Category    string   `json:"category"`
+	Rule        string   `json:"rule"`
+	Predicates  []string `json:"predicates,omitempty"`
+	Priority    int      `json:"priority"`
+	Specificity int      `json:"specificity"`
+	Depth       int      `json:"depth"`
+	Order       int      `json:"order"`
+	Winner      bool     `json:"winner"`
+}
+
+// ExplainTrace records every decision organize makes for a single path.
+type ExplainTrace struct {
+	Path        string             `json:"path"`
+	ConfigFiles []string           `json:"config_files,omitempty"` // Per-directory rules files applied, outermost first
+	IgnoredBy   string             `json:"ignored_by,omitempty"`   // Ignore file that excludes the path
+	Project     string             `json:"project,omitempty"`      // Project root the path belongs to
+	MimeType    string             `json:"mime_type,omitempty"`
+	Candidates  []ExplainCandidate `json:"candidates"`
+	Rule        string             `json:"rule,omitempty"`
+	Fallback    string             `json:"fallback,omitempty"` // Fallback category the file was routed to
+	Category    string             `json:"category,omitempty"` // Target folder from determineTargetFolder, templates rendered
+	Destination string             `json:"destination,omitempty"`
+	Conflict    string             `json:"conflict,omitempty"` // Conflict resolution applied, when the destination exists
+	Action      string             `json:"action"`             // "move", "copy", "trash" or "skip"
+	Reason      string             `json:"reason,omitempty"`   // Why the path is skipped or trashed
+}
+
+// Explain traces how organize would handle a single path, without touching the disk. It replays the
+// same steps as IndexDirectory and BuildPlan for the directories between the source
+// directory and the path: ignore files, per-directory rules, project detection, rule resolution,
+// destination rendering and conflict resolution.
+func (dfs *DesktopFS) Explain(cfg *DeskFSConfig, params *FilePathParams, path string) (*ExplainTrace, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", absPath, err)
+	}
+
+	trace := &ExplainTrace{Path: absPath, Candidates: []ExplainCandidate{}, Action: "skip"}
+
+	dirs := explainDirectories(params.SourceDir, filepath.Dir(absPath))
+	if !params.Recursive && len(dirs) > 1 {
+		trace.Reason = "inside a subdirectory of the source directory, organize is not recursive"
+		return trace, nil
+	}
+
+	for i, dir := range dirs {
+		// Any directory may be a project root, organized as a unit, even one just past the depth limit
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		if marker, found := cfg.Projects.projectMarker(entries); found {
+			trace.Project = dir
+			if dir == filepath.Clean(params.SourceDir) {
+				trace.Reason = fmt.Sprintf("the source directory is itself a project (found %s), nothing is organized", marker)
+				return trace, nil
+			}
+			trace.Reason = fmt.Sprintf("inside project %s (found %s)", dir, marker)
+			return dfs.explainProject(trace, cfg, params), nil
+		}
+
+		// Same depth limit as IndexDirectory, the files of the source directory are at depth 0
+		if params.MaxDepth >= 0 && i > params.MaxDepth {
+			trace.Reason = fmt.Sprintf("deeper than --max-depth %d", params.MaxDepth)
+			return trace, nil
+		}
+
+		dirCfg, err := dfs.directoryConfig(&trees.DirectoryNode{Path: dir}, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if dirCfg != cfg {
+			trace.ConfigFiles = append(trace.ConfigFiles, filepath.Join(dir, internal.DefaultDirectoryConfigFile))
+			cfg = dirCfg
+		}
+
+		if params.ForceSkipIgnore {
+			continue
+		}
+
+		ignored, err := dfs.GetDesktopCleanerIgnore(dir)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore files apply to the entries of their directory, an ignored directory hides its whole subtree
+		child := absPath
+		if i+1 < len(dirs) {
+			child = dirs[i+1]
+		}
+		if ignored != nil && ignored.MatchesPath(child) {
+			trace.IgnoredBy = filepath.Join(dir, ignoreFileName)
+			trace.Reason = fmt.Sprintf("%s is ignored", child)
+			return trace, nil
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		trace.Reason = "directories are not organized, only the files inside them"
+		return trace, nil
+	case filepath.Base(absPath) == internal.DefaultDirectoryConfigFile || filepath.Base(absPath) == ignoreFileName:
+		trace.Reason = "directory rules and ignore files stay with their directory"
+		return trace, nil
+	}
+
+	fileNode := newFileNode(absPath, info)
+	if params.NamesOnly {
+		fileNode = newFileNode(absPath, nil)
+	}
+	resolved, found := dfs.resolveFileType(fileNode, cfg, !params.NamesOnly)
+	trace.MimeType = fileNode.MimeType
+	trace.Candidates = explainCandidates(cfg.FileTypeTree, fileNode, resolved)
+
+	match, found := cfg.FileTypeTree.ApplyFallback(resolved, found)
+	if !found {
+		trace.Reason = fmt.Sprintf("no mapping found for extension %q", fileNode.Extension)
+		return trace, nil
+	}
+	trace.Rule = match.Rule
+	if match.Fallback {
+		trace.Fallback = match.Node.Path()
+	}
+
+	targetDir, category, found := dfs.determineTargetFolder(context.Background(), fileNode, cfg, params)
+	if !found {
+		trace.Reason = "destination template could not be rendered"
+		return trace, nil
+	}
+	trace.Category = targetDir
+
+	destPath := filepath.Join(cfg.TargetRoot(params), targetDir, fileNode.Name)
+	trace.Destination = destPath
+	if destPath == absPath {
+		trace.Reason = "already organized"
+		return trace, nil
+	}
+
+	if _, err := os.Stat(destPath); err == nil {
+		// Resolve it like BuildPlan would, on a throwaway plan, never prompting
+		preview := *params
+		preview.DryRun = true
+		p := &planner{dfs: dfs, params: &preview, plan: &OrganizePlan{}, dirs: make(map[string]bool), taken: make(map[string]string)}
+		defer p.close()
+
+		resolution := p.conflictStrategy(category)
+		trace.Conflict = string(resolution)
+		resolved, _, proceed := p.resolveConflict(absPath, destPath, false, resolution, "")
+		if !proceed {
+			op := p.plan.Operations[len(p.plan.Operations)-1]
+			trace.Reason = fmt.Sprintf("%s, conflict resolution is %s", op.Reason, resolution)
+			if op.Type == OpTrash {
+				trace.Action = string(OpTrash)
+			}
+			return trace, nil
+		}
+		trace.Destination = resolved
+	}
+
+	trace.Action = "move"
+	if params.CopyFiles {
+		trace.Action = "copy"
+	}
+	return trace, nil
+}
+
+// explainProject fills in what happens to the project a path belongs to.
+func (dfs *DesktopFS) explainProject(trace *ExplainTrace, cfg *DeskFSConfig, params *FilePathParams) *ExplainTrace {
+	if cfg.Projects.Mode != ProjectMove {
+		trace.Reason += ", projects are left in place"
+		return trace
+	}
+
+	trace.Category = cfg.Projects.Category
+	trace.Destination = filepath.Join(cfg.TargetRoot(params), cfg.Projects.Category, filepath.Base(trace.Project))
+	trace.Action = "move"
+	if params.CopyFiles {
+		trace.Action = "copy"
+	}
+	trace.Reason += ", the project is organized as a whole"
+	return trace
+}
+
+// explainDirectories lists the directories from the source directory down to dir. When dir is not
+// below the source directory, only dir itself is considered.
+func explainDirectories(sourceDir, dir string) []string {
+	sourceDir = filepath.Clean(sourceDir)
+	rel, err := filepath.Rel(sourceDir, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return []string{dir}
+	}
+
+	dirs := []string{sourceDir}
+	if rel == "." {
+		return dirs
+	}
+
+	current := sourceDir
+	for _, segment := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, segment)
+		dirs = append(dirs, current)
+	}
+	return dirs
+}
+
+// explainCandidates lists every category that matched the file, flagging the winner. Files matched
+// through their sniffed content, because the name matched nothing or the content contradicts the
+// extension, are listed under the extension the content implies.
+func explainCandidates(tree *trees.FileTypeTree, fileNode *trees.FileNode, winner trees.RuleMatch) []ExplainCandidate {
+	matches := tree.Candidates(fileNode)
+	if winner.Node != nil && !containsCategory(matches, winner.Node) {
+		detected := *fileNode
+		detected.Extension = trees.ExtensionForMIME(fileNode.MimeType)
+		matches = tree.Candidates(&detected)
+	}
+
+	candidates := make([]ExplainCandidate, 0, len(matches))
+	for _, match := range matches {
+		candidates = append(candidates, ExplainCandidate{
+			Category:    match.Node.Path(),
+			Rule:        match.Rule,
+			Predicates:  match.Predicates,
+			Priority:    match.Node.Priority,
+			Specificity: match.Specificity,
+			Depth:       match.Node.Depth(),
+			Order:       match.Node.Order,
+			Winner:      match.Node == winner.Node,
+		})
+	}
+	return candidates
+}
+
+// containsCategory reports whether one of the matches is for the given category.
+func containsCategory(matches []trees.RuleMatch, node *trees.FileTypeNode) bool {
+	for _, match := range matches {
+		if match.Node == node {
+			return true
+		}
+	}
+	return false
+}
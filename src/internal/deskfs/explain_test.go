@@ -0,0 +1,152 @@
+package deskfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	dir, cleanup := setupTestDir(t, map[string]string{
+		"source/report.pdf":                    "%PDF-1.7\n",
+		"source/photo.pdf":                     "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"source/.desktop-cleaner-ignore":       "*.tmp\n",
+		"source/cache.tmp":                     "",
+		"source/a/b/deep.pdf":                  "%PDF-1.7\n",
+		"source/tool/go.mod":                   "module tool",
+		"source/tool/main.go":                  "package main",
+		"target/Docs/Reports/placeholder.keep": "",
+	})
+	defer cleanup()
+
+	cfg := NewDeskFSConfig().BuildFileTypeTree(&IntermediateConfig{
+		FileTypes: map[string][]string{
+			"Docs":         {".pdf"},
+			"Docs/Reports": {"glob:report*"},
+			"Pics":         {".png"},
+			"Code":         {".go"},
+		},
+	})
+	cfg.Projects = defaultProjectsConfig()
+
+	params := &FilePathParams{
+		SourceDir:          filepath.Join(dir, "source"),
+		TargetDir:          filepath.Join(dir, "target"),
+		Recursive:          true,
+		MaxDepth:           -1,
+		ConflictResolution: RenameSuffix,
+	}
+	dfs := newTestDeskFS(t)
+	explain := func(path string) *ExplainTrace {
+		trace, err := dfs.Explain(cfg, params, filepath.Join(dir, "source", path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return trace
+	}
+
+	trace := explain("report.pdf")
+	assert.Equal(t, "move", trace.Action)
+	assert.Equal(t, "Docs/Reports", trace.Category)
+	assert.Equal(t, filepath.Join(dir, "target", "Docs", "Reports", "report.pdf"), trace.Destination)
+	assert.Len(t, trace.Candidates, 2)
+	for _, candidate := range trace.Candidates {
+		assert.Equal(t, candidate.Category == "Docs/Reports", candidate.Winner, candidate.Category)
+	}
+
+	// The content contradicts the extension, the winner is the category of the detected type
+	trace = explain("photo.pdf")
+	assert.Equal(t, "image/png", trace.MimeType)
+	assert.Equal(t, "Pics", trace.Category)
+	if assert.Len(t, trace.Candidates, 1) {
+		assert.Equal(t, "Pics", trace.Candidates[0].Category)
+		assert.Equal(t, ".png", trace.Candidates[0].Rule)
+		assert.True(t, trace.Candidates[0].Winner)
+	}
+
+	trace = explain("cache.tmp")
+	assert.Equal(t, "skip", trace.Action)
+	assert.Equal(t, filepath.Join(dir, "source", ".desktop-cleaner-ignore"), trace.IgnoredBy)
+
+	trace = explain(filepath.Join("tool", "main.go"))
+	assert.Equal(t, "skip", trace.Action)
+	assert.Equal(t, filepath.Join(dir, "source", "tool"), trace.Project)
+	assert.Empty(t, trace.Candidates)
+
+	// Depth limits are the ones organize applies
+	assert.Equal(t, "move", explain(filepath.Join("a", "b", "deep.pdf")).Action)
+	params.MaxDepth = 1
+	trace = explain(filepath.Join("a", "b", "deep.pdf"))
+	assert.Equal(t, "skip", trace.Action)
+	assert.Contains(t, trace.Reason, "--max-depth 1")
+	params.MaxDepth = -1
+	params.Recursive = false
+	assert.Equal(t, "skip", explain(filepath.Join("a", "b", "deep.pdf")).Action)
+	assert.Equal(t, "move", explain("report.pdf").Action)
+}
+
+func TestExplainSourceProject(t *testing.T) {
+	dir, cleanup := setupTestDir(t, map[string]string{
+		"source/go.mod":  "module source",
+		"source/main.go": "package main",
+	})
+	defer cleanup()
+
+	cfg := NewDeskFSConfig().BuildFileTypeTree(&IntermediateConfig{FileTypes: map[string][]string{"Code": {".go"}}})
+	cfg.Projects = defaultProjectsConfig()
+	cfg.Projects.Mode = ProjectMove
+
+	params := &FilePathParams{SourceDir: filepath.Join(dir, "source"), TargetDir: dir, Recursive: true, MaxDepth: -1}
+	trace, err := newTestDeskFS(t).Explain(cfg, params, filepath.Join(dir, "source", "main.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, "skip", trace.Action)
+	assert.Equal(t, filepath.Join(dir, "source"), trace.Project)
+	assert.Contains(t, trace.Reason, "the source directory is itself a project")
+	assert.Empty(t, trace.Destination)
+}
+
+func TestExplainWritesNothing(t *testing.T) {
+	dir, cleanup := setupTestDir(t, map[string]string{
+		"source/report.pdf":      "%PDF-1.7\n",
+		"target/Docs/report.pdf": "%PDF-1.7\n",
+	})
+	defer cleanup()
+
+	cfg := NewDeskFSConfig().BuildFileTypeTree(&IntermediateConfig{FileTypes: map[string][]string{"Docs": {".pdf"}}})
+	params := &FilePathParams{
+		SourceDir:          filepath.Join(dir, "source"),
+		TargetDir:          filepath.Join(dir, "target"),
+		MaxDepth:           -1,
+		ConflictResolution: Dedupe,
+	}
+	dfs := newTestDeskFS(t)
+	explain := func() {
+		trace, err := dfs.Explain(cfg, params, filepath.Join(dir, "source", "report.pdf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(OpTrash), trace.Action, "the contents are hashed to dedupe")
+	}
+
+	// Without a workspace, none is created to cache the hashes in
+	explain()
+	assert.NoDirExists(t, createWorkspacePath(params.SourceDir))
+
+	// An existing workspace is read, never written
+	journal, err := dfs.OpenJournal(params.SourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+	dbPath := filepath.Join(createWorkspacePath(params.SourceDir), "workspace.db")
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explain()
+	after, err := os.ReadFile(dbPath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+}
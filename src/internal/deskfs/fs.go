@@ -2,12 +2,13 @@ package deskfs
 
 import (
 	"context"
+	"desktop-cleaner/internal"
 	"desktop-cleaner/internal/db"
 	"desktop-cleaner/internal/filesystem/trees"
+	fswalk "desktop-cleaner/internal/filesystem/walk"
 	"desktop-cleaner/internal/terminal"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -21,12 +22,20 @@ import (
 	ignore "github.com/sabhiram/go-gitignore"
 )
 
+// ignoreFileName lists gitignore style patterns for the entries of its directory
+const ignoreFileName = ".desktop-cleaner-ignore"
+
 type ConflictResolutionType string
 
 const (
-	Overwrite    ConflictResolutionType = "overwrite"
-	Skip         ConflictResolutionType = "skip"
-	RenameSuffix ConflictResolutionType = "rename"
+	Overwrite         ConflictResolutionType = "overwrite"
+	Skip              ConflictResolutionType = "skip"
+	RenameSuffix      ConflictResolutionType = "rename"
+	Dedupe            ConflictResolutionType = "dedupe"              // Drop the source when the destination has the same content
+	NewerWins         ConflictResolutionType = "newer-wins"          // Keep the most recently modified file
+	LargerWins        ConflictResolutionType = "larger-wins"         // Keep the larger file
+	KeepBothTimestamp ConflictResolutionType = "keep-both-timestamp" // Suffix the source with its modification time
+	Ask               ConflictResolutionType = "ask"                 // Prompt for every conflict
 )
 
 type FilePathParams struct {
@@ -40,7 +49,13 @@ type FilePathParams struct {
 	SourceDir          string
 	TargetDir          string
 	DryRun             bool
-	ConflictResolution ConflictResolutionType // "overwrite", "skip", or "rename"
+	Jobs               int                    // Transfers applied at once when applying a plan
+	Symlinks           SymlinkPolicy          // How the indexer treats symbolic links
+	CopyStrategy       CopyStrategy           // How copies are written, see CopyStrategy
+	AllowHardlinks     bool                   // Let the auto copy strategy hardlink files
+	Reindex            bool                   // Rescan every directory instead of reusing the unchanged listings of the index
+	ScanJobs           int                    // Directories read at once while indexing
+	ConflictResolution ConflictResolutionType // Default for categories without a conflict= entry, see ConflictResolutionType
 }
 
 type DesktopFS struct {
@@ -50,6 +65,11 @@ type DesktopFS struct {
 	HomeDCDir        string
 	WorkspaceManager *WorkspaceManager
 	InstanceConfig   *DeskFSConfig
+	Unmatched        *UnmatchedReport // Extensions no rule matched during the last organize run
+	CopyOptions      CopyOptions      // How the copies of the next runs are written
+	AskConflict      ConflictPrompt   // Decides the conflicts of the ask strategy, they are skipped when nil
+	hasher           *ContentHasher   // Hashes of the plan being applied, cached in its journal
+	progress         ProgressReporter
 	term             *terminal.Terminal
 }
 
@@ -58,11 +78,16 @@ func NewFilePathParams() *FilePathParams {
 	return &FilePathParams{
 		SourceDir:          "",
 		TargetDir:          "",
-		Recursive:          true,     // Default to recursive to handle directories deeply
-		CopyFiles:          false,    // Default to moving files instead of copying
-		RemoveAfter:        false,    // Default to keeping source files after move
-		DryRun:             false,    // Default to executing actual file operations
-		ConflictResolution: "rename", // Default to renaming files to avoid conflicts
+		Recursive:          true,             // Default to recursive to handle directories deeply
+		CopyFiles:          false,            // Default to moving files instead of copying
+		RemoveAfter:        false,            // Default to keeping source files after move
+		DryRun:             false,            // Default to executing actual file operations
+		MaxDepth:           -1,               // Default to no depth limit
+		Jobs:               DefaultJobs,      // Default to one worker per CPU
+		ScanJobs:           DefaultScanJobs,  // Default to keeping a few directory reads in flight
+		Symlinks:           SymlinksPreserve, // Default to organizing links as links
+		CopyStrategy:       CopyAuto,         // Default to the fastest copy the file systems support
+		ConflictResolution: RenameSuffix,     // Default to renaming files to avoid conflicts
 	}
 }
 
@@ -132,44 +157,35 @@ func CalculateMaxDepth(sourceDir string) (int, error) {
 }
 
 func (dfs *DesktopFS) IndexDirectory(cfg *DeskFSConfig, params *FilePathParams) error {
-	// Calculate the maximum depth of SourceDir
-	maxDepth, err := CalculateMaxDepth(params.SourceDir)
-	if err != nil {
-		return fmt.Errorf("failed to calculate max depth: %w", err)
-	}
-
-	if err := dfs.buildTreeAndCache(params.SourceDir, params.Recursive, maxDepth); err != nil {
+	// The walk stops at params.MaxDepth by itself, a negative depth means no limit
+	if err := dfs.buildTreeAndCache(cfg, params); err != nil {
 		return fmt.Errorf("failed to build directory tree: %w", err)
 	}
 
 	return nil
 }
 
-// Move or copy files based on the configuration
+// Move or copy files based on the configuration. The operations are planned first, then applied;
+// a dry run applies the same plan without side effects. Overlapping rules are the caller's to
+// report, see DeskFSConfig.Validate.
 func (dfs *DesktopFS) EnhancedOrganize(cfg *DeskFSConfig, params *FilePathParams) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel() // Ensure context is canceled after function exits
-
-	dfs.IndexDirectory(cfg, params)
-
-	var wg sync.WaitGroup
-	var once sync.Once
-	errCh := make(chan error, 1)
-
-	// Traverse and organize files based on config
-	dfs.traverseAndOrganize(ctx, cancel, dfs.WorkspaceManager.centralDB.DirectoryTree.Root, cfg, params, &wg, errCh)
-
-	// Wait for all goroutines to complete
-	go func() {
-		wg.Wait()
-		once.Do(func() { close(errCh) })
-	}()
+	plan, err := dfs.BuildPlan(cfg, params)
+	if err != nil {
+		return fmt.Errorf("failed to plan organize: %w", err)
+	}
 
-	if err, ok := <-errCh; ok {
-		cancel() // Cancel ongoing operations
+	if err := dfs.ApplyPlan(context.Background(), plan, ApplyOptions{
+		DryRun: params.DryRun || params.NamesOnly,
+		Jobs:   params.Jobs,
+		Copy:   CopyOptions{Strategy: params.CopyStrategy, AllowHardlinks: params.AllowHardlinks},
+	}); err != nil {
 		return fmt.Errorf("failed to organize files: %w", err)
 	}
 
+	if params.DryRun || params.NamesOnly {
+		return nil
+	}
+
 	// Commit changes if Git is enabled
 	if params.GitEnabled {
 		if err := dfs.GitAddAndCommit(dfs.Cwd, fmt.Sprintf("Organized files for %s", dfs.Cwd)); err != nil {
@@ -192,15 +208,15 @@ func (dfs *DesktopFS) InitConfig(optionalConfigPath string) {
 
 	deskfsConfig := NewDeskFSConfig()
 
-	// Build FileTypeTree
-	deskfsConfig = deskfsConfig.BuildFileTypeTree(config)
+	// Build FileTypeTree and carry over the remaining settings
+	deskfsConfig = deskfsConfig.BuildFileTypeTree(config).ApplySettings(config)
 
 	// Set the loaded configuration for this instance
 	dfs.InstanceConfig = deskfsConfig
 }
 
 func (dfs *DesktopFS) GetDesktopCleanerIgnore(dir string) (*ignore.GitIgnore, error) {
-	ignorePath := filepath.Join(dir, ".desktop-cleaner-ignore")
+	ignorePath := filepath.Join(dir, ignoreFileName)
 
 	if _, err := os.Stat(ignorePath); err == nil {
 		ignored, err := ignore.CompileIgnoreFile(ignorePath)
@@ -220,6 +236,11 @@ func (dfs *DesktopFS) GetDesktopCleanerIgnore(dir string) (*ignore.GitIgnore, er
 // Copy copies a file or directory to the destination path.
 // It uses recursion for directories if the recursive flag is enabled.
 func (dfs *DesktopFS) Copy(node *trees.DirectoryNode, dst string, recursive bool, remove bool, dryrun bool) error {
+	if dryrun {
+		slog.Info(fmt.Sprintf("Dry run: copying %s to %s\n", node.Path, dst))
+		return nil
+	}
+
 	if len(node.Children) > 0 || len(node.Files) > 0 { // Check if node is a directory
 		if !recursive {
 			return fmt.Errorf("source is a directory, use recursive flag to copy directories")
@@ -232,11 +253,7 @@ func (dfs *DesktopFS) Copy(node *trees.DirectoryNode, dst string, recursive bool
 
 		// Copy each child directory
 		for _, childDir := range node.Children {
-			childDst := filepath.Join(dst, childDir.Path)
-			if dryrun {
-				slog.Info(fmt.Sprintf("Dry run: moving %s to %s\n", childDir.Path, dst))
-				return nil
-			}
+			childDst := filepath.Join(dst, filepath.Base(childDir.Path))
 			if err := dfs.Copy(childDir, childDst, recursive, remove, dryrun); err != nil {
 				return err
 			}
@@ -244,11 +261,7 @@ func (dfs *DesktopFS) Copy(node *trees.DirectoryNode, dst string, recursive bool
 
 		// Copy each file in the directory
 		for _, fileNode := range node.Files {
-			fileDst := filepath.Join(dst, fileNode.Path)
-			if dryrun {
-				slog.Info(fmt.Sprintf("Dry run: moving %s to %s\n", fileNode.Path, dst))
-				return nil
-			}
+			fileDst := filepath.Join(dst, fileNode.Name)
 			if err := dfs.copyFile(fileNode, fileDst, remove, dryrun); err != nil {
 				return err
 			}
@@ -260,37 +273,14 @@ func (dfs *DesktopFS) Copy(node *trees.DirectoryNode, dst string, recursive bool
 		}
 		return nil
 	}
-	return fmt.Errorf("node has no files or directories to copy")
-}
-
-// Helper function for copying a file
-func (dfs *DesktopFS) copyFile(fileNode *trees.FileNode, dst string, remove bool, dryrun bool) error {
-
-	if dryrun {
-		slog.Info(fmt.Sprintf("Dry run: moving %s to %s\n", fileNode.Path, dst))
-		return nil
-	}
-
-	srcFile, err := os.Open(fileNode.Path)
-	if err != nil {
-		return fmt.Errorf("failed to open source file %s: %w", fileNode.Path, err)
-	}
-	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file %s: %w", dst, err)
-	}
-	defer dstFile.Close()
-
-	if _, err := io.Copy(dstFile, srcFile); err != nil {
-		return fmt.Errorf("failed to copy file %s to %s: %w", fileNode.Path, dst, err)
-	}
-
-	// Optionally remove the original file after copying
-	if remove {
-		if err := os.Remove(fileNode.Path); err != nil {
-			return fmt.Errorf("failed to remove original file %s after copy: %w", fileNode.Path, err)
+	// Empty directories (common inside projects, e.g. .git/refs/tags) are recreated as is
+	if node.IsDir() {
+		if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dst, err)
+		}
+		if remove {
+			return os.Remove(node.Path)
 		}
 	}
 	return nil
@@ -305,12 +295,34 @@ func (dfs *DesktopFS) Move(node *trees.DirectoryNode, dst string, recursive bool
 		return nil
 	}
 
+	info, err := os.Lstat(node.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", node.Path, err)
+	}
+	// A link is recreated rather than renamed, so a relative target still resolves from its new place
+	if info.Mode()&os.ModeSymlink != 0 {
+		return transferSymlink(node.Path, dst, true)
+	}
+
 	// Try renaming (moving) the directory node directly
 	if err := os.Rename(node.Path, dst); err != nil {
 		// If we encounter a cross-device link error, fall back to copy and delete
 		if linkErr, ok := err.(*os.LinkError); ok && linkErr.Err == syscall.EXDEV {
 			slog.Warn(fmt.Sprintf("Cross-device error detected: falling back to copy for %s\n", node.Path))
-			if err := dfs.Copy(node, dst, recursive, true, dryrun); err != nil {
+			// Copy from the disk, the node may only carry a path
+			info, statErr := os.Stat(node.Path)
+			if statErr != nil {
+				return fmt.Errorf("failed to copy file for cross-device move: %w", statErr)
+			}
+			if info.IsDir() {
+				if !recursive {
+					return fmt.Errorf("source is a directory, use recursive flag to move directories")
+				}
+				err = dfs.copyDir(node.Path, dst, true)
+			} else {
+				err = dfs.copyFile(&trees.FileNode{Path: node.Path}, dst, true, false)
+			}
+			if err != nil {
 				return fmt.Errorf("failed to copy file for cross-device move: %w", err)
 			}
 			return nil
@@ -318,20 +330,18 @@ func (dfs *DesktopFS) Move(node *trees.DirectoryNode, dst string, recursive bool
 			return fmt.Errorf("failed to move directory: %w", err)
 		}
 	}
-	return nil
-}
 
-// MoveToTrash moves a file or directory to the trash (cache) directory
-func (dfs *DesktopFS) MoveToTrash(node *trees.DirectoryNode) error {
-	dst := filepath.Join(dfs.CacheDir, filepath.Base(node.Path))
-	return os.Rename(node.Path, dst)
+	if info.IsDir() {
+		return relinkTree(node.Path, dst)
+	}
+	return nil
 }
 
 // buildTreeAndCache recursively builds a directory tree and populates a cache
-func (dfs *DesktopFS) buildTreeAndCache(rootPath string, recursive bool, maxDepth int) error {
+func (dfs *DesktopFS) buildTreeAndCache(cfg *DeskFSConfig, params *FilePathParams) error {
 	// Initialize the DirectoryTree and Cache
 	if dfs.WorkspaceManager.centralDB.DirectoryTree == nil {
-		newDirectoryTree, err := trees.NewDirectoryTree(rootPath)
+		newDirectoryTree, err := trees.NewDirectoryTree(params.SourceDir)
 		if err != nil {
 			return fmt.Errorf("failed to create directory tree: %w", err)
 		}
@@ -342,27 +352,110 @@ func (dfs *DesktopFS) buildTreeAndCache(rootPath string, recursive bool, maxDept
 	//	dfs.WorkspaceManager.centralDB.DirectoryTree.Cache = make(map[string]*trees.DirectoryNode)
 	//}
 
-	return dfs.buildTreeNodes(dfs.WorkspaceManager.centralDB.DirectoryTree.Root, recursive, maxDepth, 0)
-}
+	dfs.report(ProgressEvent{Type: EventScanStarted, Path: params.SourceDir})
 
-// Recursive helper to populate the directory tree with DirectoryNode entries
-func (dfs *DesktopFS) buildTreeNodes(node *trees.DirectoryNode, recursive bool, maxDepth int, currentDepth int) error {
-	// Check if the current depth exceeds the maxDepth
-	if currentDepth > maxDepth {
-		slog.Warn(fmt.Sprintf("Max depth of %d reached at %s. Skipping deeper levels.\n", maxDepth, node.Path))
-		return nil
+	// Following links can lead back to a directory already walked, each one is only indexed once
+	walk := &treeWalk{visited: make(map[string]bool), index: dfs.openIndex(params)}
+	firstVisit(walk.visited, params.SourceDir)
+
+	if walk.index != nil {
+		defer walk.index.close()
 	}
 
-	entries, err := os.ReadDir(node.Path)
-	if err != nil {
+	if err := dfs.buildTreeNodes(cfg, params, dfs.WorkspaceManager.centralDB.DirectoryTree.Root, walk); err != nil {
 		return err
 	}
 
-	ignored, err := dfs.GetDesktopCleanerIgnore(node.Path)
+	if walk.index != nil {
+		if err := walk.index.save(); err != nil {
+			slog.Warn(fmt.Sprintf("Failed to save the directory index: %v\n", err))
+		}
+	}
+	return nil
+}
+
+// DefaultScanJobs is the number of directories read at once when --scan-jobs is not set. Reading
+// a directory mostly waits on the disk, so it pays to have more reads in flight than CPUs.
+const DefaultScanJobs = 16
+
+// treeWalk is the state shared by the directories of a walk, indexed concurrently.
+type treeWalk struct {
+	mu      sync.Mutex
+	visited map[string]bool // Directories walked, by file identity
+	index   *directoryIndex // Listings of the previous runs, nil to read every directory from disk
+}
+
+// walkDir is a directory of the walk and its depth below the source directory.
+type walkDir struct {
+	node  *trees.DirectoryNode
+	depth int
+}
+
+// firstVisit records a directory reached by the walk, see firstVisit.
+func (walk *treeWalk) firstVisit(path string) bool {
+	walk.mu.Lock()
+	defer walk.mu.Unlock()
+	return firstVisit(walk.visited, path)
+}
+
+// readDir lists a directory from the index, or from disk without one.
+func (walk *treeWalk) readDir(dir string) ([]os.DirEntry, error) {
+	if walk.index == nil {
+		return os.ReadDir(dir)
+	}
+	return walk.index.list(dir)
+}
+
+// buildTreeNodes populates the directory tree below root, reading up to params.ScanJobs directories
+// at once. Children keep the order of the directory listings, whatever order they were read in.
+// Following links walks one directory at a time: the first path reaching a directory indexes it,
+// and that has to be the same path on every run.
+func (dfs *DesktopFS) buildTreeNodes(cfg *DeskFSConfig, params *FilePathParams, root *trees.DirectoryNode, walk *treeWalk) error {
+	jobs := params.ScanJobs
+	if params.Symlinks == SymlinksFollow {
+		jobs = 1
+	}
+	return fswalk.Walk(walkDir{node: root}, jobs, func(dir walkDir) ([]walkDir, error) {
+		return dfs.indexDirectory(cfg, params, dir, walk)
+	})
+}
+
+// indexDirectory adds the files and subdirectories of a directory to its DirectoryNode, and returns
+// the subdirectories to walk next. Symbolic links are skipped, indexed as files, or followed
+// according to params.Symlinks.
+func (dfs *DesktopFS) indexDirectory(cfg *DeskFSConfig, params *FilePathParams, dir walkDir, walk *treeWalk) ([]walkDir, error) {
+	node := dir.node
+
+	entries, err := walk.readDir(node.Path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Project roots, the source directory included, are organized as a unit. Their content is still
+	// indexed so the project can be copied, but ignore files inside a project are not applied.
+	if !insideProject(node) {
+		if marker, found := cfg.Projects.projectMarker(entries); found {
+			slog.Info(fmt.Sprintf("Detected project at %s (found %s)\n", node.Path, marker))
+			node.IsProject = true
+		}
+	}
+
+	// Check if the current depth exceeds the maxDepth. A project just past it is still a single
+	// entry of a directory within reach, so it is detected and indexed whole.
+	if params.MaxDepth >= 0 && dir.depth > params.MaxDepth && !insideProject(node) {
+		slog.Warn(fmt.Sprintf("Max depth of %d reached at %s. Skipping deeper levels.\n", params.MaxDepth, node.Path))
+		return nil, nil
 	}
 
+	var ignored *ignore.GitIgnore
+	if !insideProject(node) && !params.ForceSkipIgnore {
+		ignored, err = dfs.GetDesktopCleanerIgnore(node.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var subdirs []walkDir
 	for _, entry := range entries {
 		childPath := filepath.Join(node.Path, entry.Name())
 		//var child *trees.DirectoryNode
@@ -373,151 +466,151 @@ func (dfs *DesktopFS) buildTreeNodes(node *trees.DirectoryNode, recursive bool,
 			continue
 		}
 
-		if entry.IsDir() {
+		if entry.IsDir() && entry.Name() == internal.DefaultWorkspaceDotDir && !insideProject(node) {
+			continue // The workspace directory holds the journal of the runs organizing this directory
+		}
+
+		isDir := entry.IsDir()
+		var targetInfo os.FileInfo // What a followed link points at
+		if entry.Type()&fs.ModeSymlink != 0 {
+			switch params.Symlinks {
+			case SymlinksSkip:
+				slog.Info(fmt.Sprintf("Skipping symlink %s\n", childPath))
+				continue
+			case SymlinksFollow:
+				if targetInfo, err = os.Stat(childPath); err != nil {
+					slog.Warn(fmt.Sprintf("Keeping dangling symlink %s as a link: %v\n", childPath, err))
+					targetInfo = nil
+				} else {
+					isDir = targetInfo.IsDir()
+				}
+			}
+		}
+
+		if isDir && params.Symlinks == SymlinksFollow && !walk.firstVisit(childPath) {
+			slog.Warn(fmt.Sprintf("Skipping %s, its directory was already indexed through another path\n", childPath))
+			continue
+		}
+
+		if isDir {
 			childDir := trees.NewDirectoryNode(childPath, node)
 			node.Children = append(node.Children, childDir)
 			//dfs.WorkspaceManager.centralDB.DirectoryTree.SafeCacheSet(childPath, childDir)
 
-			if !recursive {
+			if !params.Recursive {
 				continue
 			}
 
-			if err := dfs.buildTreeNodes(childDir, recursive, maxDepth, currentDepth+1); err != nil {
-				return err
-			}
+			subdirs = append(subdirs, walkDir{node: childDir, depth: dir.depth + 1})
+		} else if (entry.Name() == internal.DefaultDirectoryConfigFile || entry.Name() == ignoreFileName) && !insideProject(node) {
+			continue // Directory rules and ignore files stay with their directory
 		} else {
-			entryInfo, err := entry.Info()
-			if err != nil {
-				slog.Warn(fmt.Sprintf("Error getting file info for %s: %v", entry.Name(), err))
-			}
-
-			childFile := &trees.FileNode{
-				Path:      childPath,
-				Name:      entry.Name(),
-				Extension: strings.ToLower(filepath.Ext(entry.Name())),
-				Metadata:  trees.NewMetadata(entryInfo),
+			// Names only mode never stats the files
+			var entryInfo os.FileInfo
+			if targetInfo != nil && !params.NamesOnly {
+				entryInfo = targetInfo
+			} else if !params.NamesOnly {
+				entryInfo, err = entry.Info()
+				if err != nil {
+					slog.Warn(fmt.Sprintf("Error getting file info for %s: %v", entry.Name(), err))
+				}
 			}
-			_ = node.AddFile(childFile)
+			_ = node.AddFile(newFileNode(childPath, entryInfo))
 			//dfs.WorkspaceManager.centralDB.DirectoryTree.SafeCacheSet(childPath, child)
 		}
 	}
 
-	return nil
+	dfs.report(ProgressEvent{Type: EventDirIndexed, Path: node.Path, Files: len(node.Files)})
+	return subdirs, nil
 }
 
-// traverseAndOrganize traverses the tree and organizes files based on the configuration
-func (dfs *DesktopFS) traverseAndOrganize(ctx context.Context, cancel context.CancelFunc, node *trees.DirectoryNode, cfg *DeskFSConfig, params *FilePathParams, wg *sync.WaitGroup, errCh chan error) {
-	// Process each file within the directory
-	for _, fileNode := range node.Files {
-		wg.Add(1)
-		go func(fileNode *trees.FileNode) {
-			defer wg.Done()
-
-			// Respect context cancellation
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			// Determine the target folder based on file extension
-			targetDir, found := dfs.determineTargetFolder(ctx, fileNode, cfg)
-			if !found {
-				slog.Warn(fmt.Sprintf("Skipping file %s as no target path found\n", fileNode.Name))
-				return // Skip files without a target folder
-			}
-
-			// Construct the correct destination directory and path
-			destDir := filepath.Join(params.TargetDir, targetDir)
-			slog.Debug(fmt.Sprintf("Creating directory: %s\n", destDir))
-			destPath := filepath.Join(destDir, filepath.Base(fileNode.Path)) // Only the base name
-			slog.Debug(fmt.Sprintf("Moving file %s to %s\n", fileNode.Path, destPath))
-
-			// Check if the target file already exists
-			if _, err := os.Stat(destPath); err == nil {
-				switch params.ConflictResolution {
-				case Overwrite:
-					slog.Info(fmt.Sprintf("Overwriting existing file: %s\n", destPath))
-				case Skip:
-					slog.Info(fmt.Sprintf("Skipping file to avoid conflict: %s\n", destPath))
-					return // Skip this file
-				case RenameSuffix:
-					destPath = generateUniqueFilename(destPath)
-					slog.Info(fmt.Sprintf("Renaming file to avoid conflict: %s\n", destPath))
-				default:
-					slog.Info(fmt.Sprintf("Unknown conflict resolution type: %s\n", params.ConflictResolution))
-					return
-				}
-			}
-
-			slog.Info(fmt.Sprintf("Moving file %s to %s\n", fileNode.Path, destPath))
-
-			// Ensure target directory exists before moving or copying files
-			if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
-				select {
-				case errCh <- fmt.Errorf("failed to create target directory %s: %w", destDir, err):
-					cancel() // Cancel all ongoing operations
-				default:
-				}
-				return
-			}
+// determineTargetFolder resolves the file against the FileTypeTree in DeskFSConfig, matching
+// extensions and glob/regex name patterns. When several categories match, the most specific one wins.
+// Fallback categories catch what the rules leave, and files no rule matched are added to dfs.Unmatched.
+// Templated categories are rendered against the file and its metadata.
+// It returns the path to the target folder and the matched category if a match is found.
+func (dfs *DesktopFS) determineTargetFolder(ctx context.Context, fileNode *trees.FileNode, cfg *DeskFSConfig, params *FilePathParams) (string, *trees.FileTypeNode, bool) {
+	resolved, matched := dfs.resolveFileType(fileNode, cfg, !params.NamesOnly)
+	match, found := cfg.FileTypeTree.ApplyFallback(resolved, matched)
+	if !matched {
+		dfs.Unmatched.Add(fileNode, found)
+	}
+	if !found {
+		slog.Info(fmt.Sprintf("No mapping found for file %s with extension %s\n", fileNode.Name, fileNode.Extension))
+		return "", nil, false
+	}
+
+	path := buildPathFromNode(ctx, match.Node)
+	if match.Node.Template != nil {
+		rendered, err := match.Node.RenderDestination(fileNode)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Skipping file %s: %v\n", fileNode.Name, err))
+			return "", nil, false
+		}
+		path = rendered
+	}
+	slog.Info(fmt.Sprintf("File %s matched rule %s, mapped to path: %s\n", fileNode.Name, match.Rule, path))
+	return path, match.Node, true
+}
 
-			// Copy or move the file based on params
-			var fileErr error
-			if params.CopyFiles {
-				fileErr = dfs.copyFile(fileNode, destPath, params.RemoveAfter, params.DryRun)
-			} else {
-				fileErr = dfs.Move(&trees.DirectoryNode{Path: fileNode.Path}, destPath, false, params.DryRun)
-			}
-			// Send error to errCh and cancel context on first failure
-			if fileErr != nil {
-				select {
-				case errCh <- fmt.Errorf("file operation failed: %w", fileErr):
-					cancel() // Cancel all ongoing operations
-				default:
-				}
-			}
+// resolveFileType resolves a file against the FileTypeTree. The file content is sniffed when the tree
+// has MIME rules, when the name matched nothing or when the extension can be checked against a known
+// signature. A file the name does not place, or whose content contradicts its extension, is retried
+// under the extension its content implies, so misnamed or extensionless downloads still find a category.
+// Content is never read when sniff is false.
+func (dfs *DesktopFS) resolveFileType(fileNode *trees.FileNode, cfg *DeskFSConfig, sniff bool) (trees.RuleMatch, bool) {
+	tree := cfg.FileTypeTree
 
-		}(fileNode)
+	match, found := tree.Resolve(fileNode)
+	if !sniff || (found && !tree.HasMIMERules() && !trees.HasSignature(fileNode.Extension)) {
+		return match, found
 	}
 
-	// Process each child directory
-	for _, childDir := range node.Children {
-		if params.Recursive {
-			dfs.traverseAndOrganize(ctx, cancel, childDir, cfg, params, wg, errCh)
+	if fileNode.MimeType == "" {
+		mimeType, err := trees.DetectFileType(fileNode.Path)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Could not detect file type of %s: %v\n", fileNode.Path, err))
+			return match, found
 		}
+		fileNode.MimeType = mimeType
 	}
-}
 
-// determineTargetFolder traverses the FileTypeTree in DeskFSConfig to find the appropriate folder
-// based on the file's extension. It returns the path to the target folder if a match is found.
-func (dfs *DesktopFS) determineTargetFolder(ctx context.Context, fileNode *trees.FileNode, cfg *DeskFSConfig) (string, bool) {
-	ext := fileNode.Extension
+	if tree.HasMIMERules() {
+		match, found = tree.Resolve(fileNode)
+	}
+	if found && !trees.ContradictsContent(fileNode.Extension, fileNode.MimeType) {
+		return match, true
+	}
 
-	path, found := dfs.findFolderForExtension(ctx, cfg.FileTypeTree.Root, ext)
-	if found {
-		slog.Info(fmt.Sprintf("File %s with extension %s mapped to path: %s\n", fileNode.Name, ext, path))
-	} else {
-		slog.Info(fmt.Sprintf("No mapping found for file %s with extension %s\n", fileNode.Name, ext))
+	detectedExt := trees.ExtensionForMIME(fileNode.MimeType)
+	if detectedExt == "" || detectedExt == fileNode.Extension {
+		return match, found
 	}
-	return path, found
-}
 
-// Helper recursive function to search for the appropriate folder in the FileTypeTree.
-func (dfs *DesktopFS) findFolderForExtension(ctx context.Context, node *trees.FileTypeNode, ext string) (string, bool) {
-	// Traverse the tree to find a matching extension in the nodes
-	if node.AllowsExtension(ext) {
-		return buildPathFromNode(ctx, node), true
+	// A misnamed file keeps its name-based category when its content has none
+	detected := *fileNode
+	detected.Extension = detectedExt
+	if detectedMatch, ok := tree.Resolve(&detected); ok {
+		detectedMatch.Rule = fmt.Sprintf("%s (detected %s)", detectedMatch.Rule, fileNode.MimeType)
+		return detectedMatch, true
 	}
+	return match, found
+}
 
-	// Continue to search for extensions in children
-	for _, child := range node.Children {
-		if path, found := dfs.findFolderForExtension(ctx, child, ext); found {
-			return path, true
-		}
+// newFileNode builds the FileNode for a file. Metadata feeds the size/age/permission/tag predicates
+// of the FileTypeTree, it is left empty when info is nil.
+func newFileNode(path string, info os.FileInfo) *trees.FileNode {
+	fileNode := &trees.FileNode{
+		Path:      path,
+		Name:      filepath.Base(path),
+		Extension: trees.FileExtension(path),
 	}
 
-	return "", false
+	if info != nil {
+		fileNode.Metadata = trees.NewMetadata(info)
+		trees.AddTagsToMetadata(&fileNode.Metadata)
+	}
+	return fileNode
 }
 
 // buildPathFromNode constructs the path from the root to the given node.
@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"desktop-cleaner/internal/db"
+	"desktop-cleaner/internal/filesystem/trees"
 	"desktop-cleaner/internal/terminal"
 
 	"github.com/stretchr/testify/assert"
@@ -26,6 +28,35 @@ func loadTestConfig(configPath string) *DeskFSConfig {
 	return deskfsConfig
 }
 
+// newTestDeskFS returns a DesktopFS whose central database and trash live in a temporary home
+// directory.
+func newTestDeskFS(t testing.TB) *DesktopFS {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	centralDB, err := db.NewCentralDBProvider()
+	if err != nil {
+		t.Fatalf("failed to open the central database: %v", err)
+	}
+	t.Cleanup(func() { centralDB.Close() })
+
+	return NewDesktopFS(terminal.NewTerminal(), centralDB)
+}
+
+// indexedFiles returns the paths of the files of a directory tree.
+func indexedFiles(node *trees.DirectoryNode) map[string]bool {
+	files := make(map[string]bool)
+	for _, file := range node.Files {
+		files[file.Path] = true
+	}
+	for _, child := range node.Children {
+		for path := range indexedFiles(child) {
+			files[path] = true
+		}
+	}
+	return files
+}
+
 // Helper to create a temporary directory structure for tests
 func setupTestDir(t *testing.T, structure map[string]string) (string, func()) {
 	dir, err := os.MkdirTemp("", "desktop_cleaner_test")
@@ -104,8 +135,7 @@ func TestNewConfig(t *testing.T) {
 }
 
 func TestBuildTreeAndCache(t *testing.T) {
-	term := terminal.NewTerminal()
-	dfs := NewDesktopFS(term)
+	dfs := newTestDeskFS(t)
 
 	dir, cleanup := setupTestDir(t, map[string]string{
 		"docs/report.docx": "",
@@ -114,30 +144,127 @@ func TestBuildTreeAndCache(t *testing.T) {
 	})
 	defer cleanup()
 
-	newDirTree, err := NewDirectoryTree(dir)
-	assert.NoError(t, err)
-
-	dfs.DirectoryTree = newDirTree
-
-	err = dfs.buildTreeAndCache(dir, true, 10)
+	params := NewFilePathParams()
+	params.SourceDir = dir
+	params.MaxDepth = 10
+	err := dfs.buildTreeAndCache(NewDeskFSConfig(), params)
 	assert.NoError(t, err)
 
-	// Check that each expected path is in the cache
+	// Check that each expected path is in the tree
 	reportDocPath := filepath.Join(dir, "docs", "report.docx")
 	photoPath := filepath.Join(dir, "pics", "photo.jpg")
 	setupShPath := filepath.Join(dir, "scripts", "setup.sh")
 
-	_, reportExists := dfs.DirectoryTree.Cache[reportDocPath]
-	_, photoExists := dfs.DirectoryTree.Cache[photoPath]
-	_, setupExists := dfs.DirectoryTree.Cache[setupShPath]
+	indexed := indexedFiles(dfs.WorkspaceManager.centralDB.DirectoryTree.Root)
+	reportExists := indexed[reportDocPath]
+	photoExists := indexed[photoPath]
+	setupExists := indexed[setupShPath]
 
 	assert.True(t, reportExists, "Expected report.docx to be in the cache")
 	assert.True(t, photoExists, "Expected photo.jpg to be in the cache")
 	assert.True(t, setupExists, "Expected setup.sh to be in the cache")
 }
 
+func TestIndexDirectoryOptions(t *testing.T) {
+	dir, cleanup := setupTestDir(t, map[string]string{
+		"report.pdf":              "%PDF-1.7\n",
+		"cache.tmp":               "temporary",
+		".desktop-cleaner-ignore": "*.tmp\n",
+		"a/one.txt":               "1",
+		"a/b/two.txt":             "2",
+		"a/b/c/three.txt":         "3",
+	})
+	defer cleanup()
+
+	index := func(configure func(params *FilePathParams)) *trees.DirectoryNode {
+		params := NewFilePathParams()
+		params.SourceDir = dir
+		configure(params)
+
+		dfs := newTestDeskFS(t)
+		if err := dfs.IndexDirectory(NewDeskFSConfig(), params); err != nil {
+			t.Fatal(err)
+		}
+		return dfs.WorkspaceManager.centralDB.DirectoryTree.Root
+	}
+
+	root := index(func(params *FilePathParams) {})
+	indexed := indexedFiles(root)
+	assert.False(t, indexed[filepath.Join(dir, "cache.tmp")])
+	assert.True(t, indexed[filepath.Join(dir, "a", "b", "c", "three.txt")])
+	for _, file := range root.Files {
+		assert.NotZero(t, file.Metadata.ModifiedAt, file.Name)
+	}
+
+	// Names only mode never stats the files
+	root = index(func(params *FilePathParams) { params.NamesOnly = true })
+	assert.NotEmpty(t, root.Files)
+	for _, file := range root.Files {
+		assert.Zero(t, file.Metadata, file.Name)
+	}
+
+	root = index(func(params *FilePathParams) { params.ForceSkipIgnore = true })
+	assert.True(t, indexedFiles(root)[filepath.Join(dir, "cache.tmp")])
+
+	// The walk stops at the depth limit, the directories past it are never read
+	if err := os.Chmod(filepath.Join(dir, "a", "b", "c"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dir, "a", "b", "c"), 0755)
+
+	root = index(func(params *FilePathParams) { params.MaxDepth = 1 })
+	indexed = indexedFiles(root)
+	assert.True(t, indexed[filepath.Join(dir, "a", "one.txt")])
+	assert.False(t, indexed[filepath.Join(dir, "a", "b", "two.txt")])
+	if assert.Len(t, root.Children, 1) && assert.Len(t, root.Children[0].Children, 1) {
+		assert.Empty(t, root.Children[0].Children[0].Children)
+	}
+
+	root = index(func(params *FilePathParams) { params.MaxDepth = 0 })
+	indexed = indexedFiles(root)
+	assert.True(t, indexed[filepath.Join(dir, "report.pdf")])
+	assert.False(t, indexed[filepath.Join(dir, "a", "one.txt")])
+}
+
+// BenchmarkIndexDirectory indexes a generated tree of 1111 directories and 22220 files with a
+// single directory read at a time, as the recursive walk did, and with several in flight. There is
+// no workspace, so every run reads the whole tree from disk.
+func BenchmarkIndexDirectory(b *testing.B) {
+	files := make(map[string]string)
+	var fill func(dir string, level int)
+	fill = func(dir string, level int) {
+		for i := 0; i < 20; i++ {
+			files[filepath.Join(dir, fmt.Sprintf("f%d.txt", i))] = "content"
+		}
+		if level == 3 {
+			return
+		}
+		for i := 0; i < 10; i++ {
+			fill(filepath.Join(dir, fmt.Sprintf("d%d", i)), level+1)
+		}
+	}
+	fill("", 0)
+	root := newTestTree(b, files)
+	dfs := newTestDeskFS(b)
+
+	for _, jobs := range []int{1, 4, DefaultScanJobs, 64} {
+		b.Run(fmt.Sprintf("scan-jobs=%d", jobs), func(b *testing.B) {
+			params := newTestParams(root)
+			params.Recursive = true
+			params.DryRun = true
+			params.ScanJobs = jobs
+			for i := 0; i < b.N; i++ {
+				dfs.WorkspaceManager.centralDB.DirectoryTree = nil
+				if err := dfs.IndexDirectory(NewDeskFSConfig(), params); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestPopulateFileTypes(t *testing.T) {
-	tree := NewFileTypeTree()
+	tree := trees.NewFileTypeTree()
 	rules := map[string][]string{
 		"docs/Reports":  {".docx", ".pdf"},
 		"pics/Photos":   {".jpg", ".png"},
@@ -156,9 +283,54 @@ func TestPopulateFileTypes(t *testing.T) {
 	assert.True(t, setupNode.AllowsExtension(".sh"))
 }
 
+func TestResolveFileType(t *testing.T) {
+	dir := t.TempDir()
+	tree := trees.NewFileTypeTree()
+	tree.PopulateFileTypes(map[string][]string{
+		"Compressed":         {".gz"},
+		"Compressed/Tarball": {".tar.gz"},
+		"Pics":               {".jpg", ".png"},
+		"Docs":               {".pdf", ".docx", ".txt"},
+	})
+	cfg := &DeskFSConfig{FileTypeTree: tree}
+	dfs := &DesktopFS{}
+
+	files := map[string]string{
+		"backup.tar.gz": "\x1f\x8b\x08\x00",
+		"photo.pdf":     "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"report.docx":   "PK\x03\x04",
+		"notes.txt":     "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"scan":          "%PDF-1.7\n",
+	}
+	expected := map[string]string{
+		"backup.tar.gz": "Tarball", // the compound extension beats ".gz"
+		"photo.pdf":     "Pics",    // a known extension contradicted by the content is sniffed
+		"report.docx":   "Docs",    // a zip file, but ".docx" has no signature to contradict
+		"notes.txt":     "Docs",    // nor does ".txt"
+		"scan":          "Docs",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		fileNode := &trees.FileNode{Path: path, Name: name, Extension: trees.FileExtension(name)}
+		match, found := dfs.resolveFileType(fileNode, cfg, true)
+		assert.True(t, found, name)
+		assert.Equal(t, expected[name], match.Node.Name, name)
+	}
+
+	// Names only mode never reads the content
+	scan := &trees.FileNode{Path: filepath.Join(dir, "scan"), Name: "scan"}
+	_, found := dfs.resolveFileType(scan, cfg, false)
+	assert.False(t, found)
+	assert.Empty(t, scan.MimeType)
+}
+
 func TestEnhancedOrganize(t *testing.T) {
-	term := terminal.NewTerminal()
-	dfs := NewDesktopFS(term)
+	dfs := newTestDeskFS(t)
 
 	dir, cleanup := setupTestDir(t, map[string]string{
 		"source/report.docx":           "",
@@ -226,8 +398,7 @@ func TestEnhancedOrganize_NonexistentDirs(t *testing.T) {
 //}
 
 func initDeskFS(t *testing.T) *DesktopFS {
-	term := terminal.NewTerminal()
-	dfs := NewDesktopFS(term)
+	dfs := newTestDeskFS(t)
 
 	dir, cleanup := setupTestDir(t, map[string]string{
 		"source/report.docx":           "",
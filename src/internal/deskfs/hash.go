@@ -0,0 +1,100 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/db"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// ContentHasher hashes file contents for the organizer and the other commands, keeping the hashes
+// in the workspace database of a directory so unchanged files are never read twice.
+type ContentHasher struct {
+	*trees.Hasher
+	Images    *trees.ImageHasher // Perceptual hashes of images, cached alongside, set by OpenHasher
+	workspace *db.WorkspaceDB    // Nil when hashing without a cache
+}
+
+// OpenHasher returns a hasher caching its hashes in the workspace database of dir, creating the
+// workspace if needed. Close it once done.
+func (dfs *DesktopFS) OpenHasher(dir string) (*ContentHasher, error) {
+	workspace, err := dfs.OpenJournal(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &ContentHasher{Hasher: trees.NewHasher(workspace), Images: trees.NewImageHasher(workspace), workspace: workspace}, nil
+}
+
+// Close releases the workspace database of the hasher.
+func (h *ContentHasher) Close() {
+	if h.workspace != nil {
+		h.workspace.Close()
+	}
+}
+
+// SameContent compares two regular files by size, then by SHA-256 checksum.
+func (h *ContentHasher) SameContent(a, b string) (bool, error) {
+	infoA, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Lstat(b)
+	if err != nil {
+		return false, err
+	}
+	if !infoA.Mode().IsRegular() || !infoB.Mode().IsRegular() || infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	sumA, err := h.HashPath(a, true)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := h.HashPath(b, true)
+	if err != nil {
+		return false, err
+	}
+	return sumA.Equal(sumB), nil
+}
+
+// hasher returns the hasher of the plan, opened on first use. A preview, an explanation included,
+// only reads the hashes cached in an existing workspace, it never creates or writes the workspace.
+func (p *planner) hasher() *ContentHasher {
+	if p.contentHasher != nil {
+		return p.contentHasher
+	}
+
+	p.contentHasher = &ContentHasher{Hasher: trees.NewHasher(nil)}
+	if p.params.DryRun {
+		if workspace, err := db.OpenWorkspaceDBReadOnly(createWorkspacePath(p.params.SourceDir)); err == nil {
+			p.contentHasher = &ContentHasher{Hasher: trees.NewHasher(readOnlyHashCache{workspace}), workspace: workspace}
+		}
+		return p.contentHasher
+	}
+	if hasher, err := p.dfs.OpenHasher(p.params.SourceDir); err != nil {
+		slog.Warn(fmt.Sprintf("Hashing without the workspace database: %v\n", err))
+	} else {
+		p.contentHasher = hasher
+	}
+	return p.contentHasher
+}
+
+// readOnlyHashCache serves the hashes of a workspace database without storing new ones.
+type readOnlyHashCache struct {
+	workspace *db.WorkspaceDB
+}
+
+func (c readOnlyHashCache) LookupHash(key trees.HashKey) (trees.ContentHash, bool, error) {
+	hash, found, err := c.workspace.LookupHash(key)
+	if err != nil {
+		// A workspace from before the hash cache has no table for it, and a preview cannot add it
+		slog.Debug(fmt.Sprintf("Hashing without the workspace database: %v\n", err))
+		return trees.ContentHash{}, false, nil
+	}
+	return hash, found, nil
+}
+
+func (c readOnlyHashCache) StoreHash(trees.HashKey, trees.ContentHash) error {
+	return nil
+}
@@ -0,0 +1,251 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/db"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// racyWindow is how recent a directory modification must be for its listing to be rescanned next
+// time anyway: an entry added within the same timestamp tick as the scan would go unnoticed.
+const racyWindow = 2 * time.Second
+
+// IndexStats summarizes how a walk used the directory index.
+type IndexStats struct {
+	Reused    int // Directories listed from the index
+	Rescanned int // Directories read from disk, new or modified since the last run
+	Added     int
+	Removed   int
+	Renamed   int // Files found under a new name or in another directory, they keep their index ID
+}
+
+// directoryIndex serves the listings of the directories that did not change since the last run
+// from the workspace database, so only new and modified directories are read from disk. A
+// directory gets a new modification time whenever an entry is added, removed or renamed in it;
+// edits to the content of a file do not change it, use a full rescan to pick those up.
+// Directories are listed concurrently, mu guards what the listings record.
+type directoryIndex struct {
+	db       *db.WorkspaceDB
+	known    map[string]time.Time // Directory modification times recorded by the previous runs
+	rescan   bool                 // Read every directory from disk, still reconciling with the index
+	readOnly bool                 // A preview reads the index but never writes it back
+	mu       sync.Mutex
+	scanned  []db.IndexedDirectory
+	added    map[string]*db.IndexedEntry // Entries new in the rescanned directories, by file identity
+	removed  map[string]db.IndexedEntry  // Entries gone from them, by file identity
+	pruned   []string
+	stats    IndexStats
+}
+
+// openIndex opens the directory index of the source directory. Without one, directories are
+// read from disk. A preview only reads the index of an existing workspace, it never creates or
+// writes the workspace of the source directory. params.Reindex rescans every directory.
+func (dfs *DesktopFS) openIndex(params *FilePathParams) *directoryIndex {
+	if params.NamesOnly {
+		return nil // The index records metadata, names only runs never read it
+	}
+
+	var (
+		workspace *db.WorkspaceDB
+		err       error
+	)
+	if params.DryRun {
+		if workspace, err = db.OpenWorkspaceDBReadOnly(createWorkspacePath(params.SourceDir)); err != nil {
+			return nil // No workspace yet, nothing indexed to preview from
+		}
+	} else if workspace, err = dfs.OpenJournal(params.SourceDir); err != nil {
+		slog.Warn(fmt.Sprintf("Indexing without the workspace database: %v\n", err))
+		return nil
+	}
+
+	index := &directoryIndex{
+		db:       workspace,
+		rescan:   params.Reindex,
+		readOnly: params.DryRun,
+		added:    make(map[string]*db.IndexedEntry),
+		removed:  make(map[string]db.IndexedEntry),
+	}
+	if index.known, err = workspace.IndexedDirectories(); err != nil {
+		slog.Warn(fmt.Sprintf("Rescanning every directory: %v\n", err))
+		index.known = make(map[string]time.Time)
+	}
+	return index
+}
+
+// list returns the entries of a directory sorted by name, like os.ReadDir.
+func (idx *directoryIndex) list(dir string) ([]os.DirEntry, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	known, indexed := idx.known[dir]
+	if indexed && !idx.rescan && known.Equal(info.ModTime()) {
+		entries, err := idx.db.IndexedEntries(dir)
+		if err == nil {
+			idx.mu.Lock()
+			idx.stats.Reused++
+			idx.mu.Unlock()
+			return indexedDirEntries(entries), nil
+		}
+		slog.Warn(fmt.Sprintf("Rescanning %s: %v\n", dir, err))
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]db.IndexedEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		entryInfo, err := dirEntry.Info()
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Error getting file info for %s: %v\n", dirEntry.Name(), err))
+			continue // Removed since ReadDir
+		}
+		entries = append(entries, newIndexedEntry(dir, entryInfo))
+	}
+
+	var previous []db.IndexedEntry
+	if indexed {
+		if previous, err = idx.db.IndexedEntries(dir); err != nil {
+			slog.Warn(fmt.Sprintf("Reindexing %s from scratch: %v\n", dir, err))
+		}
+	}
+
+	modTime := info.ModTime()
+	if time.Since(modTime) < racyWindow {
+		modTime = time.Time{} // Too recent to trust, see racyWindow
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.stats.Rescanned++
+	idx.reconcile(previous, entries)
+	idx.scanned = append(idx.scanned, db.IndexedDirectory{Path: dir, ModTime: modTime, Entries: entries})
+	return indexedDirEntries(entries), nil
+}
+
+// reconcile matches a fresh listing against the previous one: entries still there keep their ID,
+// the others are added or removed. Renames are paired up in save, once every directory is known.
+// The caller holds idx.mu.
+func (idx *directoryIndex) reconcile(previous, entries []db.IndexedEntry) {
+	byPath := make(map[string]db.IndexedEntry, len(previous))
+	for _, entry := range previous {
+		byPath[entry.Path] = entry
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if old, ok := byPath[entry.Path]; ok && old.Device == entry.Device && old.Inode == entry.Inode {
+			entry.ID = old.ID
+			delete(byPath, entry.Path)
+			continue
+		}
+		entry.ID = uuid.New()
+		idx.added[entryIdentity(*entry)] = entry
+		idx.stats.Added++
+	}
+
+	for _, old := range byPath {
+		idx.removed[entryIdentity(old)] = old
+		idx.stats.Removed++
+		if old.Mode.IsDir() {
+			idx.pruned = append(idx.pruned, old.Path)
+		}
+	}
+}
+
+// save pairs the added and removed entries into renames and writes the rescanned directories back,
+// unless the index is read only.
+func (idx *directoryIndex) save() error {
+	for identity, entry := range idx.added {
+		if old, ok := idx.removed[identity]; ok && entry.Device != 0 && sameIndexedFile(old, *entry) {
+			slog.Debug(fmt.Sprintf("Renamed %s to %s\n", old.Path, entry.Path))
+			entry.ID = old.ID
+			idx.stats.Renamed++
+			idx.stats.Added--
+			idx.stats.Removed--
+		}
+	}
+
+	if !idx.readOnly {
+		if err := idx.db.SaveIndex(idx.scanned, idx.pruned); err != nil {
+			return err
+		}
+	}
+	slog.Info(fmt.Sprintf("Index: %d directories reused, %d rescanned, %d files added, %d removed, %d renamed\n",
+		idx.stats.Reused, idx.stats.Rescanned, idx.stats.Added, idx.stats.Removed, idx.stats.Renamed))
+	return nil
+}
+
+func (idx *directoryIndex) close() {
+	idx.db.Close()
+}
+
+func newIndexedEntry(dir string, info os.FileInfo) db.IndexedEntry {
+	entry := db.IndexedEntry{
+		Path:    filepath.Join(dir, info.Name()),
+		Parent:  dir,
+		Mode:    info.Mode(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	entry.Device, entry.Inode, _ = trees.FileIdentity(info)
+	return entry
+}
+
+// entryIdentity identifies the file of an entry on disk, whatever its name.
+func entryIdentity(entry db.IndexedEntry) string {
+	if entry.Device == 0 && entry.Inode == 0 {
+		return entry.Path // Not available on this platform, renames are seen as an add and a remove
+	}
+	return fmt.Sprintf("%d:%d", entry.Device, entry.Inode)
+}
+
+// sameIndexedFile tells a renamed file from a new one that got the inode of a deleted file: a
+// rename keeps the size and modification time of a file.
+func sameIndexedFile(old, entry db.IndexedEntry) bool {
+	if old.Mode.Type() != entry.Mode.Type() {
+		return false
+	}
+	return entry.Mode.IsDir() || (old.Size == entry.Size && old.ModTime.Equal(entry.ModTime))
+}
+
+// indexedDirEntry serves an index entry as an fs.DirEntry, without touching the disk.
+type indexedDirEntry struct {
+	entry db.IndexedEntry
+}
+
+func indexedDirEntries(entries []db.IndexedEntry) []os.DirEntry {
+	dirEntries := make([]os.DirEntry, len(entries))
+	for i, entry := range entries {
+		dirEntries[i] = indexedDirEntry{entry: entry}
+	}
+	return dirEntries
+}
+
+func (e indexedDirEntry) Name() string               { return filepath.Base(e.entry.Path) }
+func (e indexedDirEntry) IsDir() bool                { return e.entry.Mode.IsDir() }
+func (e indexedDirEntry) Type() fs.FileMode          { return e.entry.Mode.Type() }
+func (e indexedDirEntry) Info() (fs.FileInfo, error) { return indexedFileInfo{entry: e.entry}, nil }
+
+// indexedFileInfo is the fs.FileInfo recorded for an index entry.
+type indexedFileInfo struct {
+	entry db.IndexedEntry
+}
+
+func (i indexedFileInfo) Name() string       { return filepath.Base(i.entry.Path) }
+func (i indexedFileInfo) Size() int64        { return i.entry.Size }
+func (i indexedFileInfo) Mode() fs.FileMode  { return i.entry.Mode }
+func (i indexedFileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i indexedFileInfo) IsDir() bool        { return i.entry.Mode.IsDir() }
+func (i indexedFileInfo) Sys() any           { return nil }
@@ -0,0 +1,262 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal"
+	"desktop-cleaner/internal/db"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// newIndexTestTree creates files below a directory that already has its workspace, so indexing it
+// does not modify it, and returns that directory.
+func newIndexTestTree(t testing.TB, dfs *DesktopFS, files map[string]string) string {
+	root := newTestTree(t, files)
+	journal, err := dfs.OpenJournal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+	ageDirectories(t, root)
+	return root
+}
+
+// ageDirectories sets the modification time of the directories below root an hour back, out of
+// the racyWindow, the way they would be when they were not changed since the last run. Each call
+// gives them another time, as modifying them would.
+func ageDirectories(t testing.TB, root string) {
+	past := time.Now().Add(-time.Hour)
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		if entry.Name() == internal.DefaultWorkspaceDotDir {
+			return filepath.SkipDir
+		}
+		return os.Chtimes(path, past, past)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// walkIndex lists every directory below the source directory of params through the index, as a
+// walk does, saves the index and returns how it was used.
+func walkIndex(t testing.TB, dfs *DesktopFS, params *FilePathParams) IndexStats {
+	idx := dfs.openIndex(params)
+	if idx == nil {
+		t.Fatalf("no index for %s", params.SourceDir)
+	}
+	defer idx.close()
+
+	dirs := []string{params.SourceDir}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		entries, err := idx.list(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && entry.Name() != internal.DefaultWorkspaceDotDir {
+				dirs = append(dirs, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	if err := idx.save(); err != nil {
+		t.Fatal(err)
+	}
+	return idx.stats
+}
+
+// indexedEntry returns the index entry of a file.
+func indexedEntry(t *testing.T, dfs *DesktopFS, root, path string) db.IndexedEntry {
+	journal, err := dfs.OpenJournal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	entries, err := journal.IndexedEntries(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Path == path {
+			return entry
+		}
+	}
+	t.Fatalf("%s is not indexed", path)
+	return db.IndexedEntry{}
+}
+
+var indexTestFiles = map[string]string{
+	"a.jpg":              "jpeg",
+	"deep/b.md":          "# notes",
+	"deep/deeper/c.pdf":  "pdf",
+	"deep/deeper/d.jpeg": "jpeg",
+}
+
+func TestIndexReusesUnchangedDirectories(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newIndexTestTree(t, dfs, indexTestFiles)
+	params := newTestParams(root)
+
+	// a.jpg, deep, .desktop_cleaner, b.md, deeper, c.pdf and d.jpeg
+	assert.Equal(t, IndexStats{Rescanned: 3, Added: 7}, walkIndex(t, dfs, params))
+	notes := indexedEntry(t, dfs, root, filepath.Join(root, "deep", "b.md"))
+
+	assert.Equal(t, IndexStats{Reused: 3}, walkIndex(t, dfs, params))
+	assert.Equal(t, notes, indexedEntry(t, dfs, root, filepath.Join(root, "deep", "b.md")))
+
+	// A directory modified within the racyWindow of the walk is rescanned the next time too
+	if err := os.WriteFile(filepath.Join(root, "deep", "e.md"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, IndexStats{Reused: 2, Rescanned: 1, Added: 1}, walkIndex(t, dfs, params))
+	assert.Equal(t, IndexStats{Reused: 2, Rescanned: 1}, walkIndex(t, dfs, params))
+}
+
+func TestIndexKeepsEditsUntilReindex(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newIndexTestTree(t, dfs, indexTestFiles)
+	walkIndex(t, dfs, newTestParams(root))
+	path := filepath.Join(root, "deep", "b.md")
+	before := indexedEntry(t, dfs, root, path)
+
+	// Editing a file leaves the modification time of its directory alone, the index keeps its size
+	if err := os.WriteFile(path, []byte("# notes, and many more"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, IndexStats{Reused: 3}, walkIndex(t, dfs, newTestParams(root)))
+	assert.Equal(t, before, indexedEntry(t, dfs, root, path))
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), newTestParams(root))
+	assert.Equal(t, before.Size, operationsBySource(plan)[path].Size)
+
+	// A reindex reads the size on disk, the file keeps its ID
+	params := newTestParams(root)
+	params.Reindex = true
+	plan = buildTestPlan(t, dfs, newTestConfig(nil, testRules), params)
+	assert.Equal(t, int64(len("# notes, and many more")), operationsBySource(plan)[path].Size)
+	after := indexedEntry(t, dfs, root, path)
+	assert.Equal(t, before.ID, after.ID)
+	assert.Equal(t, int64(len("# notes, and many more")), after.Size)
+}
+
+func TestIndexReconcilesChanges(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newIndexTestTree(t, dfs, indexTestFiles)
+	walkIndex(t, dfs, newTestParams(root))
+
+	renamed := indexedEntry(t, dfs, root, filepath.Join(root, "deep", "deeper", "c.pdf"))
+	kept := indexedEntry(t, dfs, root, filepath.Join(root, "deep", "deeper", "d.jpeg"))
+
+	// A file is added, another removed, and c.pdf moves to another directory under a new name
+	if err := os.WriteFile(filepath.Join(root, "new.md"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "deep", "deeper", "c.pdf"), filepath.Join(root, "deep", "moved.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	ageDirectories(t, root)
+
+	assert.Equal(t, IndexStats{Rescanned: 3, Added: 1, Removed: 1, Renamed: 1}, walkIndex(t, dfs, newTestParams(root)))
+	assert.Equal(t, renamed.ID, indexedEntry(t, dfs, root, filepath.Join(root, "deep", "moved.pdf")).ID)
+	assert.Equal(t, kept.ID, indexedEntry(t, dfs, root, filepath.Join(root, "deep", "deeper", "d.jpeg")).ID)
+	added := indexedEntry(t, dfs, root, filepath.Join(root, "new.md"))
+	assert.NotEqual(t, uuid.Nil, added.ID)
+
+	journal, err := dfs.OpenJournal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	entries, err := journal.IndexedEntries(root)
+	assert.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, filepath.Base(entry.Path))
+	}
+	assert.Equal(t, []string{internal.DefaultWorkspaceDotDir, "deep", "new.md"}, names)
+}
+
+func TestReindexRescans(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newIndexTestTree(t, dfs, indexTestFiles)
+	walkIndex(t, dfs, newTestParams(root))
+	before := indexedEntry(t, dfs, root, filepath.Join(root, "deep", "b.md"))
+
+	params := newTestParams(root)
+	params.Reindex = true
+	assert.Equal(t, IndexStats{Rescanned: 3}, walkIndex(t, dfs, params))
+	assert.Equal(t, before, indexedEntry(t, dfs, root, filepath.Join(root, "deep", "b.md")), "a rescan keeps the IDs")
+
+	// A preview does not create the workspace for an index
+	params = newTestParams(t.TempDir())
+	params.DryRun = true
+	assert.Nil(t, dfs.openIndex(params))
+	assert.NoDirExists(t, createWorkspacePath(params.SourceDir))
+}
+
+func TestDryRunIndexIsReadOnly(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newIndexTestTree(t, dfs, indexTestFiles)
+	walkIndex(t, dfs, newTestParams(root))
+	if err := os.WriteFile(filepath.Join(root, "deep", "e.md"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A preview reads the index and rescans what changed, but leaves the database as it was
+	dbPath := filepath.Join(createWorkspacePath(root), "workspace.db")
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := newTestParams(root)
+	params.DryRun = true
+	assert.Equal(t, IndexStats{Reused: 2, Rescanned: 1, Added: 1}, walkIndex(t, dfs, params))
+	after, err := os.ReadFile(dbPath)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// The next run still finds the directory modified
+	assert.Equal(t, IndexStats{Reused: 2, Rescanned: 1, Added: 1}, walkIndex(t, dfs, newTestParams(root)))
+}
+
+// BenchmarkIndex compares the first scan of a tree with the runs that reuse its index.
+func BenchmarkIndex(b *testing.B) {
+	files := make(map[string]string)
+	for dir := 0; dir < 50; dir++ {
+		for file := 0; file < 20; file++ {
+			files[fmt.Sprintf("dir%02d/sub/file%02d.md", dir, file)] = "# notes"
+		}
+	}
+
+	for _, reuse := range []bool{false, true} {
+		name := "scan"
+		if reuse {
+			name = "reuse"
+		}
+		b.Run(name, func(b *testing.B) {
+			dfs := newTestDeskFS(b)
+			root := newIndexTestTree(b, dfs, files)
+			params := newTestParams(root)
+			params.Reindex = !reuse
+			walkIndex(b, dfs, newTestParams(root))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				walkIndex(b, dfs, params)
+			}
+		})
+	}
+}
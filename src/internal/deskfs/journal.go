@@ -0,0 +1,275 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/db"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const journalPerformer = "organize"
+
+// OpenJournal opens the workspace database of a source directory, where organize runs are journaled.
+// The workspace directory is created when missing.
+func (dfs *DesktopFS) OpenJournal(sourceDir string) (*db.WorkspaceDB, error) {
+	workspaceDir := createWorkspacePath(sourceDir)
+	if err := os.MkdirAll(workspaceDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace directory %s: %w", workspaceDir, err)
+	}
+
+	journal, err := db.NewWorkspaceDB(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return journal, nil
+}
+
+// journalOperations converts the plan into journal records. Skipped operations have no effect
+// and are not journaled; the returned IDs are indexed like plan.Operations, uuid.Nil for skips.
+func journalOperations(plan *OrganizePlan) ([]db.OperationHistory, []uuid.UUID) {
+	var records []db.OperationHistory
+	ids := make([]uuid.UUID, len(plan.Operations))
+
+	for i, op := range plan.Operations {
+		operation, ok := historyOperationType(op.Type)
+		if !ok {
+			continue
+		}
+
+		ids[i] = uuid.New()
+		records = append(records, db.OperationHistory{
+			ID:           ids[i],
+			RunID:        plan.ID,
+			Seq:          i,
+			Operation:    operation,
+			OldPath:      op.Source,
+			NewPath:      op.Destination,
+			Directory:    op.Directory,
+			RemoveSource: op.RemoveSource,
+			Status:       db.OperationPending,
+			TimeStamp:    time.Now(),
+			PerformedBy:  journalPerformer,
+		})
+	}
+	return records, ids
+}
+
+func historyOperationType(opType OperationType) (db.OperationType, bool) {
+	switch opType {
+	case OpCreateDir:
+		return db.OperationTypeCreate, true
+	case OpMove, OpRename:
+		return db.OperationTypeMove, true
+	case OpTrash:
+		return db.OperationTypeDelete, true
+	case OpHardlink:
+		return db.OperationTypeUpdate, true
+	case OpCopy:
+		return db.OperationTypeCopy, true
+	}
+	return 0, false
+}
+
+// operationFromHistory rebuilds a plan operation from its journal record.
+func operationFromHistory(record db.OperationHistory) Operation {
+	op := Operation{
+		Source:       record.OldPath,
+		Destination:  record.NewPath,
+		Directory:    record.Directory,
+		RemoveSource: record.RemoveSource,
+	}
+
+	switch record.Operation {
+	case db.OperationTypeCreate:
+		op.Type = OpCreateDir
+	case db.OperationTypeCopy:
+		op.Type = OpCopy
+	case db.OperationTypeDelete:
+		op.Type = OpTrash
+	case db.OperationTypeUpdate:
+		op.Type = OpHardlink
+	default:
+		op.Type = OpMove
+	}
+	return op
+}
+
+// InterruptedRuns returns the journaled runs of a source directory that never completed.
+func (dfs *DesktopFS) InterruptedRuns(sourceDir string) ([]db.OrganizeRun, error) {
+	if _, err := os.Stat(createWorkspacePath(sourceDir)); os.IsNotExist(err) {
+		return nil, nil // Nothing was ever journaled here
+	}
+
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	return journal.InterruptedRuns()
+}
+
+// RunProgress returns how many of the journaled operations of a run were applied, out of the total.
+func (dfs *DesktopFS) RunProgress(sourceDir string, runID uuid.UUID) (int, int, error) {
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer journal.Close()
+
+	records, err := journal.RunOperations(runID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	done := 0
+	for _, record := range records {
+		if record.Status == db.OperationDone {
+			done++
+		}
+	}
+	return done, len(records), nil
+}
+
+// ResumeRun applies the operations of an interrupted run that are still pending. An operation the
+// crash interrupted right after it took effect is detected on disk and only marked done.
+func (dfs *DesktopFS) ResumeRun(sourceDir string, runID uuid.UUID) error {
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	records, err := journal.RunOperations(runID)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Status != db.OperationPending {
+			continue
+		}
+
+		op := operationFromHistory(record)
+		if operationApplied(op) {
+			slog.Info(fmt.Sprintf("Operation %s %s already applied\n", op.Type, op.Destination))
+		} else if err := dfs.applyOperation(op, false); err != nil {
+			journal.FinishRun(runID, db.RunFailed)
+			return fmt.Errorf("%s %s failed: %w", op.Type, op.Source, err)
+		}
+
+		if err := journal.SetOperationStatus(record.ID, db.OperationDone); err != nil {
+			return err
+		}
+	}
+
+	return journal.FinishRun(runID, db.RunCompleted)
+}
+
+// RollbackRun reverses the applied operations of a run, last first, and marks the run rolled back.
+func (dfs *DesktopFS) RollbackRun(sourceDir string, runID uuid.UUID) error {
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	records, err := journal.RunOperations(runID)
+	if err != nil {
+		return err
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		op := operationFromHistory(record)
+
+		// A pending operation may have taken effect right before the crash
+		if record.Status == db.OperationUndone || (record.Status == db.OperationPending && !operationApplied(op)) {
+			continue
+		}
+
+		if err := dfs.reverseOperation(op, false); err != nil {
+			return fmt.Errorf("failed to roll back %s %s: %w", op.Type, op.Destination, err)
+		}
+		if err := journal.SetOperationStatus(record.ID, db.OperationUndone); err != nil {
+			return err
+		}
+	}
+
+	return journal.FinishRun(runID, db.RunRolledBack)
+}
+
+// operationApplied checks the disk for the effect of an operation.
+func operationApplied(op Operation) bool {
+	switch op.Type {
+	case OpCreateDir:
+		return exists(op.Destination)
+	case OpMove, OpRename, OpTrash:
+		return !exists(op.Source) && exists(op.Destination)
+	case OpCopy:
+		// A copy that keeps its source is simply made again, that is harmless
+		return op.RemoveSource && !exists(op.Source) && exists(op.Destination)
+	case OpHardlink:
+		linked, err := sameFile(op.Source, op.Destination)
+		return err == nil && linked
+	}
+	return false
+}
+
+// reverseOperation undoes a single applied operation. A file found at the original path is only
+// replaced with overwrite set, a directory never is.
+func (dfs *DesktopFS) reverseOperation(op Operation, overwrite bool) error {
+	switch op.Type {
+	case OpCreateDir:
+		// Only an empty directory is removed, anything added to it since is kept
+		if err := os.Remove(op.Destination); err != nil && !os.IsNotExist(err) {
+			slog.Warn(fmt.Sprintf("Keeping directory %s: %v\n", op.Destination, err))
+		}
+		return nil
+	case OpCopy:
+		if !op.RemoveSource {
+			return os.RemoveAll(op.Destination)
+		}
+	case OpHardlink:
+		return dfs.unlinkDuplicate(op.Source, op.Destination)
+	}
+
+	if !exists(op.Destination) {
+		slog.Warn(fmt.Sprintf("Cannot restore %s, %s no longer exists\n", op.Source, op.Destination))
+		return nil
+	}
+	if info, err := os.Lstat(op.Source); err == nil {
+		if !overwrite || info.IsDir() {
+			return fmt.Errorf("cannot restore %s, the path is taken", op.Source)
+		}
+		if err := os.Remove(op.Source); err != nil {
+			return fmt.Errorf("failed to overwrite %s: %w", op.Source, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(op.Source), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to recreate directory %s: %w", filepath.Dir(op.Source), err)
+	}
+	slog.Info(fmt.Sprintf("Restoring %s from %s\n", op.Source, op.Destination))
+	if err := dfs.Move(&trees.DirectoryNode{Path: op.Destination}, op.Source, op.Directory, false); err != nil {
+		return err
+	}
+	if op.Type == OpTrash {
+		// The file left the trash, so does its entry
+		if err := os.Remove(trashInfoPath(op.Destination)); err != nil && !os.IsNotExist(err) {
+			slog.Warn(fmt.Sprintf("Failed to remove the trash entry of %s: %v\n", op.Source, err))
+		}
+	}
+	return nil
+}
+
+// exists reports whether something, a dangling symlink included, is at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,188 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal/db"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// interruptRun journals plan as a running run and applies its first applied operations, as a run
+// killed at that point would have. The operation after them takes effect but stays pending, as if
+// the crash happened right before it was marked done.
+func interruptRun(t *testing.T, dfs *DesktopFS, plan *OrganizePlan, applied int) {
+	journal, err := dfs.OpenJournal(plan.SourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	records, ids := journalOperations(plan)
+	run := &db.OrganizeRun{ID: plan.ID, SourceDir: plan.SourceDir, TargetDir: plan.TargetDir, Status: db.RunRunning, StartedAt: time.Now()}
+	if err := journal.BeginRun(run, records); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, op := range plan.Operations {
+		if ids[i] == uuid.Nil {
+			continue
+		}
+		if applied < 0 {
+			return
+		}
+		if err := dfs.applyOperation(op, false); err != nil {
+			t.Fatal(err)
+		}
+		if applied > 0 {
+			if err := journal.SetOperationStatus(ids[i], db.OperationDone); err != nil {
+				t.Fatal(err)
+			}
+		}
+		applied--
+	}
+}
+
+// runStatus returns the journaled status of a run.
+func runStatus(t *testing.T, dfs *DesktopFS, sourceDir string, runID uuid.UUID) db.RunStatus {
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	run, err := journal.GetRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run.Status
+}
+
+// newInterruptedRun plans three files to three new categories, then interrupts the run after the
+// move of the first file and the directory of the second, with the move of the second applied but
+// still pending. The operations are: create Pics, move a.jpg, create Notes, move b.md, create
+// Docs, move c.pdf.
+func newInterruptedRun(t *testing.T) (*DesktopFS, string, *OrganizePlan) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a.jpg": "jpeg",
+		"b.md":  "# notes",
+		"c.pdf": "pdf",
+	})
+
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), newTestParams(root))
+	assert.Len(t, plan.Operations, 6)
+	interruptRun(t, dfs, plan, 3)
+	return dfs, root, plan
+}
+
+func TestApplyPlanJournalsTheRun(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"a.jpg": "jpeg", "b.md": "# notes"})
+
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), newTestParams(root))
+	assert.NoError(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}))
+
+	assert.Equal(t, db.RunCompleted, runStatus(t, dfs, root, plan.ID))
+	done, total, err := dfs.RunProgress(root, plan.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, total, done)
+
+	interrupted, err := dfs.InterruptedRuns(root)
+	assert.NoError(t, err)
+	assert.Empty(t, interrupted)
+}
+
+func TestInterruptedRuns(t *testing.T) {
+	dfs, root, plan := newInterruptedRun(t)
+
+	interrupted, err := dfs.InterruptedRuns(root)
+	assert.NoError(t, err)
+	if assert.Len(t, interrupted, 1) {
+		assert.Equal(t, plan.ID, interrupted[0].ID)
+		assert.Equal(t, db.RunRunning, interrupted[0].Status)
+	}
+
+	done, total, err := dfs.RunProgress(root, plan.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, done)
+	assert.Equal(t, 6, total)
+
+	// A directory that was never organized has no journal, and gets none by being asked
+	untouched := t.TempDir()
+	interrupted, err = dfs.InterruptedRuns(untouched)
+	assert.NoError(t, err)
+	assert.Empty(t, interrupted)
+	assert.NoDirExists(t, createWorkspacePath(untouched))
+}
+
+func TestResumeRun(t *testing.T) {
+	dfs, root, plan := newInterruptedRun(t)
+
+	assert.NoError(t, dfs.ResumeRun(root, plan.ID))
+
+	assert.Equal(t, map[string]string{
+		"Pics": "/", "Pics/a.jpg": "jpeg",
+		"Notes": "/", "Notes/b.md": "# notes",
+		"Docs": "/", "Docs/c.pdf": "pdf",
+	}, snapshotTree(t, root))
+	assert.Equal(t, db.RunCompleted, runStatus(t, dfs, root, plan.ID))
+	done, total, err := dfs.RunProgress(root, plan.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, total, done)
+
+	interrupted, err := dfs.InterruptedRuns(root)
+	assert.NoError(t, err)
+	assert.Empty(t, interrupted)
+}
+
+func TestRollbackRun(t *testing.T) {
+	dfs, root, plan := newInterruptedRun(t)
+
+	assert.NoError(t, dfs.RollbackRun(root, plan.ID))
+
+	// The move applied right before the crash is reversed too, the directories created are removed
+	assert.Equal(t, map[string]string{"a.jpg": "jpeg", "b.md": "# notes", "c.pdf": "pdf"}, snapshotTree(t, root))
+	assert.Equal(t, db.RunRolledBack, runStatus(t, dfs, root, plan.ID))
+
+	journal, err := dfs.OpenJournal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	records, err := journal.RunOperations(plan.ID)
+	assert.NoError(t, err)
+	var statuses []db.OperationStatus
+	for _, record := range records {
+		statuses = append(statuses, record.Status)
+	}
+	assert.Equal(t, []db.OperationStatus{db.OperationUndone, db.OperationUndone, db.OperationUndone, db.OperationUndone, db.OperationPending, db.OperationPending}, statuses)
+}
+
+func TestRollbackFailedRun(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a.jpg": "jpeg",
+		"b.md":  "# notes",
+		"c.pdf": "pdf",
+	})
+
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), newTestParams(root))
+	if err := os.Remove(filepath.Join(root, "c.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{Jobs: 1}))
+
+	assert.Equal(t, db.RunFailed, runStatus(t, dfs, root, plan.ID))
+	interrupted, err := dfs.InterruptedRuns(root)
+	assert.NoError(t, err)
+	assert.Len(t, interrupted, 1)
+
+	assert.NoError(t, dfs.RollbackRun(root, plan.ID))
+	assert.Equal(t, map[string]string{"a.jpg": "jpeg", "b.md": "# notes"}, snapshotTree(t, root))
+}
@@ -0,0 +1,129 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DirectoryConfig is a rules file placed in a directory to adjust the config for that subtree.
+// Its categories are added to the parent rules, replacing categories with the same path and
+// winning ties against the inherited ones.
+//
+//	inherit = true                 # false starts from no categories at all
+//	disable = ["Pics", "Torrents"] # drops parent categories, and their subcategories
+//	target_dir = "."               # relative to the directory holding the file
+//
+//	[file_types]
+//	"Reports" = [".pdf", ".docx"]
+type DirectoryConfig struct {
+	Inherit       *bool               `toml:"inherit"`
+	Disable       []string            `toml:"disable"`
+	TargetDir     string              `toml:"target_dir"`
+	FileTypes     map[string][]string `toml:"file_types"`
+	FileTypeOrder []string            `toml:"-"`
+}
+
+// LoadDirectoryConfig reads the rules file of a directory. It returns nil when the directory has none.
+func LoadDirectoryConfig(dir string) (*DirectoryConfig, error) {
+	configPath := filepath.Join(dir, internal.DefaultDirectoryConfigFile)
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error checking for %s: %w", configPath, err)
+	}
+
+	var dirConfig DirectoryConfig
+	md, err := toml.DecodeFile(configPath, &dirConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", configPath, err)
+	}
+	dirConfig.FileTypeOrder = fileTypeOrder(md)
+
+	return &dirConfig, nil
+}
+
+// Merge returns the effective config for the subtree rooted at dir, with the directory's rules
+// applied on top of this config. The receiver is left untouched.
+func (dfc *DeskFSConfig) Merge(dir string, dirConfig *DirectoryConfig) *DeskFSConfig {
+	fileTypes := make(map[string][]string)
+
+	// The directory's own categories come first, so they win ties against inherited ones
+	order := trees.OrderFileTypes(dirConfig.FileTypeOrder, dirConfig.FileTypes)
+	for _, path := range order {
+		fileTypes[path] = dirConfig.FileTypes[path]
+	}
+
+	if dirConfig.Inherit == nil || *dirConfig.Inherit {
+		for _, path := range dfc.FileTypeOrder {
+			if _, replaced := fileTypes[path]; replaced || isDisabled(path, dirConfig.Disable) {
+				continue
+			}
+			fileTypes[path] = dfc.FileTypes[path]
+			order = append(order, path)
+		}
+	}
+
+	merged := &DeskFSConfig{
+		Config:       dfc.Config,
+		FileTypeTree: trees.NewFileTypeTree(),
+		TargetDir:    dfc.TargetDir,
+		CacheDir:     dfc.CacheDir,
+		Projects:     dfc.Projects,
+	}
+	merged.BuildFileTypeTree(&IntermediateConfig{FileTypes: fileTypes, FileTypeOrder: order})
+
+	if dirConfig.TargetDir != "" {
+		merged.TargetDir = dirConfig.TargetDir
+		if !filepath.IsAbs(merged.TargetDir) {
+			merged.TargetDir = filepath.Join(dir, merged.TargetDir)
+		}
+	}
+
+	return merged
+}
+
+// TargetRoot returns the directory files are organized into, a per-directory target_dir
+// takes over from the one given on the command line.
+func (dfc *DeskFSConfig) TargetRoot(params *FilePathParams) string {
+	if dfc.TargetDir != "" {
+		return dfc.TargetDir
+	}
+	return params.TargetDir
+}
+
+// isDisabled reports whether a category, or one of its parent categories, is in the disable list.
+func isDisabled(path string, disabled []string) bool {
+	for _, name := range disabled {
+		name = strings.Trim(name, "/")
+		if path == name || strings.HasPrefix(path, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// directoryConfig returns the effective config for a directory, merging its rules file if it has one.
+func (dfs *DesktopFS) directoryConfig(node *trees.DirectoryNode, cfg *DeskFSConfig) (*DeskFSConfig, error) {
+	dirConfig, err := LoadDirectoryConfig(node.Path)
+	if err != nil || dirConfig == nil {
+		return cfg, err
+	}
+
+	slog.Info(fmt.Sprintf("Applying directory rules from %s\n", filepath.Join(node.Path, internal.DefaultDirectoryConfigFile)))
+	merged := cfg.Merge(node.Path, dirConfig)
+	if err := merged.CheckRules(); err != nil {
+		return nil, fmt.Errorf("invalid rules in %s: %w", filepath.Join(node.Path, internal.DefaultDirectoryConfigFile), err)
+	}
+	for _, overlap := range merged.Validate() {
+		slog.Warn(fmt.Sprintf("Overlapping rules in %s: %s\n", node.Path, overlap))
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,110 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal"
+	"desktop-cleaner/internal/filesystem/trees"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newOverridesConfig returns a config with a few nested categories, the base of the merge tests.
+func newOverridesConfig() *DeskFSConfig {
+	return NewDeskFSConfig().BuildFileTypeTree(&IntermediateConfig{
+		FileTypes: map[string][]string{
+			"Docs":         {".txt", ".md"},
+			"Docs/Reports": {".pdf"},
+			"Pics":         {".jpg"},
+		},
+		FileTypeOrder: []string{"Docs", "Docs/Reports", "Pics"},
+	})
+}
+
+// categoryOf returns the category path a file name resolves to, or "" when nothing matches.
+func categoryOf(cfg *DeskFSConfig, name string) string {
+	match, found := cfg.FileTypeTree.Resolve(&trees.FileNode{Name: name, Extension: trees.FileExtension(name)})
+	if !found {
+		return ""
+	}
+	return match.Node.Path()
+}
+
+func TestMergeDirectoryConfig(t *testing.T) {
+	base := newOverridesConfig()
+	dir := filepath.Join("/home", "user", "Work")
+
+	merged := base.Merge(dir, &DirectoryConfig{
+		Disable:   []string{"Docs/"},
+		TargetDir: "Sorted",
+		FileTypes: map[string][]string{
+			"Pics":    {".png"}, // replaces the inherited category
+			"Scripts": {".sh"},  // added
+		},
+	})
+
+	assert.Equal(t, "Scripts", categoryOf(merged, "setup.sh"))
+	assert.Equal(t, "Pics", categoryOf(merged, "photo.png"))
+	assert.Equal(t, "", categoryOf(merged, "photo.jpg"), "the override replaces the category rules")
+	assert.Equal(t, "", categoryOf(merged, "notes.txt"), "disabled category")
+	assert.Equal(t, "", categoryOf(merged, "report.pdf"), "subcategory of a disabled category")
+	assert.Equal(t, filepath.Join(dir, "Sorted"), merged.TargetDir)
+
+	// The parent config is left untouched
+	assert.Equal(t, "Pics", categoryOf(base, "photo.jpg"))
+	assert.Equal(t, "Docs/Reports", categoryOf(base, "report.pdf"))
+	assert.Equal(t, "", base.TargetDir)
+
+	inherit := false
+	isolated := base.Merge(dir, &DirectoryConfig{
+		Inherit:   &inherit,
+		TargetDir: "/srv/sorted",
+		FileTypes: map[string][]string{"Scripts": {".sh"}},
+	})
+	assert.Equal(t, "Scripts", categoryOf(isolated, "setup.sh"))
+	assert.Equal(t, "", categoryOf(isolated, "notes.txt"))
+	assert.Equal(t, "/srv/sorted", isolated.TargetDir)
+}
+
+func TestIsDisabled(t *testing.T) {
+	disabled := []string{"Docs", "/Pics/Raw/"}
+
+	assert.True(t, isDisabled("Docs", disabled))
+	assert.True(t, isDisabled("Docs/Reports", disabled))
+	assert.True(t, isDisabled("Pics/Raw", disabled))
+	assert.False(t, isDisabled("Pics", disabled))
+	assert.False(t, isDisabled("Documents", disabled))
+	assert.False(t, isDisabled("Docs", nil))
+}
+
+func TestDirectoryConfigSubtree(t *testing.T) {
+	dir, cleanup := setupTestDir(t, map[string]string{
+		"source/notes.txt":         "",
+		"source/work/todo.txt":     "",
+		"source/work/deep/log.txt": "",
+		"source/work/" + internal.DefaultDirectoryConfigFile: `[file_types]
+"Work/Notes" = [".txt"]`,
+		"source/other/list.txt":   "",
+		"target/placeholder.keep": "",
+	})
+	defer cleanup()
+
+	params := &FilePathParams{
+		SourceDir:          filepath.Join(dir, "source"),
+		TargetDir:          filepath.Join(dir, "target"),
+		Recursive:          true,
+		MaxDepth:           -1,
+		ConflictResolution: RenameSuffix,
+	}
+	dfs := newTestDeskFS(t)
+	assert.NoError(t, dfs.EnhancedOrganize(newOverridesConfig(), params))
+
+	// The rules file applies to its directory and below, never to its siblings or parent
+	assert.FileExists(t, filepath.Join(dir, "target", "Work", "Notes", "todo.txt"))
+	assert.FileExists(t, filepath.Join(dir, "target", "Work", "Notes", "log.txt"))
+	assert.FileExists(t, filepath.Join(dir, "target", "Docs", "notes.txt"))
+	assert.FileExists(t, filepath.Join(dir, "target", "Docs", "list.txt"))
+
+	// and stays with its directory
+	assert.FileExists(t, filepath.Join(dir, "source", "work", internal.DefaultDirectoryConfigFile))
+}
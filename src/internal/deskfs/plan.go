@@ -0,0 +1,264 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+)
+
+type OperationType string
+
+const (
+	OpCreateDir OperationType = "create-dir"
+	OpMove      OperationType = "move"
+	OpCopy      OperationType = "copy"
+	OpRename    OperationType = "rename" // Move under a new name, the destination was taken
+	OpSkip      OperationType = "skip"
+	OpTrash     OperationType = "trash"    // Move of a file that lost a conflict to the trash
+	OpHardlink  OperationType = "hardlink" // Replacement of a duplicate with a hardlink to the source
+)
+
+// Operation is a single step of an OrganizePlan.
+type Operation struct {
+	Type         OperationType `json:"type"`
+	Source       string        `json:"source,omitempty"`
+	Destination  string        `json:"destination,omitempty"`
+	Reason       string        `json:"reason,omitempty"`
+	Directory    bool          `json:"directory,omitempty"`     // The source is a whole directory, e.g. a project
+	RemoveSource bool          `json:"remove_source,omitempty"` // Copy followed by removal of the source (--remove)
+	Size         int64         `json:"size,omitempty"`          // Bytes of a file, when its metadata was read
+}
+
+// OrganizePlan is the ordered list of operations an organize run performs. It is computed from the
+// DirectoryTree and the DeskFSConfig without side effects, then executed by ApplyPlan.
+type OrganizePlan struct {
+	ID         uuid.UUID   `json:"id"` // Identifies the run in the journal once the plan is applied
+	SourceDir  string      `json:"source_dir"`
+	TargetDir  string      `json:"target_dir"`
+	Operations []Operation `json:"operations"`
+}
+
+// Transfers returns the number of moves, renames and copies of the plan and their total size.
+func (plan *OrganizePlan) Transfers() (int, int64) {
+	files, bytes := 0, int64(0)
+	for _, op := range plan.Operations {
+		if op.Type == OpMove || op.Type == OpRename || op.Type == OpCopy {
+			files++
+			bytes += op.Size
+		}
+	}
+	return files, bytes
+}
+
+// Counts returns the number of operations per type.
+func (plan *OrganizePlan) Counts() map[OperationType]int {
+	counts := make(map[OperationType]int)
+	for _, op := range plan.Operations {
+		counts[op.Type]++
+	}
+	return counts
+}
+
+// WriteTable prints the plan as an aligned table.
+func (plan *OrganizePlan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tSOURCE\tDESTINATION\tREASON")
+	for _, op := range plan.Operations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", op.Type, op.Source, op.Destination, op.Reason)
+	}
+	return tw.Flush()
+}
+
+// planner accumulates the operations of a plan. It tracks the directories and destinations the plan
+// already claims, so two files planned to the same destination are treated as a conflict.
+type planner struct {
+	dfs    *DesktopFS
+	params *FilePathParams
+	plan   *OrganizePlan
+	dirs   map[string]bool
+	taken  map[string]string // Claimed destination to its source
+
+	contentHasher *ContentHasher // Compares contents for the dedupe strategy, see hasher
+}
+
+// BuildPlan indexes the source directory and computes the operations that organize it, following
+// per-directory rules, projects, fallbacks and the conflict resolution. Nothing is moved.
+func (dfs *DesktopFS) BuildPlan(cfg *DeskFSConfig, params *FilePathParams) (*OrganizePlan, error) {
+	if err := dfs.IndexDirectory(cfg, params); err != nil {
+		return nil, err
+	}
+	dfs.Unmatched = NewUnmatchedReport()
+
+	p := &planner{
+		dfs:    dfs,
+		params: params,
+		plan:   &OrganizePlan{ID: uuid.New(), SourceDir: params.SourceDir, TargetDir: params.TargetDir, Operations: []Operation{}},
+		dirs:   make(map[string]bool),
+		taken:  make(map[string]string),
+	}
+	defer p.close()
+
+	root := dfs.WorkspaceManager.centralDB.DirectoryTree.Root
+	if stop, err := sourceProject(root, cfg); stop {
+		if err != nil {
+			return nil, err
+		}
+		p.skip(root.Path, "", "source directory is a project, left in place")
+		return p.plan, nil
+	}
+
+	if err := p.planDirectory(root, cfg); err != nil {
+		return nil, err
+	}
+
+	files, bytes := p.plan.Transfers()
+	dfs.report(ProgressEvent{Type: EventPlanReady, Files: files, Bytes: bytes})
+	return p.plan, nil
+}
+
+// close releases what the planner opened along the way.
+func (p *planner) close() {
+	if p.contentHasher != nil {
+		p.contentHasher.Close()
+	}
+}
+
+func (p *planner) planDirectory(node *trees.DirectoryNode, cfg *DeskFSConfig) error {
+	// A rules file in this directory applies to the whole subtree
+	cfg, err := p.dfs.directoryConfig(node, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load directory config: %w", err)
+	}
+
+	for _, fileNode := range node.Files {
+		p.planFile(fileNode, cfg)
+	}
+
+	if !p.params.Recursive {
+		return nil
+	}
+
+	for _, childDir := range node.Children {
+		// Never descend into a project, it is moved whole or left alone
+		if childDir.IsProject {
+			p.planProject(childDir, cfg)
+			continue
+		}
+
+		if err := p.planDirectory(childDir, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *planner) planFile(fileNode *trees.FileNode, cfg *DeskFSConfig) {
+	targetDir, category, found := p.dfs.determineTargetFolder(context.Background(), fileNode, cfg, p.params)
+	if !found {
+		p.skip(fileNode.Path, "", fmt.Sprintf("no mapping found for extension %q", fileNode.Extension))
+		return
+	}
+
+	destDir := filepath.Join(cfg.TargetRoot(p.params), targetDir)
+	destPath := filepath.Join(destDir, fileNode.Name)
+	if destPath == fileNode.Path {
+		p.skip(fileNode.Path, destPath, "already organized")
+		return
+	}
+
+	p.transfer(fileNode.Path, destDir, destPath, false, fileNode.Metadata.Size, fmt.Sprintf("category %s", targetDir), p.conflictStrategy(category))
+}
+
+func (p *planner) planProject(node *trees.DirectoryNode, cfg *DeskFSConfig) {
+	if cfg.Projects.Mode != ProjectMove {
+		p.skip(node.Path, "", "project, left in place")
+		return
+	}
+
+	destDir := filepath.Join(cfg.TargetRoot(p.params), cfg.Projects.Category)
+	destPath := filepath.Join(destDir, filepath.Base(node.Path))
+	if destPath == node.Path {
+		p.skip(node.Path, destPath, "already organized")
+		return
+	}
+
+	p.transfer(node.Path, destDir, destPath, true, 0, "project", p.params.ConflictResolution)
+}
+
+// transfer plans a move or copy of source into destDir, applying the conflict resolution when destPath is taken.
+func (p *planner) transfer(source, destDir, destPath string, directory bool, size int64, reason string, resolution ConflictResolutionType) {
+	opType := OpMove
+	if p.params.CopyFiles {
+		opType = OpCopy
+	}
+
+	if p.exists(destPath) {
+		resolved, resolvedReason, proceed := p.resolveConflict(source, destPath, directory, resolution, reason)
+		if !proceed {
+			return
+		}
+		if resolved != destPath && opType == OpMove {
+			opType = OpRename
+		}
+		destPath, reason = resolved, resolvedReason
+	}
+
+	p.createDir(destDir)
+	p.taken[destPath] = source
+	p.plan.Operations = append(p.plan.Operations, Operation{
+		Type:         opType,
+		Source:       source,
+		Destination:  destPath,
+		Reason:       reason,
+		Directory:    directory,
+		RemoveSource: opType == OpCopy && p.params.RemoveAfter,
+		Size:         size,
+	})
+	p.dfs.report(ProgressEvent{Type: EventFilePlanned, Path: source, Destination: destPath, Bytes: size})
+}
+
+// createDir plans the creation of a destination directory, once, when it does not exist yet.
+func (p *planner) createDir(dir string) {
+	if p.dirs[dir] {
+		return
+	}
+	p.dirs[dir] = true
+
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return
+	}
+	p.plan.Operations = append(p.plan.Operations, Operation{Type: OpCreateDir, Destination: dir})
+}
+
+func (p *planner) skip(source, destination, reason string) {
+	p.plan.Operations = append(p.plan.Operations, Operation{Type: OpSkip, Source: source, Destination: destination, Reason: reason})
+}
+
+// exists reports whether a path is on disk or already claimed by the plan.
+func (p *planner) exists(path string) bool {
+	if p.taken[path] != "" {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// uniqueDestination appends a numeric suffix until the path is free, on disk and in the plan.
+func (p *planner) uniqueDestination(path string) string {
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	base := filepath.Base(path[:len(path)-len(ext)])
+
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
+		if !p.exists(candidate) {
+			return candidate
+		}
+	}
+}
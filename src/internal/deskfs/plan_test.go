@@ -0,0 +1,198 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestConfig returns the config of rules, its categories in the order given.
+func newTestConfig(order []string, rules map[string][]string) *DeskFSConfig {
+	config := &IntermediateConfig{FileTypes: rules, FileTypeOrder: order, Projects: ProjectsConfig{}.withDefaults()}
+	return NewDeskFSConfig().BuildFileTypeTree(config).ApplySettings(config)
+}
+
+// newTestTree creates files, by path relative to a temporary directory, and returns that directory.
+func newTestTree(t testing.TB, files map[string]string) string {
+	root := t.TempDir()
+	for path, content := range files {
+		fullPath := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// snapshotTree returns the content of every file below root, by relative path, directories mapping
+// to "/". The workspace, where planning keeps its index, is left out.
+func snapshotTree(t *testing.T, root string) map[string]string {
+	snapshot := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if entry.IsDir() && entry.Name() == internal.DefaultWorkspaceDotDir {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() {
+			snapshot[rel] = "/"
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		snapshot[rel] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+// newTestParams organizes source in place, recursively.
+func newTestParams(source string) *FilePathParams {
+	params := NewFilePathParams()
+	params.SourceDir = source
+	params.TargetDir = source
+	return params
+}
+
+// buildTestPlan plans params on a new directory tree, a DesktopFS adds every walk to the tree it has.
+func buildTestPlan(t *testing.T, dfs *DesktopFS, cfg *DeskFSConfig, params *FilePathParams) *OrganizePlan {
+	dfs.WorkspaceManager.centralDB.DirectoryTree = nil
+	plan, err := dfs.BuildPlan(cfg, params)
+	if err != nil {
+		t.Fatalf("failed to plan %s: %v", params.SourceDir, err)
+	}
+	return plan
+}
+
+// operationsBySource indexes the operations of a plan that have a source.
+func operationsBySource(plan *OrganizePlan) map[string]Operation {
+	ops := make(map[string]Operation)
+	for _, op := range plan.Operations {
+		if op.Source != "" {
+			ops[op.Source] = op
+		}
+	}
+	return ops
+}
+
+// operationIndex returns the position of the first operation of type opType on path, or -1.
+func operationIndex(plan *OrganizePlan, opType OperationType, path string) int {
+	for i, op := range plan.Operations {
+		if op.Type == opType && (op.Source == path || op.Destination == path) {
+			return i
+		}
+	}
+	return -1
+}
+
+var testRules = map[string][]string{
+	"Pics":  {".jpg"},
+	"Notes": {".md"},
+	"Docs":  {".pdf"},
+}
+
+func TestBuildPlan(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"photo.jpg":       "jpeg",
+		"notes.md":        "# notes",
+		"mystery.xyz":     "?",
+		"deep/report.pdf": "pdf",
+		"Pics/old.jpg":    "old",
+	})
+	before := snapshotTree(t, root)
+
+	plan := buildTestPlan(t, dfs, newTestConfig([]string{"Pics", "Notes", "Docs"}, testRules), newTestParams(root))
+	assert.Equal(t, before, snapshotTree(t, root), "planning must not touch the files")
+
+	ops := operationsBySource(plan)
+	assert.Equal(t, Operation{Type: OpMove, Source: filepath.Join(root, "photo.jpg"), Destination: filepath.Join(root, "Pics", "photo.jpg"), Reason: "category Pics", Size: 4}, ops[filepath.Join(root, "photo.jpg")])
+	assert.Equal(t, filepath.Join(root, "Notes", "notes.md"), ops[filepath.Join(root, "notes.md")].Destination)
+	assert.Equal(t, filepath.Join(root, "Docs", "report.pdf"), ops[filepath.Join(root, "deep", "report.pdf")].Destination)
+
+	assert.Equal(t, OpSkip, ops[filepath.Join(root, "mystery.xyz")].Type)
+	assert.Equal(t, OpSkip, ops[filepath.Join(root, "Pics", "old.jpg")].Type)
+	assert.Equal(t, "already organized", ops[filepath.Join(root, "Pics", "old.jpg")].Reason)
+
+	// Missing destination directories are created once, before the files moved into them
+	counts := plan.Counts()
+	assert.Equal(t, 2, counts[OpCreateDir])
+	assert.Equal(t, -1, operationIndex(plan, OpCreateDir, filepath.Join(root, "Pics")))
+	notesDir := operationIndex(plan, OpCreateDir, filepath.Join(root, "Notes"))
+	assert.GreaterOrEqual(t, notesDir, 0)
+	assert.Less(t, notesDir, operationIndex(plan, OpMove, filepath.Join(root, "notes.md")))
+
+	files, bytes := plan.Transfers()
+	assert.Equal(t, 3, files)
+	assert.Equal(t, int64(len("jpeg")+len("# notes")+len("pdf")), bytes)
+}
+
+func TestBuildPlanClaimsDestinations(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a/photo.jpg": "first",
+		"b/photo.jpg": "second",
+	})
+
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), newTestParams(root))
+
+	// Two files planned to the same destination conflict, the second one is renamed
+	ops := operationsBySource(plan)
+	first, second := ops[filepath.Join(root, "a", "photo.jpg")], ops[filepath.Join(root, "b", "photo.jpg")]
+	assert.Equal(t, OpMove, first.Type)
+	assert.Equal(t, filepath.Join(root, "Pics", "photo.jpg"), first.Destination)
+	assert.Equal(t, OpRename, second.Type)
+	assert.Equal(t, filepath.Join(root, "Pics", "photo_1.jpg"), second.Destination)
+}
+
+func TestDryRunAppliesTheSamePlan(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"photo.jpg":       "jpeg",
+		"notes.md":        "# notes",
+		"deep/report.pdf": "pdf",
+		"Pics/photo.jpg":  "taken",
+	})
+	cfg := newTestConfig(nil, testRules)
+	before := snapshotTree(t, root)
+
+	params := newTestParams(root)
+	params.DryRun = true
+	dryPlan := buildTestPlan(t, dfs, cfg, params)
+	assert.NoError(t, dfs.ApplyPlan(context.Background(), dryPlan, ApplyOptions{DryRun: true}))
+	assert.Equal(t, before, snapshotTree(t, root), "a dry run must not touch the files")
+	assert.NoDirExists(t, createWorkspacePath(root))
+
+	plan := buildTestPlan(t, dfs, cfg, newTestParams(root))
+	assert.Equal(t, dryPlan.Operations, plan.Operations)
+	assert.NoError(t, dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}))
+
+	for _, op := range plan.Operations {
+		if op.Type != OpMove && op.Type != OpRename {
+			continue
+		}
+		rel, _ := filepath.Rel(root, op.Source)
+		assert.NoFileExists(t, op.Source)
+		content, err := os.ReadFile(op.Destination)
+		assert.NoError(t, err)
+		assert.Equal(t, before[rel], string(content))
+	}
+	assert.FileExists(t, filepath.Join(root, "Pics", "photo_1.jpg"))
+
+	// Once applied, there is nothing left to organize
+	again := buildTestPlan(t, dfs, cfg, newTestParams(root))
+	files, _ := again.Transfers()
+	assert.Zero(t, files)
+}
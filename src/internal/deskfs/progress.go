@@ -0,0 +1,83 @@
+package deskfs
+
+import (
+	"io"
+	"time"
+)
+
+type ProgressEventType string
+
+const (
+	EventScanStarted ProgressEventType = "scan_started" // Indexing of the source directory began
+	EventDirIndexed  ProgressEventType = "dir_indexed"  // A directory was read, Files holds its file count
+	EventFilePlanned ProgressEventType = "file_planned" // A move or copy was added to the plan
+	EventPlanReady   ProgressEventType = "plan_ready"   // The plan is complete, Files and Bytes hold its totals
+	EventFileMoved   ProgressEventType = "file_moved"   // A move, rename or copy was applied
+	EventBytesCopied ProgressEventType = "bytes_copied" // A chunk of a copy was written, Bytes holds its size
+	EventError       ProgressEventType = "error"
+	EventFinished    ProgressEventType = "finished" // The plan was applied, or stopped at Error
+)
+
+// ProgressEvent is a single step of an organize run, as reported to a ProgressReporter.
+type ProgressEvent struct {
+	Type        ProgressEventType `json:"event"`
+	Time        time.Time         `json:"time"`
+	Path        string            `json:"path,omitempty"`
+	Destination string            `json:"destination,omitempty"`
+	Files       int               `json:"files,omitempty"`
+	Bytes       int64             `json:"bytes,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// ProgressReporter receives the events of the indexer and the organizer. Workers report from
+// several goroutines at once, so implementations must be safe for concurrent use.
+type ProgressReporter interface {
+	Report(event ProgressEvent)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Report(ProgressEvent) {}
+
+// SetProgress installs the reporter the next runs report to, nil to stop reporting.
+func (dfs *DesktopFS) SetProgress(reporter ProgressReporter) {
+	if reporter == nil {
+		reporter = nopProgress{}
+	}
+	dfs.progress = reporter
+}
+
+func (dfs *DesktopFS) report(event ProgressEvent) {
+	if dfs.progress == nil {
+		return
+	}
+	event.Time = time.Now()
+	dfs.progress.Report(event)
+}
+
+// copyChunk is how many bytes a copy writes between two EventBytesCopied.
+const copyChunk = 1 << 20
+
+// progressWriter reports the bytes written through it, in chunks of copyChunk.
+type progressWriter struct {
+	dfs     *DesktopFS
+	w       io.Writer
+	path    string
+	pending int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.pending += int64(n)
+	if pw.pending >= copyChunk {
+		pw.flush()
+	}
+	return n, err
+}
+
+func (pw *progressWriter) flush() {
+	if pw.pending > 0 {
+		pw.dfs.report(ProgressEvent{Type: EventBytesCopied, Path: pw.path, Bytes: pw.pending})
+		pw.pending = 0
+	}
+}
@@ -0,0 +1,105 @@
+package deskfs
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordedProgress keeps every event it receives, in order.
+type recordedProgress struct {
+	mu     sync.Mutex
+	events []ProgressEvent
+}
+
+func (r *recordedProgress) Report(event ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// ofType returns the recorded events of a type.
+func (r *recordedProgress) ofType(eventType ProgressEventType) []ProgressEvent {
+	var events []ProgressEvent
+	for _, event := range r.events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestOrganizeProgress(t *testing.T) {
+	root := newTestTree(t, map[string]string{
+		"photo.jpg":       "jpeg",
+		"deep/report.pdf": "pdf",
+		"notes.md":        "# notes",
+	})
+	cfg := newTestConfig([]string{"Pics", "Docs"}, map[string][]string{"Pics": {".jpg"}, "Docs": {".pdf"}})
+
+	dfs := newTestDeskFS(t)
+	recorded := &recordedProgress{}
+	dfs.SetProgress(recorded)
+	assert.NoError(t, dfs.EnhancedOrganize(cfg, newTestParams(root)))
+
+	events := recorded.events
+	if !assert.NotEmpty(t, events) {
+		return
+	}
+	assert.Equal(t, ProgressEvent{Type: EventScanStarted, Path: root}, ProgressEvent{Type: events[0].Type, Path: events[0].Path})
+	assert.Equal(t, ProgressEvent{Type: EventFinished}, ProgressEvent{Type: events[len(events)-1].Type, Error: events[len(events)-1].Error})
+	for _, event := range events {
+		assert.False(t, event.Time.IsZero(), event.Type)
+	}
+
+	assert.Len(t, recorded.ofType(EventDirIndexed), 2)
+	assert.Len(t, recorded.ofType(EventFilePlanned), 2, "unmatched files are not planned")
+	if ready := recorded.ofType(EventPlanReady); assert.Len(t, ready, 1) {
+		assert.Equal(t, 2, ready[0].Files)
+		assert.Equal(t, int64(len("jpeg")+len("pdf")), ready[0].Bytes)
+	}
+
+	moved := make(map[string]int64)
+	for _, event := range recorded.ofType(EventFileMoved) {
+		moved[event.Destination] = event.Bytes
+	}
+	assert.Equal(t, map[string]int64{
+		filepath.Join(root, "Pics", "photo.jpg"):  4,
+		filepath.Join(root, "Docs", "report.pdf"): 3,
+	}, moved)
+
+	// Nothing is reported once the reporter is removed
+	dfs.SetProgress(nil)
+	count := len(recorded.events)
+	dfs.WorkspaceManager.centralDB.DirectoryTree = nil
+	assert.NoError(t, dfs.EnhancedOrganize(cfg, newTestParams(root)))
+	assert.Len(t, recorded.events, count)
+}
+
+func TestProgressWriter(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	recorded := &recordedProgress{}
+	dfs.SetProgress(recorded)
+
+	var out bytes.Buffer
+	writer := &progressWriter{dfs: dfs, w: &out, path: "/src/big.iso"}
+	chunk := bytes.Repeat([]byte{1}, copyChunk/2)
+	for i := 0; i < 5; i++ {
+		n, err := writer.Write(chunk)
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	writer.flush()
+	writer.flush() // Nothing left, nothing reported
+
+	var sizes []int64
+	for _, event := range recorded.ofType(EventBytesCopied) {
+		assert.Equal(t, "/src/big.iso", event.Path)
+		sizes = append(sizes, event.Bytes)
+	}
+	assert.Equal(t, []int64{copyChunk, copyChunk, copyChunk / 2}, sizes)
+	assert.Equal(t, 5*len(chunk), out.Len())
+}
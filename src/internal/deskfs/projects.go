@@ -0,0 +1,90 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+type ProjectMode string
+
+const (
+	// ProjectSkip leaves detected projects where they are
+	ProjectSkip ProjectMode = "skip"
+	// ProjectMove moves detected projects, as a whole, into the projects category
+	ProjectMove ProjectMode = "move"
+)
+
+// ProjectsConfig controls how project folders are handled during a recursive organize.
+// A directory containing any of the markers is a project and is never split apart.
+type ProjectsConfig struct {
+	Markers  []string    `toml:"markers"`  // File or directory names (globs allowed) marking a project root
+	Mode     ProjectMode `toml:"mode"`     // "skip" or "move"
+	Category string      `toml:"category"` // Destination folder for ProjectMove, relative to the target directory
+}
+
+func defaultProjectsConfig() ProjectsConfig {
+	return ProjectsConfig{
+		Markers: []string{
+			".git", ".hg", ".svn", "go.mod", "package.json", "Cargo.toml", "pyproject.toml",
+			"setup.py", "pom.xml", "build.gradle", "CMakeLists.txt", "*.sln", "*.csproj",
+		},
+		Mode:     ProjectSkip,
+		Category: "Projects",
+	}
+}
+
+// withDefaults fills unset fields, so configs written before projects existed keep working.
+func (pc ProjectsConfig) withDefaults() ProjectsConfig {
+	defaults := defaultProjectsConfig()
+	if pc.Markers == nil {
+		pc.Markers = defaults.Markers
+	}
+	if pc.Mode == "" {
+		pc.Mode = defaults.Mode
+	}
+	if pc.Category == "" {
+		pc.Category = defaults.Category
+	}
+	return pc
+}
+
+// projectMarker returns the first entry that marks the directory as a project root.
+func (pc ProjectsConfig) projectMarker(entries []os.DirEntry) (string, bool) {
+	for _, entry := range entries {
+		for _, marker := range pc.Markers {
+			if matched, _ := filepath.Match(marker, entry.Name()); matched {
+				return entry.Name(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// insideProject reports whether the node or one of its ancestors is a project root.
+func insideProject(node *trees.DirectoryNode) bool {
+	for current := node; current != nil; current = current.Parent {
+		if current.IsProject {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceProject handles a source directory that is itself a project. Organizing it would split the
+// project apart, so it is left alone in skip mode and refused in move mode. It reports whether
+// organizing should stop.
+func sourceProject(root *trees.DirectoryNode, cfg *DeskFSConfig) (bool, error) {
+	if !root.IsProject {
+		return false, nil
+	}
+
+	if cfg.Projects.Mode == ProjectMove {
+		return true, fmt.Errorf("source directory %s is itself a project, organize its parent to move it as a unit", root.Path)
+	}
+	slog.Info(fmt.Sprintf("Source directory %s is itself a project, leaving it in place\n", root.Path))
+	return true, nil
+}
+
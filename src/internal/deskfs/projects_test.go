@@ -0,0 +1,143 @@
+package deskfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newProjectsConfig returns a config sorting Go sources and text files, with the given project mode.
+func newProjectsConfig(mode ProjectMode) *DeskFSConfig {
+	cfg := NewDeskFSConfig()
+	cfg.FileTypeTree.PopulateFileTypes(map[string][]string{
+		"Code": {".go"},
+		"Docs": {".txt"},
+	})
+	cfg.Projects = defaultProjectsConfig()
+	cfg.Projects.Mode = mode
+	return cfg
+}
+
+func TestProjectMarker(t *testing.T) {
+	dir, cleanup := setupTestDir(t, map[string]string{
+		"tool/go.mod":          "module tool",
+		"tool/main.go":         "package main",
+		"app/App.sln":          "",
+		"notes/todo.txt":       "",
+		"notes/go.mod.txt":     "",
+		"nested/tool/main.go":  "package main",
+		"nested/tool/go.mod":   "module tool",
+		"nested/readme.txt":    "",
+		"nested/deeper/go.mod": "module deeper",
+	})
+	defer cleanup()
+
+	pc := defaultProjectsConfig()
+	markers := map[string]string{
+		"tool":  "go.mod",
+		"app":   "App.sln", // globs are allowed
+		"notes": "",
+	}
+	for name, expected := range markers {
+		entries, err := os.ReadDir(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		marker, found := pc.projectMarker(entries)
+		assert.Equal(t, expected != "", found, name)
+		assert.Equal(t, expected, marker, name)
+	}
+
+	// Projects are detected one level past the depth limit and indexed whole
+	params := NewFilePathParams()
+	params.SourceDir = filepath.Join(dir, "nested")
+	params.MaxDepth = 0
+	dfs := newTestDeskFS(t)
+	assert.NoError(t, dfs.buildTreeAndCache(newProjectsConfig(ProjectSkip), params))
+
+	root := dfs.WorkspaceManager.centralDB.DirectoryTree.Root
+	assert.False(t, root.IsProject)
+	projects := make(map[string]bool)
+	for _, child := range root.Children {
+		projects[filepath.Base(child.Path)] = child.IsProject
+	}
+	assert.Equal(t, map[string]bool{"tool": true, "deeper": true}, projects)
+	assert.True(t, indexedFiles(root)[filepath.Join(dir, "nested", "tool", "main.go")])
+}
+
+func TestOrganizeProjects(t *testing.T) {
+	for _, mode := range []ProjectMode{ProjectSkip, ProjectMove} {
+		t.Run(string(mode), func(t *testing.T) {
+			dir, cleanup := setupTestDir(t, map[string]string{
+				"source/notes.txt":        "",
+				"source/tool/go.mod":      "module tool",
+				"source/tool/main.go":     "package main",
+				"source/tool/docs/a.txt":  "",
+				"source/misc/scratch.go":  "package scratch",
+				"target/placeholder.keep": "",
+			})
+			defer cleanup()
+
+			params := &FilePathParams{
+				SourceDir:          filepath.Join(dir, "source"),
+				TargetDir:          filepath.Join(dir, "target"),
+				Recursive:          true,
+				MaxDepth:           -1,
+				ConflictResolution: RenameSuffix,
+			}
+			dfs := newTestDeskFS(t)
+			assert.NoError(t, dfs.EnhancedOrganize(newProjectsConfig(mode), params))
+
+			// Loose files are organized either way, the project is never split apart
+			assert.FileExists(t, filepath.Join(dir, "target", "Docs", "notes.txt"))
+			assert.FileExists(t, filepath.Join(dir, "target", "Code", "scratch.go"))
+			assert.NoFileExists(t, filepath.Join(dir, "target", "Code", "main.go"))
+			assert.NoFileExists(t, filepath.Join(dir, "target", "Docs", "a.txt"))
+
+			projectDir := filepath.Join(dir, "source", "tool")
+			if mode == ProjectMove {
+				projectDir = filepath.Join(dir, "target", "Projects", "tool")
+				assert.NoDirExists(t, filepath.Join(dir, "source", "tool"))
+			}
+			assert.FileExists(t, filepath.Join(projectDir, "go.mod"))
+			assert.FileExists(t, filepath.Join(projectDir, "main.go"))
+			assert.FileExists(t, filepath.Join(projectDir, "docs", "a.txt"))
+		})
+	}
+}
+
+func TestOrganizeSourceProject(t *testing.T) {
+	for _, mode := range []ProjectMode{ProjectSkip, ProjectMove} {
+		t.Run(string(mode), func(t *testing.T) {
+			dir, cleanup := setupTestDir(t, map[string]string{
+				"source/go.mod":           "module source",
+				"source/main.go":          "package main",
+				"source/notes.txt":        "",
+				"target/placeholder.keep": "",
+			})
+			defer cleanup()
+
+			params := &FilePathParams{
+				SourceDir:          filepath.Join(dir, "source"),
+				TargetDir:          filepath.Join(dir, "target"),
+				Recursive:          true,
+				MaxDepth:           -1,
+				ConflictResolution: RenameSuffix,
+			}
+			dfs := newTestDeskFS(t)
+			err := dfs.EnhancedOrganize(newProjectsConfig(mode), params)
+			if mode == ProjectMove {
+				assert.ErrorContains(t, err, "is itself a project")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// The source is left alone in both modes
+			assert.FileExists(t, filepath.Join(dir, "source", "main.go"))
+			assert.FileExists(t, filepath.Join(dir, "source", "notes.txt"))
+			assert.NoDirExists(t, filepath.Join(dir, "target", "Docs"))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package deskfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// DefaultJobs is the number of transfers applied at once when --jobs is not set.
+var DefaultJobs = runtime.NumCPU()
+
+// maxCachedDevices bounds the device cache of the scheduler. Plans are ordered by directory, so
+// forgetting older directories only costs a few extra stats.
+const maxCachedDevices = 4096
+
+// deviceLane groups the transfers between a source device and a target device. Renames within a
+// device and copies across devices run on separate lanes, so slow copies never starve fast renames.
+type deviceLane struct {
+	source uint64
+	target uint64
+}
+
+type transferResult struct {
+	index int
+	err   error
+}
+
+// scheduler runs the transfers of a plan on a pool of jobs workers in total, split across the device
+// lanes of the plan. Each lane gets its own share of the workers; when there are more lanes than
+// workers, lanes share a group round-robin. Work is fed through bounded channels straight from the
+// plan, so besides the plan itself the number of goroutines and queued operations stays the same
+// whatever the size of the tree.
+type scheduler struct {
+	dfs     *DesktopFS
+	jobs    int
+	devices map[string]uint64  // Device per directory, the feeder only stats each directory once
+	lanes   map[deviceLane]int // Lanes in order of first appearance
+	apply   func(op Operation) error
+}
+
+func newScheduler(dfs *DesktopFS, jobs int) *scheduler {
+	if jobs < 1 {
+		jobs = DefaultJobs
+	}
+	return &scheduler{
+		dfs:     dfs,
+		jobs:    jobs,
+		devices: make(map[string]uint64),
+		lanes:   make(map[deviceLane]int),
+		apply:   func(op Operation) error { return dfs.applyOperation(op, false) },
+	}
+}
+
+// run applies every transfer of ops, calling done from the calling goroutine after each success.
+// The first failure cancels the operations not started yet; the ones in flight finish and are
+// still reported to done. It returns the first error.
+func (s *scheduler) run(ctx context.Context, ops []Operation, done func(index int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The lanes are known up front so the workers can be split between them
+	for _, op := range ops {
+		if isTransfer(op) {
+			s.lane(s.laneFor(op))
+		}
+	}
+	if len(s.lanes) == 0 {
+		return nil
+	}
+
+	groups := make([]chan int, min(len(s.lanes), s.jobs))
+	results := make(chan transferResult, s.jobs)
+	var workers sync.WaitGroup
+	for g := range groups {
+		groups[g] = make(chan int, s.jobs)
+		n := s.workers(g, len(groups))
+		workers.Add(n)
+		for i := 0; i < n; i++ {
+			go s.work(ctx, ops, groups[g], results, &workers)
+		}
+	}
+
+	go func() {
+		defer func() {
+			for _, group := range groups {
+				close(group)
+			}
+			workers.Wait()
+			close(results)
+		}()
+
+		for i, op := range ops {
+			if !isTransfer(op) {
+				continue
+			}
+
+			select {
+			case groups[s.lane(s.laneFor(op))%len(groups)] <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var firstErr error
+	for result := range results {
+		if result.err == nil {
+			result.err = done(result.index)
+		}
+		if result.err != nil && firstErr == nil {
+			firstErr = result.err
+			cancel()
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// workers returns the share of the jobs given to a group, the first groups take the remainder.
+func (s *scheduler) workers(group, groups int) int {
+	n := s.jobs / groups
+	if group < s.jobs%groups {
+		n++
+	}
+	return n
+}
+
+func (s *scheduler) work(ctx context.Context, ops []Operation, group <-chan int, results chan<- transferResult, workers *sync.WaitGroup) {
+	defer workers.Done()
+
+	for index := range group {
+		if err := ctx.Err(); err != nil {
+			continue // Drain the group without starting anything new
+		}
+
+		op := ops[index]
+		if err := s.apply(op); err != nil {
+			s.dfs.report(ProgressEvent{Type: EventError, Path: op.Source, Error: err.Error()})
+			results <- transferResult{index: index, err: fmt.Errorf("%s %s failed: %w", op.Type, op.Source, err)}
+			continue
+		}
+		s.dfs.report(ProgressEvent{Type: EventFileMoved, Path: op.Source, Destination: op.Destination, Bytes: op.Size})
+		results <- transferResult{index: index}
+	}
+}
+
+// lane returns the order of a lane, registering it on first sight.
+func (s *scheduler) lane(key deviceLane) int {
+	order, ok := s.lanes[key]
+	if !ok {
+		order = len(s.lanes)
+		s.lanes[key] = order
+	}
+	return order
+}
+
+// laneFor returns the device lane of a transfer, from the source directory and the nearest existing
+// ancestor of the destination, which may only be created by the plan.
+func (s *scheduler) laneFor(op Operation) deviceLane {
+	return deviceLane{
+		source: s.device(filepath.Dir(op.Source)),
+		target: s.device(filepath.Dir(op.Destination)),
+	}
+}
+
+func (s *scheduler) device(dir string) uint64 {
+	if dev, ok := s.devices[dir]; ok {
+		return dev
+	}
+
+	dev, ok := deviceID(dir)
+	if !ok {
+		if parent := filepath.Dir(dir); parent != dir {
+			dev = s.device(parent)
+		}
+	}
+	if len(s.devices) >= maxCachedDevices {
+		s.devices = make(map[string]uint64)
+	}
+	s.devices[dir] = dev
+	return dev
+}
@@ -0,0 +1,131 @@
+package deskfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestScheduler returns a scheduler whose directories are spread over fake devices, so lanes can
+// be tested on a single disk. Nothing is applied unless the test replaces apply.
+func newTestScheduler(jobs int, devices map[string]uint64) *scheduler {
+	s := newScheduler(&DesktopFS{}, jobs)
+	for dir, dev := range devices {
+		s.devices[filepath.FromSlash(dir)] = dev
+	}
+	s.apply = func(op Operation) error { return nil }
+	return s
+}
+
+func transfer(source, destination string) Operation {
+	return Operation{Type: OpMove, Source: filepath.FromSlash(source), Destination: filepath.FromSlash(destination)}
+}
+
+func TestSchedulerLanes(t *testing.T) {
+	s := newTestScheduler(4, map[string]uint64{"/a": 1, "/b": 2, "/t": 1, "/t/Docs": 1})
+
+	rename := s.laneFor(transfer("/a/x.txt", "/t/x.txt"))
+	copyAcross := s.laneFor(transfer("/b/y.txt", "/t/y.txt"))
+	assert.Equal(t, deviceLane{source: 1, target: 1}, rename)
+	assert.Equal(t, deviceLane{source: 2, target: 1}, copyAcross)
+	assert.Equal(t, rename, s.laneFor(transfer("/a/z.txt", "/t/Docs/z.txt")))
+
+	// The destination may only be created by the plan, its nearest existing ancestor counts
+	assert.Equal(t, rename, s.laneFor(transfer("/a/w.txt", "/t/Docs/Reports/w.txt")))
+
+	assert.Equal(t, 0, s.lane(rename))
+	assert.Equal(t, 1, s.lane(copyAcross))
+	assert.Equal(t, 0, s.lane(rename))
+
+	// The jobs are split between the groups, the first ones take the remainder
+	for _, tc := range []struct {
+		jobs, groups int
+		expected     []int
+	}{
+		{4, 2, []int{2, 2}},
+		{5, 2, []int{3, 2}},
+		{3, 3, []int{1, 1, 1}},
+		{1, 1, []int{1}},
+	} {
+		s := newScheduler(&DesktopFS{}, tc.jobs)
+		var workers []int
+		for g := 0; g < tc.groups; g++ {
+			workers = append(workers, s.workers(g, tc.groups))
+		}
+		assert.Equal(t, tc.expected, workers, "%d jobs on %d groups", tc.jobs, tc.groups)
+	}
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	// Four lanes on three jobs, the first and the last lane share a worker
+	s := newTestScheduler(3, map[string]uint64{"/a": 1, "/b": 2, "/c": 3, "/d": 4, "/t": 1})
+	var ops []Operation
+	for i := 0; i < 10; i++ {
+		for _, dir := range []string{"a", "b", "c", "d"} {
+			ops = append(ops, transfer(fmt.Sprintf("/%s/%d.txt", dir, i), fmt.Sprintf("/t/%s%d.txt", dir, i)))
+		}
+	}
+	ops = append(ops, Operation{Type: OpCreateDir, Destination: filepath.FromSlash("/t/Docs")})
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	groupInFlight, maxGroupInFlight := make(map[int]int), make(map[int]int)
+	groups := map[string]int{"a": 0, "b": 1, "c": 2, "d": 0}
+	s.apply = func(op Operation) error {
+		group := groups[filepath.Base(filepath.Dir(op.Source))]
+		mu.Lock()
+		inFlight++
+		groupInFlight[group]++
+		maxInFlight = max(maxInFlight, inFlight)
+		maxGroupInFlight[group] = max(maxGroupInFlight[group], groupInFlight[group])
+		mu.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		groupInFlight[group]--
+		mu.Unlock()
+		return nil
+	}
+
+	done := make(map[int]int)
+	assert.NoError(t, s.run(context.Background(), ops, func(index int) error {
+		done[index]++
+		return nil
+	}))
+
+	assert.Len(t, done, 40, "every transfer is applied, directories are not")
+	for index, count := range done {
+		assert.Equal(t, 1, count, ops[index].Source)
+	}
+	assert.LessOrEqual(t, maxInFlight, 3, "--jobs bounds the transfers across all lanes")
+	for group, n := range maxGroupInFlight {
+		assert.LessOrEqual(t, n, 1, "group %d has a single worker", group)
+	}
+}
+
+func TestSchedulerFailure(t *testing.T) {
+	s := newTestScheduler(1, map[string]uint64{"/a": 1, "/t": 1})
+	ops := []Operation{transfer("/a/1.txt", "/t/1.txt"), transfer("/a/2.txt", "/t/2.txt"), transfer("/a/3.txt", "/t/3.txt")}
+	s.apply = func(op Operation) error {
+		if op.Source == ops[1].Source {
+			return fmt.Errorf("disk full")
+		}
+		return nil
+	}
+
+	var done []int
+	err := s.run(context.Background(), ops, func(index int) error {
+		done = append(done, index)
+		return nil
+	})
+	assert.ErrorContains(t, err, "disk full")
+	assert.Contains(t, done, 0)
+	assert.NotContains(t, done, 1)
+}
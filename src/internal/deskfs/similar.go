@@ -0,0 +1,158 @@
+package deskfs
+
+import (
+	"cmp"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// DefaultSimilarityThreshold is the Hamming distance, out of 64 bits, up to which two images are
+// similar. Resized and re-encoded copies are usually within a few bits, unrelated pictures around 32.
+const DefaultSimilarityThreshold = 10
+
+// SimilarImage is an image of a SimilarImageGroup.
+type SimilarImage struct {
+	Path       string    `json:"path"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Hash       string    `json:"hash"`
+	Distance   int       `json:"distance"` // Hamming distance to the first image of the group
+}
+
+// SimilarImageGroup is a set of images that look alike. The image with the most pixels, likely the
+// original, comes first, the others follow by distance to it.
+type SimilarImageGroup struct {
+	Images []SimilarImage `json:"images"`
+}
+
+// SimilarImagesReport lists the groups of similar images below a directory, the largest groups first.
+type SimilarImagesReport struct {
+	SourceDir string                    `json:"source_dir"`
+	Algorithm trees.PerceptualAlgorithm `json:"algorithm"`
+	Threshold int                       `json:"threshold"`
+	Scanned   int                       `json:"scanned"` // Images hashed
+	Groups    []SimilarImageGroup       `json:"groups"`
+}
+
+// similarCandidate is an image file and its hashes, once computed.
+type similarCandidate struct {
+	file   *trees.FileNode
+	hash   trees.ImageHash
+	hashed bool
+}
+
+// FindSimilarImages indexes params.SourceDir and groups its JPEG, PNG and GIF images by perceptual
+// hash, through hasher and its cache. Two images are similar when the hashes of algorithm differ in at
+// most threshold bits, and groups are transitive: a chain of similar images makes a single group.
+// Images that cannot be decoded, and the content of projects, are left out.
+func (dfs *DesktopFS) FindSimilarImages(cfg *DeskFSConfig, params *FilePathParams, hasher *trees.ImageHasher, algorithm trees.PerceptualAlgorithm, threshold int) (*SimilarImagesReport, error) {
+	if threshold < 0 || threshold > 64 {
+		return nil, fmt.Errorf("threshold %d is out of range, expected 0 to 64", threshold)
+	}
+	if err := dfs.IndexDirectory(cfg, params); err != nil {
+		return nil, err
+	}
+
+	report := &SimilarImagesReport{SourceDir: params.SourceDir, Algorithm: algorithm, Threshold: threshold, Groups: []SimilarImageGroup{}}
+	var files []*trees.FileNode
+	collectDuplicateCandidates(dfs.WorkspaceManager.centralDB.DirectoryTree.Root, &files)
+
+	var candidates []*similarCandidate
+	for _, file := range files {
+		if trees.IsHashableImage(file.Path) {
+			candidates = append(candidates, &similarCandidate{file: file})
+		}
+	}
+	runParallel(candidates, params.Jobs, func(c *similarCandidate) {
+		hash, err := hasher.Hash(c.file)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Leaving out %s: %v\n", c.file.Path, err))
+			return
+		}
+		c.hash, c.hashed = hash, true
+	})
+	candidates = slices.DeleteFunc(candidates, func(c *similarCandidate) bool { return !c.hashed })
+	report.Scanned = len(candidates)
+
+	var index trees.BKTree[int]
+	for i, c := range candidates {
+		index.Add(c.hash.Of(algorithm), i)
+	}
+
+	// Union-find over the pairs within the threshold
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, c := range candidates {
+		for _, match := range index.Search(c.hash.Of(algorithm), threshold) {
+			parent[find(match.Value)] = find(i)
+		}
+	}
+
+	var order []int
+	members := make(map[int][]*similarCandidate)
+	for i, c := range candidates {
+		root := find(i)
+		if _, seen := members[root]; !seen {
+			order = append(order, root)
+		}
+		members[root] = append(members[root], c)
+	}
+	for _, root := range order {
+		if len(members[root]) > 1 {
+			report.Groups = append(report.Groups, newSimilarImageGroup(members[root], algorithm))
+		}
+	}
+	slices.SortFunc(report.Groups, func(a, b SimilarImageGroup) int {
+		return cmp.Or(cmp.Compare(len(b.Images), len(a.Images)), cmp.Compare(a.Images[0].Path, b.Images[0].Path))
+	})
+	return report, nil
+}
+
+func newSimilarImageGroup(candidates []*similarCandidate, algorithm trees.PerceptualAlgorithm) SimilarImageGroup {
+	// The most pixels first, then the largest file, then the shortest path
+	reference := slices.MinFunc(candidates, func(a, b *similarCandidate) int {
+		return cmp.Or(
+			cmp.Compare(b.hash.Width*b.hash.Height, a.hash.Width*a.hash.Height),
+			cmp.Compare(b.file.Metadata.Size, a.file.Metadata.Size),
+			cmp.Compare(len(a.file.Path), len(b.file.Path)),
+			cmp.Compare(a.file.Path, b.file.Path),
+		)
+	})
+
+	var group SimilarImageGroup
+	for _, c := range candidates {
+		group.Images = append(group.Images, SimilarImage{
+			Path:       c.file.Path,
+			Width:      c.hash.Width,
+			Height:     c.hash.Height,
+			Size:       c.file.Metadata.Size,
+			ModifiedAt: c.file.Metadata.ModifiedAt,
+			Hash:       c.hash.Of(algorithm).String(),
+			Distance:   c.hash.Of(algorithm).Distance(reference.hash.Of(algorithm)),
+		})
+	}
+	slices.SortFunc(group.Images, func(a, b SimilarImage) int {
+		switch reference.file.Path {
+		case a.Path:
+			return -1
+		case b.Path:
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.Path, b.Path))
+	})
+	return group
+}
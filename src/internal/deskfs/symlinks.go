@@ -0,0 +1,143 @@
+package deskfs
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy selects how the indexer treats symbolic links.
+type SymlinkPolicy string
+
+const (
+	SymlinksSkip     SymlinkPolicy = "skip"     // Leave links where they are
+	SymlinksPreserve SymlinkPolicy = "preserve" // Organize links as links, relative targets are rewritten when moved
+	SymlinksFollow   SymlinkPolicy = "follow"   // Index the targets, descending into linked directories once
+)
+
+// ParseSymlinkPolicy validates a --symlinks value.
+func ParseSymlinkPolicy(value string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(value); policy {
+	case SymlinksSkip, SymlinksPreserve, SymlinksFollow:
+		return policy, nil
+	case "":
+		return SymlinksPreserve, nil
+	}
+	return "", fmt.Errorf("unknown symlink policy %q, expected skip, preserve or follow", value)
+}
+
+// firstVisit records a directory reached by the walk and reports whether it was new. Directories are
+// identified by device and inode, so a link back to an ancestor, or two links to the same directory,
+// are only walked once.
+func firstVisit(visited map[string]bool, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return true // Unreadable, ReadDir reports it
+	}
+
+	key, ok := fileKey(info)
+	if !ok {
+		if key, err = filepath.EvalSymlinks(path); err != nil {
+			return true
+		}
+	}
+
+	if visited[key] {
+		return false
+	}
+	visited[key] = true
+	return true
+}
+
+// relinkTarget returns the target a link moved from oldPath to newPath needs to keep pointing at the
+// same file. Absolute targets are kept as they are.
+func relinkTarget(target, oldPath, newPath string) string {
+	if filepath.IsAbs(target) {
+		return target
+	}
+
+	resolved := filepath.Join(filepath.Dir(oldPath), target)
+	rel, err := filepath.Rel(filepath.Dir(newPath), resolved)
+	if err != nil {
+		return resolved
+	}
+	return rel
+}
+
+// placeSymlink creates a link at dst pointing at target, replacing dst atomically when it exists.
+func placeSymlink(target, dst string) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), fmt.Sprintf(copyTempPattern, filepath.Base(dst)))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary link for %s: %w", dst, err)
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	os.Remove(tmpPath)
+
+	if err := os.Symlink(target, tmpPath); err != nil {
+		return fmt.Errorf("failed to create link %s: %w", dst, err)
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move link into place at %s: %w", dst, err)
+	}
+	return nil
+}
+
+// transferSymlink recreates the link src at dst, rewriting a relative target, and removes src when asked.
+func transferSymlink(src, dst string, remove bool) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return fmt.Errorf("failed to read link %s: %w", src, err)
+	}
+
+	newTarget := relinkTarget(target, src, dst)
+	if newTarget != target {
+		slog.Info(fmt.Sprintf("Rewriting link %s from %s to %s\n", dst, target, newTarget))
+	}
+	if err := placeSymlink(newTarget, dst); err != nil {
+		return err
+	}
+
+	if remove {
+		return os.Remove(src)
+	}
+	return nil
+}
+
+// relinkTree fixes the relative links of a directory moved or copied from oldRoot to newRoot whose
+// targets lie outside of it. Links within the tree still resolve and are left alone.
+func relinkTree(oldRoot, newRoot string) error {
+	return filepath.WalkDir(newRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil || filepath.IsAbs(target) {
+			return err
+		}
+
+		rel, err := filepath.Rel(newRoot, path)
+		if err != nil {
+			return err
+		}
+		oldPath := filepath.Join(oldRoot, rel)
+		if within(oldRoot, filepath.Join(filepath.Dir(oldPath), target)) {
+			return nil
+		}
+
+		newTarget := relinkTarget(target, oldPath, path)
+		slog.Info(fmt.Sprintf("Rewriting link %s from %s to %s\n", path, target, newTarget))
+		return placeSymlink(newTarget, path)
+	})
+}
+
+// within reports whether path is root or below it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
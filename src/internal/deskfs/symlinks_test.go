@@ -0,0 +1,223 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/filesystem/trees"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// symlink creates a link at path, skipping the test where links cannot be created.
+func symlink(t *testing.T, target, path string) {
+	if err := os.Symlink(target, path); err != nil {
+		t.Skipf("cannot create symlinks here: %v", err)
+	}
+}
+
+// indexedNodes returns the file nodes of a directory tree by path.
+func indexedNodes(node *trees.DirectoryNode) map[string]*trees.FileNode {
+	files := make(map[string]*trees.FileNode)
+	for _, file := range node.Files {
+		files[file.Path] = file
+	}
+	for _, child := range node.Children {
+		for path, file := range indexedNodes(child) {
+			files[path] = file
+		}
+	}
+	return files
+}
+
+// indexWithSymlinks indexes source with a symlink policy and returns its files by path.
+func indexWithSymlinks(t *testing.T, source string, policy SymlinkPolicy) map[string]*trees.FileNode {
+	params := newTestParams(source)
+	params.Symlinks = policy
+	dfs := newTestDeskFS(t)
+	if err := dfs.buildTreeAndCache(newTestConfig(nil, testRules), params); err != nil {
+		t.Fatalf("failed to index %s: %v", source, err)
+	}
+	return indexedNodes(dfs.WorkspaceManager.centralDB.DirectoryTree.Root)
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, value := range []string{"skip", "preserve", "follow"} {
+		policy, err := ParseSymlinkPolicy(value)
+		assert.NoError(t, err)
+		assert.Equal(t, SymlinkPolicy(value), policy)
+	}
+
+	policy, err := ParseSymlinkPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, SymlinksPreserve, policy)
+
+	_, err = ParseSymlinkPolicy("resolve")
+	assert.ErrorContains(t, err, `unknown symlink policy "resolve"`)
+}
+
+func TestIndexSymlinkPolicies(t *testing.T) {
+	root := newTestTree(t, map[string]string{
+		"source/real.md":      "# real",
+		"source/dir/inner.md": "# inner",
+		"outside/shared.pdf":  "shared pdf",
+	})
+	source := filepath.Join(root, "source")
+	symlink(t, "real.md", filepath.Join(source, "link.md"))
+	symlink(t, "dir", filepath.Join(source, "dirlink"))
+	symlink(t, filepath.Join("..", "outside", "shared.pdf"), filepath.Join(source, "shared.pdf"))
+	symlink(t, "missing.md", filepath.Join(source, "broken.md"))
+
+	path := func(rel string) string { return filepath.Join(source, filepath.FromSlash(rel)) }
+
+	t.Run("skip", func(t *testing.T) {
+		files := indexWithSymlinks(t, source, SymlinksSkip)
+		assert.Len(t, files, 2)
+		assert.Contains(t, files, path("real.md"))
+		assert.Contains(t, files, path("dir/inner.md"))
+	})
+
+	t.Run("preserve", func(t *testing.T) {
+		// Links are files of their own, linked directories are not descended into
+		files := indexWithSymlinks(t, source, SymlinksPreserve)
+		assert.Len(t, files, 6)
+		for _, link := range []string{"link.md", "dirlink", "shared.pdf", "broken.md"} {
+			assert.Contains(t, files, path(link))
+		}
+		assert.NotContains(t, files, path("dirlink/inner.md"))
+		if link, ok := files[path("shared.pdf")]; assert.True(t, ok) {
+			assert.NotEqual(t, int64(len("shared pdf")), link.Metadata.Size, "the link is indexed, not its target")
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		// Targets are indexed in place of the links, each directory only once
+		files := indexWithSymlinks(t, source, SymlinksFollow)
+		assert.Contains(t, files, path("dir/inner.md"))
+		assert.NotContains(t, files, path("dirlink/inner.md"), "dir was already indexed")
+		assert.NotContains(t, files, path("dirlink"))
+		if link, ok := files[path("shared.pdf")]; assert.True(t, ok) {
+			assert.Equal(t, int64(len("shared pdf")), link.Metadata.Size)
+		}
+		assert.Contains(t, files, path("broken.md"), "a dangling link is kept as a link")
+		assert.Len(t, files, 5)
+	})
+}
+
+func TestFollowSymlinkLoop(t *testing.T) {
+	root := newTestTree(t, map[string]string{
+		"source/a/file.md": "# file",
+		"source/b/file.md": "# file",
+	})
+	source := filepath.Join(root, "source")
+	symlink(t, "..", filepath.Join(source, "a", "up"))                           // Back to the source
+	symlink(t, filepath.Join("..", "a"), filepath.Join(source, "b", "sideways")) // Into a sibling
+	symlink(t, "self", filepath.Join(source, "self"))                            // Never resolves
+
+	// The walk ends, every directory indexed once through the first path reaching it
+	files := indexWithSymlinks(t, source, SymlinksFollow)
+	paths := make(map[string]bool)
+	for path := range files {
+		paths[path] = true
+	}
+	assert.Equal(t, map[string]bool{
+		filepath.Join(source, "a", "file.md"): true,
+		filepath.Join(source, "b", "file.md"): true,
+		filepath.Join(source, "self"):         true,
+	}, paths)
+}
+
+func TestFirstVisit(t *testing.T) {
+	root := newTestTree(t, map[string]string{"dir/file.md": ""})
+	symlink(t, "dir", filepath.Join(root, "alias"))
+
+	visited := make(map[string]bool)
+	assert.True(t, firstVisit(visited, filepath.Join(root, "dir")))
+	assert.False(t, firstVisit(visited, filepath.Join(root, "alias")), "the same directory through a link")
+	assert.False(t, firstVisit(visited, filepath.Join(root, "dir")))
+	assert.True(t, firstVisit(visited, root))
+}
+
+func TestRelinkTarget(t *testing.T) {
+	for _, tc := range []struct {
+		target, oldPath, newPath, expected string
+	}{
+		{"notes.md", "/src/link.md", "/src/Docs/link.md", "../notes.md"},
+		{"../shared/a.pdf", "/src/docs/link.pdf", "/dst/Docs/Reports/link.pdf", "../../../src/shared/a.pdf"},
+		{"a.pdf", "/src/link.pdf", "/src/renamed.pdf", "a.pdf"},
+		{"/etc/hosts", "/src/hosts", "/dst/Config/hosts", "/etc/hosts"},
+	} {
+		expected := filepath.FromSlash(tc.expected)
+		if filepath.IsAbs(tc.target) {
+			expected = tc.target
+		}
+		assert.Equal(t, expected, relinkTarget(filepath.FromSlash(tc.target), filepath.FromSlash(tc.oldPath), filepath.FromSlash(tc.newPath)), tc.target)
+	}
+}
+
+func TestMoveSymlinkRewritesTarget(t *testing.T) {
+	root := newTestTree(t, map[string]string{
+		"source/placeholder.keep":  "",
+		"outside/shared.pdf":       "shared pdf",
+		"source/drafts/report.txt": "report",
+	})
+	source := filepath.Join(root, "source")
+	symlink(t, filepath.Join("..", "outside", "shared.pdf"), filepath.Join(source, "shared.pdf"))
+	symlink(t, filepath.Join("drafts", "report.txt"), filepath.Join(source, "latest.pdf"))
+
+	// Organized as a link, the moved link still points at the same file
+	dfs := newTestDeskFS(t)
+	assert.NoError(t, dfs.EnhancedOrganize(newTestConfig(nil, testRules), newTestParams(source)))
+
+	for name, content := range map[string]string{"shared.pdf": "shared pdf", "latest.pdf": "report"} {
+		moved := filepath.Join(source, "Docs", name)
+		info, err := os.Lstat(moved)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.NotZero(t, info.Mode()&os.ModeSymlink, "%s is still a link", name)
+		target, err := os.Readlink(moved)
+		assert.NoError(t, err)
+		assert.False(t, filepath.IsAbs(target), "%s keeps a relative target", name)
+
+		read, err := os.ReadFile(moved)
+		assert.NoError(t, err, name)
+		assert.Equal(t, content, string(read), name)
+		assert.NoFileExists(t, filepath.Join(source, name))
+	}
+	assert.Equal(t, filepath.Join("..", "..", "outside", "shared.pdf"), readlink(t, filepath.Join(source, "Docs", "shared.pdf")))
+}
+
+func TestRelinkTree(t *testing.T) {
+	root := newTestTree(t, map[string]string{
+		"source/tool/go.mod":   "module tool",
+		"source/tool/main.go":  "package main",
+		"source/shared/lib.go": "package lib",
+	})
+	project := filepath.Join(root, "source", "tool")
+	symlink(t, "main.go", filepath.Join(project, "entry.go"))
+	symlink(t, filepath.Join("..", "shared", "lib.go"), filepath.Join(project, "lib.go"))
+
+	dst := filepath.Join(root, "target", "Projects", "tool")
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dfs := newTestDeskFS(t)
+	assert.NoError(t, dfs.Move(&trees.DirectoryNode{Path: project}, dst, true, false))
+
+	// Links within the project still resolve and are left alone, the ones leaving it are rewritten
+	assert.Equal(t, "main.go", readlink(t, filepath.Join(dst, "entry.go")))
+	assert.Equal(t, filepath.Join("..", "..", "..", "source", "shared", "lib.go"), readlink(t, filepath.Join(dst, "lib.go")))
+	content, err := os.ReadFile(filepath.Join(dst, "lib.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, "package lib", string(content))
+}
+
+// readlink returns the target of a link.
+func readlink(t *testing.T, path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
@@ -0,0 +1,436 @@
+package deskfs
+
+import (
+	"bufio"
+	"desktop-cleaner/internal/filesystem/trees"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The trash follows the FreeDesktop.org Trash specification, so file managers list and restore
+// what organize trashes, and the other way around.
+const (
+	trashInfoExt      = ".trashinfo"
+	trashInfoHeader   = "[Trash Info]"
+	trashDateFormat   = "2006-01-02T15:04:05"
+	trashFilesDirName = "files"
+	trashInfoDirName  = "info"
+)
+
+// TrashItem is an entry of a trash directory, described by its .trashinfo file.
+type TrashItem struct {
+	Name         string    `json:"name"`      // Name in the trash directory, unique in it
+	TrashDir     string    `json:"trash_dir"` // Holds the files and info directories
+	OriginalPath string    `json:"original_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+}
+
+// Path returns where the trashed file or directory is kept.
+func (item TrashItem) Path() string {
+	return filepath.Join(item.TrashDir, trashFilesDirName, item.Name)
+}
+
+func (item TrashItem) infoPath() string {
+	return trashInfoPath(item.Path())
+}
+
+// trashInfoPath returns the .trashinfo file describing a path of the files directory of a trash.
+func trashInfoPath(trashed string) string {
+	trashDir := filepath.Dir(filepath.Dir(trashed))
+	return filepath.Join(trashDir, trashInfoDirName, filepath.Base(trashed)+trashInfoExt)
+}
+
+// homeTrashDir returns the trash of the user, $XDG_DATA_HOME/Trash.
+func (dfs *DesktopFS) homeTrashDir() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if !filepath.IsAbs(dataHome) {
+		dataHome = filepath.Join(dfs.HomeDir, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash")
+}
+
+// trashDirFor picks the trash a path goes to: the home trash when they share a device, and the
+// trash at the top of the mount of the path otherwise, so trashing never copies across devices.
+// That is $topdir/.Trash/$uid when the administrator provides a sticky $topdir/.Trash, and
+// $topdir/.Trash-$uid when it exists or can be created. The home trash is the last resort.
+func (dfs *DesktopFS) trashDirFor(path string) string {
+	home := dfs.homeTrashDir()
+
+	dev, ok := deviceID(filepath.Dir(path))
+	if !ok {
+		return home
+	}
+	if homeDev, ok := deviceID(existingAncestor(home)); !ok || homeDev == dev {
+		return home
+	}
+
+	topdir := mountTop(filepath.Dir(path), dev)
+	uid := strconv.Itoa(os.Getuid())
+
+	shared := filepath.Join(topdir, ".Trash")
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		return filepath.Join(shared, uid)
+	}
+
+	own := filepath.Join(topdir, ".Trash-"+uid)
+	if info, err := os.Lstat(own); err == nil {
+		if info.IsDir() {
+			return own
+		}
+	} else if dirWritable(topdir) {
+		return own
+	}
+
+	slog.Debug(fmt.Sprintf("No usable trash on the mount of %s, using %s\n", path, home))
+	return home
+}
+
+// existingAncestor returns the path, or its closest parent that exists.
+func existingAncestor(path string) string {
+	for !exists(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
+
+// mountTop walks up from dir to the top directory of the mount, the last one on device dev.
+func mountTop(dir string, dev uint64) string {
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		if parentDev, ok := deviceID(parent); !ok || parentDev != dev {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// trashTopdir returns the directory the paths of a mount trash are relative to, empty for the home trash.
+func (dfs *DesktopFS) trashTopdir(trashDir string) string {
+	if trashDir == dfs.homeTrashDir() {
+		return ""
+	}
+	if strings.HasPrefix(filepath.Base(trashDir), ".Trash-") {
+		return filepath.Dir(trashDir)
+	}
+	return filepath.Dir(filepath.Dir(trashDir)) // $topdir/.Trash/$uid
+}
+
+// trashName returns a name free in the trash, both for the file and its .trashinfo. taken reports
+// the names already claimed by a plan, it may be nil.
+func trashName(trashDir, name string, taken func(string) bool) string {
+	ext := filepath.Ext(name)
+	base := name[:len(name)-len(ext)]
+
+	candidate := name
+	for i := 2; ; i++ {
+		trashed := filepath.Join(trashDir, trashFilesDirName, candidate)
+		if !exists(trashed) && !exists(trashInfoPath(trashed)) && (taken == nil || !taken(trashed)) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+}
+
+// MoveToTrash moves a file or directory to the trash, where it can be restored from.
+func (dfs *DesktopFS) MoveToTrash(node *trees.DirectoryNode) error {
+	_, err := dfs.Trash(node.Path)
+	return err
+}
+
+// Trash moves a file or directory to the trash of its mount, recording where it came from.
+func (dfs *DesktopFS) Trash(path string) (*TrashItem, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trashDir := dfs.trashDirFor(path)
+	trashed := filepath.Join(trashDir, trashFilesDirName, trashName(trashDir, filepath.Base(path), nil))
+	return dfs.trashTo(path, trashed)
+}
+
+// trashTo moves path to trashed, a path in the files directory of a trash. The .trashinfo file is
+// created first and exclusively, it reserves the name.
+func (dfs *DesktopFS) trashTo(path, trashed string) (*TrashItem, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &TrashItem{
+		Name:         filepath.Base(trashed),
+		TrashDir:     filepath.Dir(filepath.Dir(trashed)),
+		OriginalPath: path,
+		DeletedAt:    time.Now(),
+	}
+	for _, dir := range []string{trashFilesDirName, trashInfoDirName} {
+		if err := os.MkdirAll(filepath.Join(item.TrashDir, dir), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create trash directory %s: %w", item.TrashDir, err)
+		}
+	}
+
+	if err := dfs.writeTrashInfo(item); err != nil {
+		return nil, err
+	}
+	if err := dfs.Move(&trees.DirectoryNode{Path: path}, trashed, info.IsDir(), false); err != nil {
+		os.Remove(item.infoPath())
+		return nil, fmt.Errorf("failed to move %s to the trash: %w", path, err)
+	}
+	return item, nil
+}
+
+func (dfs *DesktopFS) writeTrashInfo(item *TrashItem) error {
+	// Mount trashes record paths relative to the top of the mount, which may be mounted elsewhere later
+	path := item.OriginalPath
+	if topdir := dfs.trashTopdir(item.TrashDir); topdir != "" && within(topdir, path) {
+		path, _ = filepath.Rel(topdir, path)
+	}
+
+	file, err := os.OpenFile(item.infoPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reserve %s in the trash: %w", item.Name, err)
+	}
+	defer file.Close()
+
+	escaped := (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+	if _, err := fmt.Fprintf(file, "%s\nPath=%s\nDeletionDate=%s\n", trashInfoHeader, escaped, item.DeletedAt.Format(trashDateFormat)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", item.infoPath(), err)
+	}
+	return nil
+}
+
+// readTrashInfo parses the .trashinfo file of an item.
+func (dfs *DesktopFS) readTrashInfo(trashDir, infoFile string) (TrashItem, error) {
+	item := TrashItem{Name: strings.TrimSuffix(filepath.Base(infoFile), trashInfoExt), TrashDir: trashDir}
+
+	file, err := os.Open(infoFile)
+	if err != nil {
+		return item, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	section := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != trashInfoHeader {
+			continue
+		}
+
+		switch key {
+		case "Path":
+			path, err := url.PathUnescape(value)
+			if err != nil {
+				return item, fmt.Errorf("invalid path in %s: %w", infoFile, err)
+			}
+			path = filepath.FromSlash(path)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dfs.trashTopdir(trashDir), path)
+			}
+			item.OriginalPath = path
+		case "DeletionDate":
+			if item.DeletedAt, err = time.ParseInLocation(trashDateFormat, value, time.Local); err != nil {
+				return item, fmt.Errorf("invalid deletion date in %s: %w", infoFile, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return item, err
+	}
+	if item.OriginalPath == "" {
+		return item, fmt.Errorf("%s has no Path", infoFile)
+	}
+	return item, nil
+}
+
+// trashDirs returns the trash directories of the user that exist: the home trash, then the
+// trashes at the top of every mount.
+func (dfs *DesktopFS) trashDirs() []string {
+	dirs := []string{dfs.homeTrashDir()}
+	uid := strconv.Itoa(os.Getuid())
+
+	// A file system mounted twice, or bind mounted, shows the same trash under several paths
+	seen := make(map[string]bool)
+	if info, err := os.Stat(dirs[0]); err == nil {
+		if key, ok := fileKey(info); ok {
+			seen[key] = true
+		}
+	}
+
+	for _, topdir := range mountPoints() {
+		for _, dir := range []string{filepath.Join(topdir, ".Trash", uid), filepath.Join(topdir, ".Trash-"+uid)} {
+			info, err := os.Lstat(dir)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			key, ok := fileKey(info)
+			if !ok {
+				key = dir
+			}
+			if !seen[key] {
+				seen[key] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// TrashItems lists the items of every trash of the user, most recently trashed first.
+func (dfs *DesktopFS) TrashItems() ([]TrashItem, error) {
+	var items []TrashItem
+	for _, trashDir := range dfs.trashDirs() {
+		infoFiles, err := filepath.Glob(filepath.Join(trashDir, trashInfoDirName, "*"+trashInfoExt))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, infoFile := range infoFiles {
+			item, err := dfs.readTrashInfo(trashDir, infoFile)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Skipping trash entry: %v\n", err))
+				continue
+			}
+			if !exists(item.Path()) {
+				continue // Left behind by a file manager that crashed half way
+			}
+			items = append(items, item)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// FindTrashItem picks the item to restore: query is the name of the item in the trash, or the
+// original path of a file, absolute or relative to the working directory, or its base name.
+// The most recently trashed of several versions of the same file wins.
+func (dfs *DesktopFS) FindTrashItem(query string) (*TrashItem, error) {
+	items, err := dfs.TrashItems()
+	if err != nil {
+		return nil, err
+	}
+
+	absQuery, _ := filepath.Abs(query)
+	var matches []TrashItem
+	for _, item := range items {
+		if item.Name == query || item.OriginalPath == absQuery {
+			return &item, nil
+		}
+		if filepath.Base(item.OriginalPath) == query {
+			matches = append(matches, item)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("nothing named %q in the trash", query)
+	}
+	for _, match := range matches[1:] {
+		if match.OriginalPath != matches[0].OriginalPath {
+			return nil, fmt.Errorf("%q matches %s and %s, give the full path", query, matches[0].OriginalPath, match.OriginalPath)
+		}
+	}
+	return &matches[0], nil
+}
+
+// RestoreTrashItem moves an item back to its original path, recreating the missing directories.
+// A path taken in the meantime is never overwritten.
+func (dfs *DesktopFS) RestoreTrashItem(item *TrashItem) error {
+	if exists(item.OriginalPath) {
+		return fmt.Errorf("cannot restore %s, the path is taken", item.OriginalPath)
+	}
+
+	info, err := os.Lstat(item.Path())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(item.OriginalPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to recreate directory %s: %w", filepath.Dir(item.OriginalPath), err)
+	}
+	if err := dfs.Move(&trees.DirectoryNode{Path: item.Path()}, item.OriginalPath, info.IsDir(), false); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", item.OriginalPath, err)
+	}
+	return os.Remove(item.infoPath())
+}
+
+// EmptyTrash permanently deletes the items trashed more than olderThan ago, all of them when it
+// is zero, and returns them.
+func (dfs *DesktopFS) EmptyTrash(olderThan time.Duration) ([]TrashItem, error) {
+	items, err := dfs.TrashItems()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []TrashItem
+	cutoff := time.Now().Add(-olderThan)
+	for _, item := range items {
+		if olderThan > 0 && item.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		// The file goes first, an info file without one is only a stale entry
+		if err := os.RemoveAll(item.Path()); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %w", item.Path(), err)
+		}
+		if err := os.Remove(item.infoPath()); err != nil && !os.IsNotExist(err) {
+			return deleted, fmt.Errorf("failed to delete %s: %w", item.infoPath(), err)
+		}
+		deleted = append(deleted, item)
+	}
+	return deleted, nil
+}
+
+// mountPoints lists the mounted file systems from /proc/self/mounts, where available.
+func mountPoints() []string {
+	content, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return nil
+	}
+
+	var points []string
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		points = append(points, unescapeMountPath(fields[1]))
+	}
+	return points
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for a space) of the mounts table.
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if code, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
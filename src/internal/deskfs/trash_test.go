@@ -0,0 +1,169 @@
+package deskfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// trashTestFile trashes path and fails the test when it cannot.
+func trashTestFile(t *testing.T, dfs *DesktopFS, path string) *TrashItem {
+	item, err := dfs.Trash(path)
+	if err != nil {
+		t.Fatalf("failed to trash %s: %v", path, err)
+	}
+	return item
+}
+
+// onlyTestTrash skips tests that empty the trash when the trashes of the mounts hold items, they
+// would be emptied too.
+func onlyTestTrash(t *testing.T, dfs *DesktopFS) {
+	for _, trashDir := range dfs.trashDirs()[1:] {
+		if infoFiles, _ := filepath.Glob(filepath.Join(trashDir, trashInfoDirName, "*"+trashInfoExt)); len(infoFiles) > 0 {
+			t.Skipf("emptying the trash would delete the items of %s", trashDir)
+		}
+	}
+}
+
+func TestTrashRoundTrip(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"old notes 100%.md": "# notes"})
+	path := filepath.Join(root, "old notes 100%.md")
+
+	item := trashTestFile(t, dfs, path)
+	assert.NoFileExists(t, path)
+	assert.Equal(t, filepath.Join(os.Getenv("XDG_DATA_HOME"), "Trash"), item.TrashDir)
+	content, err := os.ReadFile(item.Path())
+	assert.NoError(t, err)
+	assert.Equal(t, "# notes", string(content))
+
+	// The .trashinfo file is what file managers read
+	info, err := os.ReadFile(item.infoPath())
+	assert.NoError(t, err)
+	assert.Contains(t, string(info), "[Trash Info]\nPath="+filepath.ToSlash(filepath.Join(root, "old%20notes%20100%25.md"))+"\n")
+
+	items, err := dfs.TrashItems()
+	assert.NoError(t, err)
+	assert.Contains(t, items, TrashItem{Name: item.Name, TrashDir: item.TrashDir, OriginalPath: path, DeletedAt: item.DeletedAt.Truncate(time.Second)})
+
+	for _, query := range []string{item.Name, path, "old notes 100%.md"} {
+		found, err := dfs.FindTrashItem(query)
+		if assert.NoError(t, err, query) {
+			assert.Equal(t, path, found.OriginalPath)
+		}
+	}
+	_, err = dfs.FindTrashItem("never trashed.md")
+	assert.Error(t, err)
+
+	// Restoring recreates the directory the file was in
+	if err := os.Remove(root); err != nil {
+		t.Fatal(err)
+	}
+	found, err := dfs.FindTrashItem(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, dfs.RestoreTrashItem(found))
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "# notes", string(content))
+	assert.NoFileExists(t, item.Path())
+	assert.NoFileExists(t, item.infoPath())
+}
+
+func TestTrashNameCollisions(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	first := newTestTree(t, map[string]string{"report.pdf": "first"})
+	second := newTestTree(t, map[string]string{"report.pdf": "second"})
+
+	firstItem := trashTestFile(t, dfs, filepath.Join(first, "report.pdf"))
+	secondItem := trashTestFile(t, dfs, filepath.Join(second, "report.pdf"))
+	assert.Equal(t, "report.pdf", firstItem.Name)
+	assert.Equal(t, "report.2.pdf", secondItem.Name)
+
+	// Two files of the same name are told apart by their full path
+	_, err := dfs.FindTrashItem("report.pdf")
+	assert.NoError(t, err, "the name of an item in the trash is unique")
+	found, err := dfs.FindTrashItem(filepath.Join(second, "report.pdf"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "report.2.pdf", found.Name)
+	}
+
+	// A path taken since is never overwritten
+	if err := os.WriteFile(filepath.Join(second, "report.pdf"), []byte("newer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorContains(t, dfs.RestoreTrashItem(secondItem), "the path is taken")
+	content, err := os.ReadFile(filepath.Join(second, "report.pdf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "newer", string(content))
+	assert.FileExists(t, secondItem.Path())
+}
+
+func TestFindTrashItemAmbiguous(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"a/todo.md": "first", "b/todo.md": "second"})
+	trashDir := dfs.homeTrashDir()
+
+	// Neither item is named todo.md in the trash
+	for _, dir := range []string{"a", "b"} {
+		if _, err := dfs.trashTo(filepath.Join(root, dir, "todo.md"), filepath.Join(trashDir, trashFilesDirName, "todo."+dir+".md")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := dfs.FindTrashItem("todo.md")
+	assert.ErrorContains(t, err, "give the full path")
+
+	found, err := dfs.FindTrashItem(filepath.Join(root, "b", "todo.md"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "todo.b.md", found.Name)
+	}
+
+	// An entry whose file is gone is not listed
+	if err := os.Remove(filepath.Join(trashDir, trashFilesDirName, "todo.a.md")); err != nil {
+		t.Fatal(err)
+	}
+	found, err = dfs.FindTrashItem("todo.md")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "todo.b.md", found.Name)
+	}
+}
+
+func TestEmptyTrash(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	onlyTestTrash(t, dfs)
+	root := newTestTree(t, map[string]string{"old.txt": "old", "new.txt": "new", "dir/file.txt": "file"})
+
+	old := trashTestFile(t, dfs, filepath.Join(root, "old.txt"))
+	trashTestFile(t, dfs, filepath.Join(root, "new.txt"))
+	dir := trashTestFile(t, dfs, filepath.Join(root, "dir"))
+
+	// old.txt was trashed two days ago
+	if err := os.Remove(old.infoPath()); err != nil {
+		t.Fatal(err)
+	}
+	old.DeletedAt = time.Now().Add(-48 * time.Hour)
+	if err := dfs.writeTrashInfo(old); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := dfs.EmptyTrash(24 * time.Hour)
+	assert.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, old.Name, deleted[0].Name)
+	}
+	assert.NoFileExists(t, old.Path())
+	assert.NoFileExists(t, old.infoPath())
+
+	deleted, err = dfs.EmptyTrash(0)
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.NoDirExists(t, dir.Path())
+
+	items, err := dfs.TrashItems()
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
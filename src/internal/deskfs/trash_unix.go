@@ -0,0 +1,10 @@
+//go:build !windows
+
+package deskfs
+
+import "golang.org/x/sys/unix"
+
+// dirWritable reports whether the user may create entries in dir.
+func dirWritable(dir string) bool {
+	return unix.Access(dir, unix.W_OK) == nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package deskfs
+
+// dirWritable is only consulted for mount trashes, which Windows never uses as device IDs are not available.
+func dirWritable(dir string) bool {
+	return false
+}
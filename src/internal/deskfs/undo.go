@@ -0,0 +1,161 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/db"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// UndoConfirm asks the user a yes/no question before undo overwrites or restores changed files.
+type UndoConfirm func(question string) bool
+
+// UndoReport counts the operations an undo reversed and the ones it left in place.
+type UndoReport struct {
+	Restored int
+	Skipped  int
+}
+
+// ListRuns returns the journaled organize runs of a source directory, most recent first.
+func (dfs *DesktopFS) ListRuns(sourceDir string) ([]db.OrganizeRun, error) {
+	if !exists(createWorkspacePath(sourceDir)) {
+		return nil, nil
+	}
+
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	return journal.ListRuns()
+}
+
+// FindRun returns the run to undo: the run whose ID starts with id, or the most recent completed
+// run when id is empty.
+func (dfs *DesktopFS) FindRun(sourceDir, id string) (*db.OrganizeRun, error) {
+	runs, err := dfs.ListRuns(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *db.OrganizeRun
+	for i, run := range runs {
+		if id == "" {
+			if run.Status == db.RunCompleted {
+				return &runs[i], nil
+			}
+			continue
+		}
+
+		if strings.HasPrefix(run.ID.String(), strings.ToLower(id)) {
+			if found != nil {
+				return nil, fmt.Errorf("run id %q is ambiguous", id)
+			}
+			found = &runs[i]
+		}
+	}
+
+	if found == nil {
+		if id == "" {
+			return nil, fmt.Errorf("no completed organize run recorded in %s", sourceDir)
+		}
+		return nil, fmt.Errorf("no organize run %q recorded in %s", id, sourceDir)
+	}
+	return found, nil
+}
+
+// UndoRun reverses the applied operations of a run from their recorded source and destination
+// pairs, last first: files and projects go back to where they were, recreating the directories
+// they left, and the directories the run created are removed once empty. A file modified after it
+// was moved, or a file now sitting at the original path, is only touched when confirm agrees.
+// Operations left in place keep the run undoable, so undo can be run again once they are sorted out.
+func (dfs *DesktopFS) UndoRun(sourceDir string, runID uuid.UUID, confirm UndoConfirm) (*UndoReport, error) {
+	journal, err := dfs.OpenJournal(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	defer journal.Close()
+
+	records, err := journal.RunOperations(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UndoReport{}
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Status != db.OperationDone {
+			continue
+		}
+
+		op := operationFromHistory(record)
+		overwrite, ok := undoDecision(op, record, confirm)
+		if !ok {
+			slog.Info(fmt.Sprintf("Leaving %s in place\n", op.Destination))
+			report.Skipped++
+			continue
+		}
+
+		if err := dfs.reverseOperation(op, overwrite); err != nil {
+			return report, fmt.Errorf("failed to undo %s %s: %w", op.Type, op.Destination, err)
+		}
+		if op.Type == OpCreateDir {
+			if exists(op.Destination) {
+				continue // Still holds files left in place, a later undo removes it with them
+			}
+		} else {
+			report.Restored++
+		}
+		if err := journal.SetOperationStatus(record.ID, db.OperationUndone); err != nil {
+			return report, err
+		}
+	}
+
+	if report.Skipped > 0 {
+		return report, nil
+	}
+	return report, journal.FinishRun(runID, db.RunUndone)
+}
+
+// undoDecision checks an operation against the disk and asks confirm when undoing it would lose
+// changes. It returns whether the original path may be overwritten, and whether to undo at all.
+func undoDecision(op Operation, record db.OperationHistory, confirm UndoConfirm) (bool, bool) {
+	if op.Type == OpCreateDir {
+		return false, true
+	}
+
+	// The journal marks an operation done right after applying it, anything newer is a later edit
+	if info, err := os.Stat(op.Destination); err == nil && !info.IsDir() && info.ModTime().After(record.TimeStamp) {
+		question := fmt.Sprintf("%s was modified after it was organized, restore it anyway?", op.Destination)
+		if op.Type == OpCopy && !op.RemoveSource {
+			question = fmt.Sprintf("The copy %s was modified after it was organized, delete it anyway?", op.Destination)
+		}
+		if !confirm(question) {
+			return false, false
+		}
+	}
+
+	if op.Type == OpCopy && !op.RemoveSource {
+		return false, true // The source was kept, only the copy goes
+	}
+	if op.Type == OpHardlink {
+		return false, true // The source is the kept file, the duplicate only gets its own copy back
+	}
+
+	info, err := os.Lstat(op.Source)
+	if err != nil {
+		return false, true
+	}
+	if info.IsDir() {
+		slog.Warn(fmt.Sprintf("Cannot restore %s, a directory now takes its place\n", op.Source))
+		return false, false
+	}
+	if !confirm(fmt.Sprintf("%s exists again, overwrite it with %s?", op.Source, op.Destination)) {
+		return false, false
+	}
+	return true, true
+}
@@ -0,0 +1,133 @@
+package deskfs
+
+import (
+	"context"
+	"desktop-cleaner/internal/db"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// organizeTestTree organizes root in place with params and returns the plan applied.
+func organizeTestTree(t *testing.T, dfs *DesktopFS, root string, params *FilePathParams) *OrganizePlan {
+	plan := buildTestPlan(t, dfs, newTestConfig(nil, testRules), params)
+	if err := dfs.ApplyPlan(context.Background(), plan, ApplyOptions{}); err != nil {
+		t.Fatalf("failed to organize %s: %v", root, err)
+	}
+	return plan
+}
+
+// neverAsked fails the test when undo asks anything.
+func neverAsked(t *testing.T) UndoConfirm {
+	return func(question string) bool {
+		t.Errorf("unexpected question: %s", question)
+		return false
+	}
+}
+
+func TestUndoRun(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a.jpg":      "jpeg",
+		"deep/b.md":  "# notes",
+		"mystery.xy": "?",
+	})
+	before := snapshotTree(t, root)
+	plan := organizeTestTree(t, dfs, root, newTestParams(root))
+	assert.NotEqual(t, before, snapshotTree(t, root))
+
+	run, err := dfs.FindRun(root, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, plan.ID, run.ID)
+
+	report, err := dfs.UndoRun(root, run.ID, neverAsked(t))
+	assert.NoError(t, err)
+	assert.Equal(t, &UndoReport{Restored: 2}, report)
+
+	// The files are back, the directories the run created are gone
+	assert.Equal(t, before, snapshotTree(t, root))
+	assert.Equal(t, db.RunUndone, runStatus(t, dfs, root, run.ID))
+
+	_, err = dfs.FindRun(root, "")
+	assert.Error(t, err, "an undone run is not undone again")
+}
+
+func TestUndoCopyRun(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"a.jpg": "jpeg"})
+	before := snapshotTree(t, root)
+
+	params := newTestParams(root)
+	params.CopyFiles = true
+	plan := organizeTestTree(t, dfs, root, params)
+	assert.FileExists(t, filepath.Join(root, "a.jpg"))
+	assert.FileExists(t, filepath.Join(root, "Pics", "a.jpg"))
+
+	_, err := dfs.UndoRun(root, plan.ID, neverAsked(t))
+	assert.NoError(t, err)
+	assert.Equal(t, before, snapshotTree(t, root))
+}
+
+func TestUndoRunAsksBeforeLosingChanges(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{
+		"a.jpg": "jpeg",
+		"b.md":  "# notes",
+	})
+	plan := organizeTestTree(t, dfs, root, newTestParams(root))
+
+	// a.jpg is edited after it was organized, and a new b.md takes the place of the old one
+	edited := filepath.Join(root, "Pics", "a.jpg")
+	if err := os.WriteFile(edited, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(edited, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "b.md"), []byte("new notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var questions []string
+	report, err := dfs.UndoRun(root, plan.ID, func(question string) bool {
+		questions = append(questions, question)
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Len(t, questions, 2)
+	assert.Equal(t, &UndoReport{Skipped: 2}, report)
+
+	// Everything is left in place, the run can be undone again
+	assert.FileExists(t, edited)
+	assert.FileExists(t, filepath.Join(root, "Notes", "b.md"))
+	assert.Equal(t, db.RunCompleted, runStatus(t, dfs, root, plan.ID))
+
+	report, err = dfs.UndoRun(root, plan.ID, func(string) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, &UndoReport{Restored: 2}, report)
+	assert.Equal(t, map[string]string{"a.jpg": "edited", "b.md": "# notes"}, snapshotTree(t, root))
+	assert.Equal(t, db.RunUndone, runStatus(t, dfs, root, plan.ID))
+}
+
+func TestFindRun(t *testing.T) {
+	dfs := newTestDeskFS(t)
+	root := newTestTree(t, map[string]string{"a.jpg": "jpeg"})
+
+	_, err := dfs.FindRun(root, "")
+	assert.Error(t, err, "nothing was organized yet")
+
+	plan := organizeTestTree(t, dfs, root, newTestParams(root))
+	run, err := dfs.FindRun(root, plan.ID.String()[:8])
+	if assert.NoError(t, err) {
+		assert.Equal(t, plan.ID, run.ID)
+	}
+
+	_, err = dfs.FindRun(root, "not-a-run")
+	assert.Error(t, err)
+}
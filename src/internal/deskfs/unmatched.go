@@ -0,0 +1,67 @@
+package deskfs
+
+import (
+	"desktop-cleaner/internal/filesystem/trees"
+	"sort"
+	"sync"
+)
+
+// UnmatchedExtension counts the files of one extension that matched no rule.
+type UnmatchedExtension struct {
+	Extension  string `json:"extension"`   // Empty for files without an extension
+	Count      int    `json:"count"`       // Files that matched no rule
+	FallenBack int    `json:"fallen_back"` // Of those, files caught by a top level fallback category
+}
+
+// UnmatchedReport collects the extensions no rule matched during a run, to help grow the
+// `file_types` config from real data. It is safe for concurrent use, and a nil report ignores adds.
+type UnmatchedReport struct {
+	mu         sync.Mutex
+	extensions map[string]*UnmatchedExtension
+}
+
+func NewUnmatchedReport() *UnmatchedReport {
+	return &UnmatchedReport{extensions: make(map[string]*UnmatchedExtension)}
+}
+
+// Add records a file that matched no rule, and whether a fallback category caught it.
+func (r *UnmatchedReport) Add(file *trees.FileNode, fallenBack bool) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.extensions[file.Extension]
+	if !ok {
+		entry = &UnmatchedExtension{Extension: file.Extension}
+		r.extensions[file.Extension] = entry
+	}
+	entry.Count++
+	if fallenBack {
+		entry.FallenBack++
+	}
+}
+
+// Extensions returns the unmatched extensions, most frequent first.
+func (r *UnmatchedReport) Extensions() []UnmatchedExtension {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	extensions := make([]UnmatchedExtension, 0, len(r.extensions))
+	for _, entry := range r.extensions {
+		extensions = append(extensions, *entry)
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		if extensions[i].Count != extensions[j].Count {
+			return extensions[i].Count > extensions[j].Count
+		}
+		return extensions[i].Extension < extensions[j].Extension
+	})
+	return extensions
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin
+
+package deskfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the extended attributes of src to dst. A target file system without extended
+// attributes is not an error, and neither is an attribute the user may not set, e.g. security.*.
+func copyXattrs(src, dst string) error {
+	size, err := unix.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		if err != nil && !errors.Is(err, unix.ENOTSUP) {
+			return err
+		}
+		return nil
+	}
+
+	names := make([]byte, size)
+	if size, err = unix.Listxattr(src, names); err != nil {
+		return err
+	}
+
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		attr := string(name)
+		valueSize, err := unix.Getxattr(src, attr, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = unix.Getxattr(src, attr, value); err != nil {
+			return err
+		}
+
+		if err := unix.Setxattr(dst, attr, value[:valueSize], 0); err != nil {
+			if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+				slog.Debug(fmt.Sprintf("Dropping extended attribute %s of %s: %v\n", attr, dst, err))
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
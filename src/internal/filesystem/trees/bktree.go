@@ -0,0 +1,83 @@
+package trees
+
+// BKTree indexes values by perceptual hash for searches by Hamming distance. Hamming distance is a
+// metric, so a search only descends into the children whose distance to the node is within the
+// search radius of the query's, which the axis splits of the KD-tree cannot do for 64 single bits.
+type BKTree[T any] struct {
+	root *bkNode[T]
+	size int
+}
+
+type bkNode[T any] struct {
+	hash     PerceptualHash
+	values   []T // Every value added with this exact hash
+	children map[int]*bkNode[T]
+}
+
+// BKMatch is a value found by BKTree.Search.
+type BKMatch[T any] struct {
+	Hash     PerceptualHash
+	Value    T
+	Distance int // Hamming distance to the query
+}
+
+// Len returns the number of values in the tree.
+func (t *BKTree[T]) Len() int {
+	return t.size
+}
+
+// Add indexes value by hash.
+func (t *BKTree[T]) Add(hash PerceptualHash, value T) {
+	t.size++
+	if t.root == nil {
+		t.root = &bkNode[T]{hash: hash, values: []T{value}}
+		return
+	}
+
+	node := t.root
+	for {
+		distance := node.hash.Distance(hash)
+		if distance == 0 {
+			node.values = append(node.values, value)
+			return
+		}
+		child, ok := node.children[distance]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode[T])
+			}
+			node.children[distance] = &bkNode[T]{hash: hash, values: []T{value}}
+			return
+		}
+		node = child
+	}
+}
+
+// Search returns the values whose hash is at most maxDistance bits away from hash, in no
+// particular order.
+func (t *BKTree[T]) Search(hash PerceptualHash, maxDistance int) []BKMatch[T] {
+	var matches []BKMatch[T]
+	if t.root == nil {
+		return matches
+	}
+
+	pending := []*bkNode[T]{t.root}
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		distance := node.hash.Distance(hash)
+		if distance <= maxDistance {
+			for _, value := range node.values {
+				matches = append(matches, BKMatch[T]{Hash: node.hash, Value: value, Distance: distance})
+			}
+		}
+		// By the triangle inequality, matches only live below the edges within maxDistance of distance
+		for edge, child := range node.children {
+			if edge >= distance-maxDistance && edge <= distance+maxDistance {
+				pending = append(pending, child)
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,54 @@
+package trees
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBKTreeMatchesLinearSearch(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+	hashes := make([]PerceptualHash, 2000)
+	for i := range hashes {
+		hashes[i] = PerceptualHash(random.Uint64())
+		// Near copies of earlier hashes, the case the tree is for
+		if i > 0 && i%3 == 0 {
+			hashes[i] = hashes[random.IntN(i)] ^ PerceptualHash(1)<<random.IntN(64)
+		}
+	}
+	hashes = append(hashes, hashes[0]) // The same hash twice
+
+	var tree BKTree[int]
+	for i, hash := range hashes {
+		tree.Add(hash, i)
+	}
+	assert.Equal(t, len(hashes), tree.Len())
+
+	for _, maxDistance := range []int{0, 1, 4, 20} {
+		for _, query := range hashes[:50] {
+			var expected, found []int
+			for i, hash := range hashes {
+				if query.Distance(hash) <= maxDistance {
+					expected = append(expected, i)
+				}
+			}
+			for _, match := range tree.Search(query, maxDistance) {
+				assert.Equal(t, query.Distance(match.Hash), match.Distance)
+				found = append(found, match.Value)
+			}
+			slices.Sort(found)
+			assert.Equal(t, expected, found, "distance %d", maxDistance)
+		}
+	}
+
+	var empty BKTree[string]
+	assert.Empty(t, empty.Search(0, 64))
+}
+
+func TestPerceptualHashDistance(t *testing.T) {
+	assert.Equal(t, 0, PerceptualHash(0xff).Distance(0xff))
+	assert.Equal(t, 2, PerceptualHash(0b101).Distance(0b000))
+	assert.Equal(t, 64, PerceptualHash(0).Distance(^PerceptualHash(0)))
+}
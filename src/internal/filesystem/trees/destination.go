@@ -0,0 +1,101 @@
+package trees
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	templateOpen  = "{{"
+	templateClose = "}}"
+)
+
+var destinationFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join":  strings.Join,
+}
+
+// DestinationData is the value a category path template is rendered against.
+// Metadata is embedded so templates can use e.g. {{.ModifiedAt.Year}} or {{.Size}} directly.
+type DestinationData struct {
+	Metadata
+	Name      string // File name, including extension
+	Extension string // Lowercase extension without the leading dot, e.g. "pdf" or "tar.gz"
+	Path      string // Full source path of the file
+}
+
+// NewDestinationData builds the template data for a file.
+func NewDestinationData(file *FileNode) DestinationData {
+	return DestinationData{
+		Metadata:  file.Metadata,
+		Name:      file.Name,
+		Extension: strings.TrimPrefix(strings.ToLower(file.Extension), "."),
+		Path:      file.Path,
+	}
+}
+
+// IsTemplatePath reports whether a category path contains template actions.
+func IsTemplatePath(path string) bool {
+	return strings.Contains(path, templateOpen)
+}
+
+// SplitCategoryPath splits a category path on "/" while leaving template actions intact,
+// so `Pics/{{.ModifiedAt.Format "2006/01"}}` keeps its action in a single segment.
+func SplitCategoryPath(path string) []string {
+	var segments []string
+	var current strings.Builder
+	depth := 0
+
+	for i := 0; i < len(path); i++ {
+		switch {
+		case strings.HasPrefix(path[i:], templateOpen):
+			depth++
+			current.WriteString(templateOpen)
+			i++
+		case strings.HasPrefix(path[i:], templateClose) && depth > 0:
+			depth--
+			current.WriteString(templateClose)
+			i++
+		case path[i] == '/' && depth == 0:
+			segments = append(segments, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(path[i])
+		}
+	}
+
+	return append(segments, current.String())
+}
+
+// CompileDestination parses a category path template.
+func CompileDestination(path string) (*template.Template, error) {
+	tmpl, err := template.New(path).Funcs(destinationFuncs).Option("missingkey=error").Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination template %q: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// RenderDestination renders the node's destination template for a file. The rendered path
+// is cleaned and must stay relative, a template cannot escape the target directory.
+func (n *FileTypeNode) RenderDestination(file *FileNode) (string, error) {
+	if n.Template == nil {
+		return "", fmt.Errorf("category %s has no destination template", n.Name)
+	}
+
+	var buf bytes.Buffer
+	if err := n.Template.Execute(&buf, NewDestinationData(file)); err != nil {
+		return "", fmt.Errorf("failed to render destination for %s: %w", file.Name, err)
+	}
+
+	rendered := filepath.Clean(filepath.FromSlash(buf.String()))
+	if filepath.IsAbs(rendered) || rendered == ".." || strings.HasPrefix(rendered, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("rendered destination %q escapes the target directory", rendered)
+	}
+
+	return rendered, nil
+}
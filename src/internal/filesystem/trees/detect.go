@@ -0,0 +1,225 @@
+package trees
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	mimePrefix = "mime:"
+
+	// Content is only consulted when the name says nothing better, so MIME rules rank below extensions.
+	// Use `priority=N` on a category to let its MIME rules win over extensions.
+	mimeSpecificity = 50
+
+	sniffLen = 512
+)
+
+// magic describes a file signature at a fixed offset.
+type magic struct {
+	offset    int
+	signature []byte
+	mimeType  string
+}
+
+// signatures covers common types that http.DetectContentType does not know about, or reports too generically.
+var signatures = []magic{
+	{0, []byte("\x1f\x8b"), "application/gzip"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("Rar!\x1a\x07"), "application/vnd.rar"},
+	{257, []byte("ustar"), "application/x-tar"},
+	{0, []byte("!<arch>\ndebian"), "application/vnd.debian.binary-package"},
+	{0, []byte("\xed\xab\xee\xdb"), "application/x-rpm"},
+	{0, []byte("\x7fELF"), "application/x-executable"},
+	{0, []byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{0, []byte("\x1a\x45\xdf\xa3"), "video/x-matroska"},
+	{4, []byte("ftypqt"), "video/quicktime"},
+	{4, []byte("ftyp"), "video/mp4"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("d8:announce"), "application/x-bittorrent"},
+	{30, []byte("mimetypeapplication/epub+zip"), "application/epub+zip"},
+}
+
+// canonicalExtensions maps detected MIME types to the extension a correctly named file would have.
+var canonicalExtensions = map[string]string{
+	"application/gzip":                              ".gz",
+	"application/x-bzip2":                           ".bz2",
+	"application/x-xz":                              ".xz",
+	"application/zstd":                              ".zst",
+	"application/x-7z-compressed":                   ".7z",
+	"application/vnd.rar":                           ".rar",
+	"application/x-rar-compressed":                  ".rar",
+	"application/x-tar":                             ".tar",
+	"application/zip":                               ".zip",
+	"application/vnd.debian.binary-package":         ".deb",
+	"application/x-rpm":                             ".rpm",
+	"application/vnd.microsoft.portable-executable": ".exe",
+	"application/pdf":                               ".pdf",
+	"application/epub+zip":                          ".epub",
+	"application/x-bittorrent":                      ".torrent",
+	"image/png":                                     ".png",
+	"image/jpeg":                                    ".jpg",
+	"image/gif":                                     ".gif",
+	"image/bmp":                                     ".bmp",
+	"image/webp":                                    ".webp",
+	"video/mp4":                                     ".mp4",
+	"video/quicktime":                               ".mov",
+	"video/x-matroska":                              ".mkv",
+	"video/avi":                                     ".avi",
+	"video/webm":                                    ".webm",
+	"audio/mpeg":                                    ".mp3",
+	"audio/wave":                                    ".wav",
+	"audio/ogg":                                     ".ogg",
+	"application/ogg":                               ".ogg",
+	"audio/flac":                                    ".flac",
+}
+
+// extensionAliases maps alternative spellings to the canonical extension of the same content type.
+var extensionAliases = map[string]string{
+	".jpeg": ".jpg",
+	".jpe":  ".jpg",
+	".tgz":  ".gz",
+	".tbz2": ".bz2",
+	".txz":  ".xz",
+	".webm": ".mkv", // WebM is a Matroska profile and carries the same signature
+	".m4v":  ".mp4",
+	".oga":  ".ogg",
+	".ogv":  ".ogg",
+}
+
+// compoundInnerExtensions are the extensions that form a compound one with the extension after them.
+var compoundInnerExtensions = map[string]bool{
+	".tar": true,
+}
+
+// FileExtension returns the lowercase extension of a file name. Compound extensions are kept
+// whole, "backup.tar.gz" has the extension ".tar.gz" rather than ".gz".
+func FileExtension(name string) string {
+	ext := filepath.Ext(name)
+	inner := filepath.Ext(strings.TrimSuffix(name, ext))
+	if ext != "" && compoundInnerExtensions[strings.ToLower(inner)] {
+		ext = inner + ext
+	}
+	return strings.ToLower(ext)
+}
+
+// DetectFileType sniffs the first bytes of a file and returns its MIME type, without parameters.
+func DetectFileType(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// ustar magic sits at offset 257, read a little more than the usual 512 bytes
+	head := make([]byte, sniffLen+8)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return DetectContentType(head[:n]), nil
+}
+
+// DetectContentType returns the MIME type for the given leading bytes of a file.
+func DetectContentType(head []byte) string {
+	for _, sig := range signatures {
+		end := sig.offset + len(sig.signature)
+		if len(head) >= end && bytes.Equal(head[sig.offset:end], sig.signature) {
+			return sig.mimeType
+		}
+	}
+
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+
+	detected, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return detected
+}
+
+// ExtensionForMIME returns the canonical extension for a MIME type, or "" when unknown.
+func ExtensionForMIME(mimeType string) string {
+	return canonicalExtensions[mimeType]
+}
+
+// ContradictsContent reports whether a sniffed content type rules out the extension of a file.
+// Only extensions with a known signature can be contradicted, a ".docx" is a zip file after all.
+func ContradictsContent(ext, mimeType string) bool {
+	detected := canonicalExtensions[mimeType]
+	return detected != "" && HasSignature(ext) && canonicalExtension(ext) != detected
+}
+
+// HasSignature reports whether files with the extension can be recognised by their content.
+func HasSignature(ext string) bool {
+	ext = canonicalExtension(ext)
+	for _, canonical := range canonicalExtensions {
+		if canonical == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalExtension resolves aliases, and checks a compound extension by its last part since
+// a ".tar.gz" is a gzip file.
+func canonicalExtension(ext string) string {
+	ext = filepath.Ext(ext)
+	if alias, ok := extensionAliases[ext]; ok {
+		return alias
+	}
+	return ext
+}
+
+// MatchMIME reports whether a MIME pattern such as "image/*" matches a MIME type.
+func MatchMIME(pattern, mimeType string) bool {
+	if mimeType == "" {
+		return false
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(mimeType))
+	return matched
+}
+
+// matchesExtension compares a rule extension with the file. Compound rules FileExtension does
+// not know about, such as ".user.js", are matched against the end of the name, and a compound
+// file extension also matches its last part, so "backup.tar.gz" falls back to a ".gz" rule.
+func matchesExtension(ruleExt string, file *FileNode) bool {
+	switch {
+	case ruleExt == file.Extension:
+		return true
+	case strings.Count(ruleExt, ".") > 1:
+		return strings.HasSuffix(strings.ToLower(file.Name), strings.ToLower(ruleExt))
+	default:
+		return strings.HasSuffix(file.Extension, ruleExt) && strings.Count(file.Extension, ".") > 1
+	}
+}
+
+// HasMIMERules reports whether any category in the tree matches on content type.
+func (tree *FileTypeTree) HasMIMERules() bool {
+	return hasMIMERules(tree.Root)
+}
+
+func hasMIMERules(node *FileTypeNode) bool {
+	if len(node.MimeTypes) > 0 {
+		return true
+	}
+	for _, child := range node.Children {
+		if hasMIMERules(child) {
+			return true
+		}
+	}
+	return false
+}
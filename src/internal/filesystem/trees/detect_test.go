@@ -0,0 +1,86 @@
+package trees
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectFileType(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"photo":        []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+		"archive.pdf":  []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"),
+		"document.bin": []byte("%PDF-1.7\n"),
+		"notes":        []byte("just some text"),
+	}
+	expected := map[string]string{
+		"photo":        "image/png",
+		"archive.pdf":  "application/gzip",
+		"document.bin": "application/pdf",
+		"notes":        "text/plain",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, content, 0644))
+
+		mimeType, err := DetectFileType(path)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[name], mimeType, name)
+	}
+
+	assert.Equal(t, ".png", ExtensionForMIME("image/png"))
+	assert.Equal(t, "", ExtensionForMIME("text/plain"))
+}
+
+func TestFileExtension(t *testing.T) {
+	assert.Equal(t, ".tar.gz", FileExtension("backup.tar.gz"))
+	assert.Equal(t, ".tar.xz", FileExtension("Linux.TAR.XZ"))
+	assert.Equal(t, ".gz", FileExtension("access.log.gz"))
+	assert.Equal(t, ".pdf", FileExtension("v1.2.report.pdf"))
+	assert.Equal(t, ".tar", FileExtension("archive.tar"))
+	assert.Equal(t, "", FileExtension("Makefile"))
+
+	assert.Equal(t, "tar.gz", NewDestinationData(newTestFile("backup.tar.gz")).Extension)
+}
+
+func TestContradictsContent(t *testing.T) {
+	assert.True(t, ContradictsContent(".pdf", "image/png"))
+	assert.True(t, ContradictsContent(".jpg", "application/gzip"))
+	assert.False(t, ContradictsContent(".jpeg", "image/jpeg"))
+	assert.False(t, ContradictsContent(".tar.gz", "application/gzip"))
+	assert.False(t, ContradictsContent(".webm", "video/x-matroska"))
+	assert.False(t, ContradictsContent(".docx", "application/zip"))
+	assert.False(t, ContradictsContent(".pdf", "text/plain"))
+}
+
+func TestResolveCompoundAndMIME(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateFileTypes(map[string][]string{
+		"Compressed":         {".gz", ".zip"},
+		"Compressed/Tarball": {".tar.gz", ".tgz"},
+		"Pics":               {".jpg", "mime:image/*"},
+		"Docs":               {".txt"},
+	})
+
+	assert.Equal(t, filepath.Join("Compressed", "Tarball"), resolvedPath(tree, "backup.tar.gz"))
+	assert.Equal(t, "Compressed", resolvedPath(tree, "log.gz"))
+	assert.Equal(t, "Compressed", resolvedPath(tree, "backup.tar.gz.gz"))
+	assert.True(t, tree.HasMIMERules())
+
+	untitled := newTestFile("untitled")
+	untitled.MimeType = "image/png"
+	match, found := tree.Resolve(untitled)
+	assert.True(t, found)
+	assert.Equal(t, "Pics", match.Node.Name)
+	assert.Equal(t, "mime:image/*", match.Rule)
+
+	// Extensions outrank content types unless a priority says otherwise
+	misnamed := newTestFile("photo.txt")
+	misnamed.MimeType = "image/jpeg"
+	match, _ = tree.Resolve(misnamed)
+	assert.Equal(t, "Docs", match.Node.Name)
+}
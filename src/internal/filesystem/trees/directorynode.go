@@ -19,6 +19,7 @@ type FileNode struct {
 	Path      string    `json:"path"`
 	Name      string    `json:"name"`
 	Extension string    `json:"extension"`
+	MimeType  string    `json:"mime_type,omitempty"` // Sniffed content type, filled in on demand
 	Metadata  Metadata  `json:"metadata"`
 }
 
@@ -30,6 +31,8 @@ type DirectoryNode struct {
 	Children []*DirectoryNode `json:"-"` // Omit Children from JSON output, use IDs instead
 	Files    []*FileNode      `json:"files"`
 	Metadata Metadata         `json:"metadata"`
+	// IsProject marks a project root (e.g. a Git checkout) that is organized as a single unit
+	IsProject bool `json:"is_project,omitempty"`
 }
 
 type directoryNodeJSON struct {
@@ -77,6 +80,11 @@ func (directorynode *DirectoryNode) AddFile(file *FileNode) *DirectoryNode {
 	return directorynode
 }
 
+// HasMetadata reports whether the file was stat'ed. Files indexed by name only have no metadata.
+func (file *FileNode) HasMetadata() bool {
+	return !file.Metadata.ModifiedAt.IsZero()
+}
+
 func (directorynode *DirectoryNode) String() string {
 	return directorynode.Path
 }
@@ -114,7 +114,7 @@ func (tree *DirectoryTree) AddFile(path string, filePath string, size int64, mod
 	// Now that we're at the target directory, add the file node
 	targetNode.AddFile(&FileNode{
 		Path:       filePath,
-		Extension:  filepath.Ext(filePath),
+		Extension:  FileExtension(filePath),
 	})
 
 	return nil
@@ -0,0 +1,36 @@
+package trees
+
+const fallbackEntry = "fallback"
+
+// FallbackChild returns the first fallback category directly below the node, nil when it has none.
+func (n *FileTypeNode) FallbackChild() *FileTypeNode {
+	var fallback *FileTypeNode
+	for _, child := range n.Children {
+		if child.Fallback && (fallback == nil || child.Order < fallback.Order) {
+			fallback = child
+		}
+	}
+	return fallback
+}
+
+// ApplyFallback routes a resolved file to a fallback category. A file claimed by a category with a
+// fallback child, e.g. "Docs" with "Docs/Misc", goes to the fallback child, since none of the more
+// specific subcategories took it. A file no category claims goes to the top level fallback, if any.
+func (tree *FileTypeTree) ApplyFallback(match RuleMatch, found bool) (RuleMatch, bool) {
+	if !found {
+		fallback := tree.Root.FallbackChild()
+		if fallback == nil {
+			return match, false
+		}
+		return RuleMatch{Node: fallback, Rule: fallbackEntry, Fallback: true}, true
+	}
+
+	fallback := match.Node.FallbackChild()
+	if fallback == nil {
+		return match, true
+	}
+
+	match.Node = fallback
+	match.Fallback = true
+	return match, true
+}
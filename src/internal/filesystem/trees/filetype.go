@@ -3,15 +3,25 @@ package trees
 import (
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
+	"text/template"
 )
 
 // FileTypeNode represents a folder and associated file types
 type FileTypeNode struct {
 	Name       string
-	Extensions []string        // File extensions associated with this folder
-	Parent     *FileTypeNode   // Reference to the parent node, added here
-	Children   []*FileTypeNode // Sub-categories or sub-folders for nested types
+	Extensions []string             // File extensions associated with this folder
+	Patterns   []*NamePattern       // Glob and regex file name patterns associated with this folder
+	MimeTypes  []string             // Content type patterns matched against the sniffed type, e.g. "image/*"
+	Predicates []*MetadataPredicate // Metadata conditions (size, age, permissions, tags) a file must satisfy
+	Template   *template.Template   // Destination template, set when the category path contains template actions
+	Priority   int                  // Explicit precedence from a `priority=N` entry, higher wins
+	Order      int                  // Position of the category in the config file, earlier wins ties
+	Fallback   bool                 // Catch-all for files its parent category (or no category) claims without a better match
+	Conflict   string               // Conflict resolution strategy from a `conflict=<strategy>` entry, empty to inherit
+	Parent     *FileTypeNode        // Reference to the parent node, added here
+	Children   []*FileTypeNode      // Sub-categories or sub-folders for nested types
 }
 
 type FileTypeTree struct {
@@ -23,6 +33,9 @@ func NewFileTypeNode(name string) *FileTypeNode {
 	return &FileTypeNode{
 		Name:       name,
 		Extensions: []string{},
+		Patterns:   []*NamePattern{},
+		MimeTypes:  []string{},
+		Predicates: []*MetadataPredicate{},
 		Children:   []*FileTypeNode{},
 	}
 }
@@ -98,6 +111,20 @@ func (filetype *FileTypeNode) String() string {
 	return filetype.Name
 }
 
+// Path returns the category path of the node, e.g. "Docs/Reports", without the root.
+func (filetype *FileTypeNode) Path() string {
+	var segments []string
+	for current := filetype; current != nil && !current.IsRoot(); current = current.Parent {
+		segments = append([]string{current.Name}, segments...)
+	}
+	return strings.Join(segments, "/")
+}
+
+// HasRules reports whether any extension, pattern or predicate is attached to the node.
+func (filetype *FileTypeNode) HasRules() bool {
+	return len(filetype.Extensions) > 0 || len(filetype.Patterns) > 0 || len(filetype.MimeTypes) > 0 || len(filetype.Predicates) > 0
+}
+
 func (filetype *FileTypeNode) IsRoot() bool {
 	return filetype.Name == "root"
 }
@@ -113,7 +140,7 @@ func (filetype *FileTypeNode) Flatten() []string {
 }
 
 func flattenFileTypeNode(node *FileTypeNode, currentFileType string, filetypes *[]string) {
-	if len(node.Extensions) > 0 {
+	if node.HasRules() {
 		*filetypes = append(*filetypes, currentFileType)
 	}
 
@@ -129,24 +156,107 @@ func (filetype *FileTypeNode) AddChild(name string) *FileTypeNode {
 	return child
 }
 
+// ConflictStrategy returns the conflict resolution strategy of the category, inherited from the
+// closest parent category that sets one. It is empty when none does.
+func (filetype *FileTypeNode) ConflictStrategy() string {
+	for node := filetype; node != nil; node = node.Parent {
+		if node.Conflict != "" {
+			return node.Conflict
+		}
+	}
+	return ""
+}
+
 // AddExtensions adds file extensions to the current node
 func (filetype *FileTypeNode) AddExtensions(extensions []string) {
 	filetype.Extensions = append(filetype.Extensions, extensions...)
 }
 
-// PopulateFileTypes builds the file type tree based on a set of rules
-// Example input: map[string][]string{"Docs/Reports": {".docx", ".pdf"}, "Photos": {".jpg", ".png"}}
+// AddEntries parses `file_types` entries and attaches them as extensions, name patterns or metadata predicates.
+// Invalid patterns are logged and skipped so a single typo doesn't disable the whole config.
+func (filetype *FileTypeNode) AddEntries(entries []string) {
+	for _, entry := range entries {
+		parsed, err := ParseFileTypeEntry(entry)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Skipping entry for %s: %v", filetype.Name, err))
+			continue
+		}
+
+		switch {
+		case parsed.Fallback:
+			filetype.Fallback = true
+		case parsed.Priority != nil:
+			filetype.Priority = *parsed.Priority
+		case parsed.Conflict != "":
+			filetype.Conflict = parsed.Conflict
+		case parsed.Pattern != nil:
+			filetype.Patterns = append(filetype.Patterns, parsed.Pattern)
+		case parsed.Predicate != nil:
+			filetype.Predicates = append(filetype.Predicates, parsed.Predicate)
+		case parsed.MimeType != "":
+			filetype.MimeTypes = append(filetype.MimeTypes, parsed.MimeType)
+		default:
+			filetype.Extensions = append(filetype.Extensions, parsed.Extension)
+		}
+	}
+}
+
+// PopulateFileTypes builds the file type tree based on a set of rules.
+// Map iteration order is random, so categories are ordered by path for determinism;
+// use PopulateOrderedFileTypes to keep the order of the config file.
+// Example input: map[string][]string{"Docs/Reports": {".docx", ".pdf"}, "Pics/Screenshots": {"glob:Screenshot*.png"}}
 func (tree *FileTypeTree) PopulateFileTypes(fileTypeRules map[string][]string) {
-	for path, extensions := range fileTypeRules {
-		tree.addDirectPath(path, extensions)
-		slog.Debug(fmt.Sprintf("Added path: %s with extensions: %v", path, extensions))
+	tree.PopulateOrderedFileTypes(nil, fileTypeRules)
+}
+
+// PopulateOrderedFileTypes builds the file type tree, recording each category's position in `order`.
+// Categories missing from `order` are appended after it, sorted by path.
+func (tree *FileTypeTree) PopulateOrderedFileTypes(order []string, fileTypeRules map[string][]string) {
+	for i, path := range OrderFileTypes(order, fileTypeRules) {
+		tree.addDirectPath(path, fileTypeRules[path], i)
+		slog.Debug(fmt.Sprintf("Added path: %s with entries: %v", path, fileTypeRules[path]))
 	}
 }
 
-// addDirectPath creates a final node in FileTypeTree with the given path and associates extensions with it.
-func (tree *FileTypeTree) addDirectPath(path string, extensions []string) {
-	// Split the path into directories, keeping it as a single direct path
-	dirs := strings.Split(path, "/")
+// OrderFileTypes returns the category paths of `fileTypeRules` following `order`, then the remaining paths sorted.
+func OrderFileTypes(order []string, fileTypeRules map[string][]string) []string {
+	ordered := make([]string, 0, len(fileTypeRules))
+	seen := make(map[string]bool, len(fileTypeRules))
+
+	for _, path := range order {
+		if _, ok := fileTypeRules[path]; ok && !seen[path] {
+			ordered = append(ordered, path)
+			seen[path] = true
+		}
+	}
+
+	var remaining []string
+	for path := range fileTypeRules {
+		if !seen[path] {
+			remaining = append(remaining, path)
+		}
+	}
+	sort.Strings(remaining)
+
+	return append(ordered, remaining...)
+}
+
+// addDirectPath creates a final node in FileTypeTree with the given path and associates its entries with it.
+func (tree *FileTypeTree) addDirectPath(path string, entries []string, order int) {
+	// Split the path into directories, keeping it as a single direct path and template actions intact
+	dirs := SplitCategoryPath(path)
+
+	// Templated paths are rendered per file, compile them once here, before any node exists. A
+	// category whose template does not parse is skipped rather than creating literal "{{" folders.
+	var tmpl *template.Template
+	if IsTemplatePath(path) {
+		var err error
+		if tmpl, err = CompileDestination(strings.Join(filterEmpty(dirs), "/")); err != nil {
+			slog.Error(fmt.Sprintf("Skipping category %s: %v", path, err))
+			return
+		}
+	}
+
 	current := tree.Root
 
 	// Traverse or create each level until the final directory in the path
@@ -175,6 +285,20 @@ func (tree *FileTypeTree) addDirectPath(path string, extensions []string) {
 		current = next
 	}
 
-	// Attach extensions at the last directory level
-	current.AddExtensions(extensions)
+	// Attach the template, extensions and name patterns at the last directory level
+	if tmpl != nil {
+		current.Template = tmpl
+	}
+	current.Order = order
+	current.AddEntries(entries)
+}
+
+func filterEmpty(segments []string) []string {
+	filtered := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		if segment != "" {
+			filtered = append(filtered, segment)
+		}
+	}
+	return filtered
 }
@@ -0,0 +1,259 @@
+package trees
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFile(name string) *FileNode {
+	return &FileNode{
+		Path:      filepath.Join("/tmp", name),
+		Name:      name,
+		Extension: FileExtension(name),
+		Metadata:  Metadata{ModifiedAt: now()},
+	}
+}
+
+func resolvedPath(tree *FileTypeTree, name string) string {
+	match, found := tree.Resolve(newTestFile(name))
+	if !found {
+		return ""
+	}
+
+	var segments []string
+	for current := match.Node; current != nil && !current.IsRoot(); current = current.Parent {
+		segments = append([]string{current.Name}, segments...)
+	}
+	return filepath.Join(segments...)
+}
+
+func TestResolveNamePatterns(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateFileTypes(map[string][]string{
+		"Pics":             {".png", ".jpg"},
+		"Pics/Screenshots": {"glob:Screenshot*.png"},
+		"Invoices":         {"regex:^invoice-\\d+\\.pdf$"},
+		"PDFS":             {".pdf"},
+	})
+
+	assert.Equal(t, filepath.Join("Pics", "Screenshots"), resolvedPath(tree, "Screenshot 2024-01-01.png"))
+	assert.Equal(t, "Pics", resolvedPath(tree, "holiday.png"))
+	assert.Equal(t, "Invoices", resolvedPath(tree, "invoice-0042.pdf"))
+	assert.Equal(t, "PDFS", resolvedPath(tree, "invoice-draft.pdf"))
+	assert.Equal(t, "", resolvedPath(tree, "notes.md"))
+}
+
+func TestResolveMetadataPredicates(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	tree := NewFileTypeTree()
+	tree.PopulateFileTypes(map[string][]string{
+		"Vids":               {".mp4", ".mkv"},
+		"Archive/BigVideos":  {".mp4", ".mkv", "size>2GB"},
+		"Installers":         {".deb"},
+		"Installers/Expired": {".deb", "age>14d"},
+	})
+
+	bigVideo := newTestFile("movie.mkv")
+	bigVideo.Metadata.Size = 3 << 30
+	match, found := tree.Resolve(bigVideo)
+	assert.True(t, found)
+	assert.Equal(t, "BigVideos", match.Node.Name)
+	assert.Equal(t, []string{"size>2GB"}, match.Predicates)
+
+	smallVideo := newTestFile("clip.mp4")
+	smallVideo.Metadata.Size = 10 << 20
+	match, _ = tree.Resolve(smallVideo)
+	assert.Equal(t, "Vids", match.Node.Name)
+
+	oldInstaller := newTestFile("tool.deb")
+	oldInstaller.Metadata.ModifiedAt = now().AddDate(0, 0, -30)
+	match, _ = tree.Resolve(oldInstaller)
+	assert.Equal(t, "Expired", match.Node.Name)
+
+	freshInstaller := newTestFile("tool.deb")
+	freshInstaller.Metadata.ModifiedAt = now().AddDate(0, 0, -1)
+	match, _ = tree.Resolve(freshInstaller)
+	assert.Equal(t, "Installers", match.Node.Name)
+
+	// Without metadata, as in names only mode, predicates never hold
+	unstated := &FileNode{Path: "/tmp/movie.mkv", Name: "movie.mkv", Extension: ".mkv"}
+	match, _ = tree.Resolve(unstated)
+	assert.Equal(t, "Vids", match.Node.Name)
+}
+
+func TestParseFileTypeEntry(t *testing.T) {
+	parsed, err := ParseFileTypeEntry(".png")
+	assert.NoError(t, err)
+	assert.Equal(t, ".png", parsed.Extension)
+	assert.Nil(t, parsed.Pattern)
+
+	parsed, err = ParseFileTypeEntry("*.tmp")
+	assert.NoError(t, err)
+	assert.Equal(t, GlobPattern, parsed.Pattern.Kind)
+
+	parsed, err = ParseFileTypeEntry("perm&0111")
+	assert.NoError(t, err)
+	assert.True(t, parsed.Predicate.Eval(Metadata{Permissions: 0755}))
+	assert.False(t, parsed.Predicate.Eval(Metadata{Permissions: 0644}))
+
+	_, err = ParseFileTypeEntry("regex:([")
+	assert.Error(t, err)
+
+	_, err = ParseFileTypeEntry("size>2XB")
+	assert.Error(t, err)
+
+	parsed, err = ParseFileTypeEntry("conflict=newer-wins")
+	assert.NoError(t, err)
+	assert.Equal(t, "newer-wins", parsed.Conflict)
+
+	_, err = ParseFileTypeEntry("conflict=newest-wins")
+	assert.ErrorIs(t, err, ErrUnknownConflict)
+
+	_, err = ParseFileTypeEntry("conflict=Newer_Wins")
+	assert.ErrorIs(t, err, ErrUnknownConflict)
+}
+
+func TestConflictStrategy(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateOrderedFileTypes([]string{"Docs", "Docs/Reports", "Pics"}, map[string][]string{
+		"Docs":         {".pdf", "conflict=dedupe"},
+		"Docs/Reports": {"glob:report-*"},
+		"Pics":         {".png"},
+	})
+
+	match, _ := tree.Resolve(newTestFile("report-q1.pdf"))
+	assert.Equal(t, "Docs/Reports", match.Node.Path())
+	assert.Equal(t, "dedupe", match.Node.ConflictStrategy())
+
+	match, _ = tree.Resolve(newTestFile("cat.png"))
+	assert.Equal(t, "", match.Node.ConflictStrategy())
+}
+
+func TestRenderDestination(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateFileTypes(map[string][]string{
+		`Pics/{{.ModifiedAt.Year}}/{{printf "%02d" .ModifiedAt.Month}}`: {".jpg"},
+		"Docs/{{.Extension}}": {".pdf", ".docx"},
+		"Escape/{{.Name}}":    {".txt"},
+	})
+
+	photo := newTestFile("beach.jpg")
+	photo.Metadata.ModifiedAt = time.Date(2023, 7, 14, 0, 0, 0, 0, time.UTC)
+	match, found := tree.Resolve(photo)
+	assert.True(t, found)
+	rendered, err := match.Node.RenderDestination(photo)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("Pics", "2023", "07"), rendered)
+
+	doc := newTestFile("Report.PDF")
+	doc.Extension = ".pdf"
+	match, _ = tree.Resolve(doc)
+	rendered, err = match.Node.RenderDestination(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("Docs", "pdf"), rendered)
+
+	escape := newTestFile("../../etc.txt")
+	escape.Extension = ".txt"
+	match, _ = tree.Resolve(escape)
+	_, err = match.Node.RenderDestination(escape)
+	assert.Error(t, err)
+}
+
+func TestInvalidTemplateCreatesNoNodes(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateFileTypes(map[string][]string{
+		"Broken/{{.Nope": {".jpg"},
+	})
+
+	assert.Empty(t, tree.Root.Children)
+	_, found := tree.Resolve(newTestFile("beach.jpg"))
+	assert.False(t, found)
+}
+
+func TestSplitCategoryPath(t *testing.T) {
+	assert.Equal(t, []string{"Pics", `{{.ModifiedAt.Format "2006/01"}}`}, SplitCategoryPath(`Pics/{{.ModifiedAt.Format "2006/01"}}`))
+	assert.Equal(t, []string{"Docs", "Reports"}, SplitCategoryPath("Docs/Reports"))
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	rules := map[string][]string{
+		"Docs":      {".pdf"},
+		"PDFS":      {".pdf"},
+		"Invoices":  {"glob:invoice-*.pdf"},
+		"Important": {"glob:invoice-*.pdf", "priority=5"},
+	}
+
+	// Equal rules fall back to config order, whatever the map iteration order
+	for i := 0; i < 10; i++ {
+		tree := NewFileTypeTree()
+		tree.PopulateOrderedFileTypes([]string{"PDFS", "Docs", "Invoices", "Important"}, rules)
+		assert.Equal(t, "PDFS", resolvedPath(tree, "manual.pdf"))
+		assert.Equal(t, "Important", resolvedPath(tree, "invoice-1.pdf"))
+	}
+}
+
+func TestValidateOverlaps(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateOrderedFileTypes([]string{"Pics", "Pics/Screenshots", "Images", "Notes"}, map[string][]string{
+		"Pics":             {".png", ".jpg"},
+		"Pics/Screenshots": {"glob:Screenshot*.png"},
+		"Images":           {".jpg", "size>1MB"},
+		"Notes":            {".md"},
+	})
+
+	overlaps := tree.Validate()
+	assert.Len(t, overlaps, 2)
+
+	assert.Equal(t, "glob:Screenshot*.png", overlaps[0].Rule)
+	assert.Equal(t, "Pics/Screenshots", overlaps[0].Winner)
+	assert.Equal(t, "specificity", overlaps[0].Reason)
+
+	assert.Equal(t, ".jpg", overlaps[1].Rule)
+	assert.Equal(t, "Images", overlaps[1].Winner)
+	assert.True(t, overlaps[1].Conditional)
+}
+
+func TestApplyFallback(t *testing.T) {
+	tree := NewFileTypeTree()
+	tree.PopulateOrderedFileTypes([]string{"Docs", "Docs/Reports", "Docs/Misc", "Other"}, map[string][]string{
+		"Docs":         {".pdf", ".docx"},
+		"Docs/Reports": {"glob:report-*"},
+		"Docs/Misc":    {"fallback"},
+		"Pics":         {".png"},
+		"Other":        {"fallback"},
+	})
+
+	resolve := func(name string) (RuleMatch, bool) {
+		match, found := tree.Resolve(newTestFile(name))
+		return tree.ApplyFallback(match, found)
+	}
+
+	match, found := resolve("report-q1.pdf")
+	assert.True(t, found)
+	assert.Equal(t, "Docs/Reports", match.Node.Path())
+	assert.False(t, match.Fallback)
+
+	match, _ = resolve("letter.docx")
+	assert.Equal(t, "Docs/Misc", match.Node.Path())
+	assert.Equal(t, ".docx", match.Rule)
+	assert.True(t, match.Fallback)
+
+	match, _ = resolve("cat.png")
+	assert.Equal(t, "Pics", match.Node.Path())
+
+	match, found = resolve("mystery.xyz")
+	assert.True(t, found)
+	assert.Equal(t, "Other", match.Node.Path())
+	assert.True(t, match.Fallback)
+
+	// Without a top level fallback, unmatched files stay unmatched
+	plain := NewFileTypeTree()
+	plain.PopulateFileTypes(map[string][]string{"Pics": {".png"}})
+	_, found = plain.ApplyFallback(plain.Resolve(newTestFile("mystery.xyz")))
+	assert.False(t, found)
+}
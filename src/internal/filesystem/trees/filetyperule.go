@@ -0,0 +1,286 @@
+package trees
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type PatternKind int
+
+const (
+	GlobPattern PatternKind = iota
+	RegexPattern
+)
+
+const (
+	globPrefix  = "glob:"
+	regexPrefix = "regex:"
+	rePrefix    = "re:"
+)
+
+// Specificity weights used to rank competing matches. A name pattern always
+// outranks a plain extension; within a kind, longer literals win.
+const (
+	extensionSpecificity = 100
+	patternSpecificity   = 1000
+)
+
+// NamePattern is a glob or regular expression matched against a file name.
+type NamePattern struct {
+	Kind PatternKind
+	Expr string
+	re   *regexp.Regexp
+}
+
+// RuleMatch describes why a FileTypeNode accepted a file.
+type RuleMatch struct {
+	Node        *FileTypeNode
+	Rule        string   // The config entry that matched, e.g. ".png" or "glob:Screenshot*.png"
+	Predicates  []string // Metadata predicates that held, e.g. "size>2GB"
+	Specificity int
+	Fallback    bool // Set when the node is a fallback category the file was routed to
+}
+
+// NewNamePattern compiles a glob or regex pattern.
+func NewNamePattern(kind PatternKind, expr string) (*NamePattern, error) {
+	pattern := &NamePattern{Kind: kind, Expr: expr}
+
+	switch kind {
+	case GlobPattern:
+		// Validate the glob up front, filepath.Match only reports errors lazily
+		if _, err := filepath.Match(strings.ToLower(expr), ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", expr, err)
+		}
+	case RegexPattern:
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %w", expr, err)
+		}
+		pattern.re = re
+	}
+
+	return pattern, nil
+}
+
+// Match reports whether the pattern matches the given file name.
+// Globs are matched case-insensitively, like extensions; regexes are matched as written.
+func (p *NamePattern) Match(name string) bool {
+	switch p.Kind {
+	case GlobPattern:
+		matched, _ := filepath.Match(strings.ToLower(p.Expr), strings.ToLower(name))
+		return matched
+	case RegexPattern:
+		return p.re.MatchString(name)
+	}
+	return false
+}
+
+// Specificity ranks the pattern against other matches; the more literal characters, the more specific.
+func (p *NamePattern) Specificity() int {
+	meta := "*?[]\\"
+	if p.Kind == RegexPattern {
+		meta = ".^$*+?()[]{}|\\"
+	}
+
+	literals := 0
+	for _, r := range p.Expr {
+		if !strings.ContainsRune(meta, r) {
+			literals++
+		}
+	}
+	return patternSpecificity + literals
+}
+
+func (p *NamePattern) String() string {
+	if p.Kind == RegexPattern {
+		return regexPrefix + p.Expr
+	}
+	return globPrefix + p.Expr
+}
+
+var priorityExpr = regexp.MustCompile(`^priority\s*[=:]\s*(-?\d+)$`)
+
+var conflictExpr = regexp.MustCompile(`^conflict\s*[=:]\s*(\S*)$`)
+
+// ConflictStrategies are the strategies a `conflict=` entry accepts, the conflict resolutions of the organizer.
+var ConflictStrategies = []string{"rename", "skip", "overwrite", "dedupe", "newer-wins", "larger-wins", "keep-both-timestamp", "ask"}
+
+// ErrUnknownConflict marks a `conflict=` entry naming no known strategy. Unlike the other invalid
+// entries it rejects the config, the category would otherwise resolve its conflicts another way.
+var ErrUnknownConflict = errors.New("unknown conflict strategy")
+
+// FileTypeEntry is a parsed `file_types` entry; exactly one of its fields is set.
+type FileTypeEntry struct {
+	Extension string
+	Pattern   *NamePattern
+	Predicate *MetadataPredicate
+	MimeType  string
+	Priority  *int
+	Fallback  bool
+	Conflict  string
+}
+
+// ParseFileTypeEntry parses a single `file_types` entry. Entries prefixed with
+// `glob:` or `regex:` (or `re:`) become name patterns, bare entries containing glob
+// meta characters are treated as globs, metadata conditions such as `size>2GB`
+// become predicates, `mime:image/*` matches the sniffed content type, `priority=N` sets the
+// category precedence, `fallback` marks a catch-all category, `conflict=<strategy>` sets how the
+// category resolves name conflicts, and anything else is an extension (compound ones like ".tar.gz" included).
+func ParseFileTypeEntry(entry string) (FileTypeEntry, error) {
+	var parsed FileTypeEntry
+	var err error
+
+	switch {
+	case entry == fallbackEntry:
+		parsed.Fallback = true
+	case conflictExpr.MatchString(entry):
+		parsed.Conflict = conflictExpr.FindStringSubmatch(entry)[1]
+		if !slices.Contains(ConflictStrategies, parsed.Conflict) {
+			err = fmt.Errorf("%w %q, expected %s", ErrUnknownConflict, parsed.Conflict, strings.Join(ConflictStrategies, ", "))
+		}
+	case priorityExpr.MatchString(entry):
+		priority, convErr := strconv.Atoi(priorityExpr.FindStringSubmatch(entry)[1])
+		parsed.Priority, err = &priority, convErr
+	case strings.HasPrefix(entry, mimePrefix):
+		parsed.MimeType = strings.ToLower(strings.TrimPrefix(entry, mimePrefix))
+		if _, err = path.Match(parsed.MimeType, ""); err != nil || parsed.MimeType == "" {
+			err = fmt.Errorf("invalid MIME pattern %q", entry)
+		}
+	case strings.HasPrefix(entry, globPrefix):
+		parsed.Pattern, err = NewNamePattern(GlobPattern, strings.TrimPrefix(entry, globPrefix))
+	case strings.HasPrefix(entry, regexPrefix):
+		parsed.Pattern, err = NewNamePattern(RegexPattern, strings.TrimPrefix(entry, regexPrefix))
+	case strings.HasPrefix(entry, rePrefix):
+		parsed.Pattern, err = NewNamePattern(RegexPattern, strings.TrimPrefix(entry, rePrefix))
+	case IsPredicateEntry(entry):
+		parsed.Predicate, err = NewMetadataPredicate(entry)
+	case strings.ContainsAny(entry, "*?["):
+		parsed.Pattern, err = NewNamePattern(GlobPattern, entry)
+	default:
+		parsed.Extension = entry
+	}
+	return parsed, err
+}
+
+// Match checks the node's own rules against a file and returns the most specific hit.
+// Extensions and name patterns are alternatives, any of them may match; metadata
+// predicates are conditions, all of them must hold. A node with only predicates
+// matches every file satisfying them.
+func (n *FileTypeNode) Match(file *FileNode) (RuleMatch, bool) {
+	best := RuleMatch{Node: n}
+	found := false
+
+	for _, pattern := range n.Patterns {
+		if pattern.Match(file.Name) && pattern.Specificity() > best.Specificity {
+			best.Rule = pattern.String()
+			best.Specificity = pattern.Specificity()
+			found = true
+		}
+	}
+
+	if !found {
+		// Longer extensions are more specific, ".tar.gz" beats ".gz"
+		for _, ext := range n.Extensions {
+			if matchesExtension(ext, file) && extensionSpecificity+len(ext) > best.Specificity {
+				best.Rule = ext
+				best.Specificity = extensionSpecificity + len(ext)
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		// "image/png" is more specific than "image/*"
+		for _, mimeType := range n.MimeTypes {
+			specificity := mimeSpecificity + len(strings.TrimSuffix(mimeType, "*"))
+			if MatchMIME(mimeType, file.MimeType) && specificity > best.Specificity {
+				best.Rule = mimePrefix + mimeType
+				best.Specificity = specificity
+				found = true
+			}
+		}
+	}
+
+	if !found && (len(n.Extensions) > 0 || len(n.Patterns) > 0 || len(n.MimeTypes) > 0 || len(n.Predicates) == 0) {
+		return RuleMatch{}, false
+	}
+
+	// Predicates cannot hold for a file without metadata, e.g. in names only mode
+	if len(n.Predicates) > 0 && !file.HasMetadata() {
+		return RuleMatch{}, false
+	}
+
+	for _, predicate := range n.Predicates {
+		if !predicate.Eval(file.Metadata) {
+			return RuleMatch{}, false
+		}
+		best.Predicates = append(best.Predicates, predicate.String())
+		best.Specificity += predicateSpecificity
+	}
+
+	return best, true
+}
+
+// Candidates collects every node in the tree whose rules match the file, in depth-first order.
+func (tree *FileTypeTree) Candidates(file *FileNode) []RuleMatch {
+	var matches []RuleMatch
+	collectCandidates(tree.Root, file, &matches)
+	return matches
+}
+
+func collectCandidates(node *FileTypeNode, file *FileNode, matches *[]RuleMatch) {
+	if match, ok := node.Match(file); ok {
+		*matches = append(*matches, match)
+	}
+
+	for _, child := range node.Children {
+		collectCandidates(child, file, matches)
+	}
+}
+
+// Resolve picks the winning node for the file. Precedence is, in order: explicit priority,
+// specificity of the matched rule, depth of the category, and position in the config file.
+// The outcome never depends on map or traversal order.
+func (tree *FileTypeTree) Resolve(file *FileNode) (RuleMatch, bool) {
+	candidates := tree.Candidates(file)
+	if len(candidates) == 0 {
+		return RuleMatch{}, false
+	}
+
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.Outranks(best) {
+			best = candidate
+		}
+	}
+	return best, true
+}
+
+// Outranks reports whether the match takes precedence over another match for the same file.
+func (m RuleMatch) Outranks(other RuleMatch) bool {
+	if m.Node.Priority != other.Node.Priority {
+		return m.Node.Priority > other.Node.Priority
+	}
+	if m.Specificity != other.Specificity {
+		return m.Specificity > other.Specificity
+	}
+	if m.Node.Depth() != other.Node.Depth() {
+		return m.Node.Depth() > other.Node.Depth()
+	}
+	return m.Node.Order < other.Node.Order
+}
+
+// Depth returns the number of ancestors between the node and the root.
+func (n *FileTypeNode) Depth() int {
+	depth := 0
+	for current := n.Parent; current != nil; current = current.Parent {
+		depth++
+	}
+	return depth
+}
@@ -0,0 +1,182 @@
+package trees
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// ContentHash fingerprints the content of a file. XXH64 is always set, SHA256 only when it was asked
+// for: the fast hash tells files apart, the checksum proves two files are the same.
+type ContentHash struct {
+	XXH64  uint64 // xxHash64 of the content
+	SHA256 []byte // SHA-256 of the content, nil when not computed
+}
+
+// Equal compares two hashes, by SHA-256 when both have it.
+func (h ContentHash) Equal(other ContentHash) bool {
+	if h.SHA256 != nil && other.SHA256 != nil {
+		return bytes.Equal(h.SHA256, other.SHA256)
+	}
+	return h.XXH64 == other.XXH64
+}
+
+// covers reports whether the hash has everything a request for SHA-256 or not needs.
+func (h ContentHash) covers(withSHA256 bool) bool {
+	return !withSHA256 || h.SHA256 != nil
+}
+
+// HashKey identifies a version of a file: as long as a file keeps its device, inode, size and
+// modification time, its content is taken to be unchanged, the way make and rsync do.
+type HashKey struct {
+	Device  uint64
+	Inode   uint64
+	Size    int64
+	ModTime time.Time
+}
+
+// HashCache keeps content hashes across runs. Implementations must be safe for concurrent use.
+type HashCache interface {
+	LookupHash(key HashKey) (ContentHash, bool, error)
+	StoreHash(key HashKey, hash ContentHash) error
+}
+
+// Hasher computes the content hashes of files, reusing the hashes of a HashCache for the files
+// unchanged since they were hashed. It is safe for concurrent use.
+type Hasher struct {
+	cache HashCache
+}
+
+// NewHasher returns a Hasher backed by cache, or hashing every file when cache is nil.
+func NewHasher(cache HashCache) *Hasher {
+	return &Hasher{cache: cache}
+}
+
+// Hash returns the content hash of a file, computing it on first use and keeping it in the file
+// metadata. withSHA256 also asks for the SHA-256 checksum.
+func (h *Hasher) Hash(file *FileNode, withSHA256 bool) (ContentHash, error) {
+	if file.Metadata.Hash != nil && file.Metadata.Hash.covers(withSHA256) {
+		return *file.Metadata.Hash, nil
+	}
+
+	sum, err := h.HashPath(file.Path, withSHA256)
+	if err != nil {
+		return ContentHash{}, err
+	}
+	file.Metadata.Hash = &sum
+	return sum, nil
+}
+
+// HashPath returns the content hash of the file at path, see Hash.
+func (h *Hasher) HashPath(path string, withSHA256 bool) (ContentHash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ContentHash{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ContentHash{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return ContentHash{}, fmt.Errorf("cannot hash %s, not a regular file", path)
+	}
+
+	key, cacheable := hashKey(info)
+	cacheable = cacheable && h.cache != nil
+	if cacheable {
+		cached, found, err := h.cache.LookupHash(key)
+		if err != nil {
+			return ContentHash{}, err
+		}
+		if found && cached.covers(withSHA256) {
+			return cached, nil
+		}
+	}
+
+	sum, err := hashContent(file, withSHA256)
+	if err != nil {
+		return ContentHash{}, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	// A file written to while it was read has no hash worth keeping
+	if after, err := file.Stat(); err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return sum, nil
+	}
+	if cacheable {
+		if err := h.cache.StoreHash(key, sum); err != nil {
+			return ContentHash{}, err
+		}
+	}
+	return sum, nil
+}
+
+// PartialHashSize is how many bytes PartialHash reads at each end of a file. A file up to twice
+// that size is read whole, its partial hash tells no more than a full one.
+const PartialHashSize = 16 << 10
+
+// PartialHash hashes the first and last PartialHashSize bytes of a file, a cheap way to tell apart
+// files of the same size before hashing them whole. Partial hashes are not cached.
+func (h *Hasher) PartialHash(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	fast := xxhash.New()
+	if _, err := io.CopyN(fast, file, PartialHashSize); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	if info.Size() > PartialHashSize {
+		// The tail starts after the head, they only meet in files under twice PartialHashSize
+		offset := max(PartialHashSize, info.Size()-PartialHashSize)
+		if _, err := io.Copy(fast, io.NewSectionReader(file, offset, info.Size()-offset)); err != nil {
+			return 0, fmt.Errorf("failed to hash %s: %w", path, err)
+		}
+	}
+	return fast.Sum64(), nil
+}
+
+// hashKey returns the cache key of a file. Without device and inode numbers, a key could match
+// another file, so those files are not cached.
+func hashKey(info os.FileInfo) (HashKey, bool) {
+	dev, ino, ok := FileIdentity(info)
+	if !ok {
+		return HashKey{}, false
+	}
+	return HashKey{Device: dev, Inode: ino, Size: info.Size(), ModTime: info.ModTime()}, true
+}
+
+// hashContent reads r once, computing the requested hashes side by side.
+func hashContent(r io.Reader, withSHA256 bool) (ContentHash, error) {
+	fast := xxhash.New()
+	var checksum hash.Hash
+	var w io.Writer = fast
+	if withSHA256 {
+		checksum = sha256.New()
+		w = io.MultiWriter(fast, checksum)
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return ContentHash{}, err
+	}
+
+	sum := ContentHash{XXH64: fast.Sum64()}
+	if checksum != nil {
+		sum.SHA256 = checksum.Sum(nil)
+	}
+	return sum, nil
+}
@@ -0,0 +1,157 @@
+package trees
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryHashCache is a HashCache counting its lookups and stores.
+type memoryHashCache struct {
+	mu      sync.Mutex
+	hashes  map[HashKey]ContentHash
+	lookups int
+	stores  int
+}
+
+func (c *memoryHashCache) LookupHash(key HashKey) (ContentHash, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lookups++
+	hash, ok := c.hashes[key]
+	return hash, ok, nil
+}
+
+func (c *memoryHashCache) StoreHash(key HashKey, hash ContentHash) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stores++
+	c.hashes[key] = hash
+	return nil
+}
+
+func TestHasherHashesLazily(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+	file := &FileNode{Path: path}
+
+	hasher := NewHasher(nil)
+	assert.Nil(t, file.Metadata.Hash)
+
+	sum, err := hasher.Hash(file, false)
+	assert.NoError(t, err)
+	assert.NotZero(t, sum.XXH64)
+	assert.Nil(t, sum.SHA256)
+	assert.Equal(t, &sum, file.Metadata.Hash)
+
+	// Asking for the checksum hashes again, the fast hash is the same
+	withChecksum, err := hasher.Hash(file, true)
+	assert.NoError(t, err)
+	expected := sha256.Sum256([]byte("hello"))
+	assert.Equal(t, expected[:], withChecksum.SHA256)
+	assert.Equal(t, sum.XXH64, withChecksum.XXH64)
+
+	// The file node keeps the hash, even once the file is gone
+	assert.NoError(t, os.Remove(path))
+	again, err := hasher.Hash(file, false)
+	assert.NoError(t, err)
+	assert.Equal(t, withChecksum, again)
+}
+
+func TestHasherReusesCachedHashes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.jpg")
+	assert.NoError(t, os.WriteFile(path, []byte("first"), 0644))
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	cache := &memoryHashCache{hashes: make(map[HashKey]ContentHash)}
+	hasher := NewHasher(cache)
+
+	first, err := hasher.HashPath(path, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cache.stores)
+
+	// Same size and modification time: the cache answers, the content is not read
+	assert.NoError(t, os.WriteFile(path, []byte("other"), 0644))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	cached, err := hasher.HashPath(path, false)
+	assert.NoError(t, err)
+	assert.Equal(t, first, cached)
+	assert.Equal(t, 1, cache.stores)
+
+	// A cached hash without the checksum is computed again when the checksum is asked for
+	withChecksum, err := hasher.HashPath(path, true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.XXH64, withChecksum.XXH64)
+	assert.NotNil(t, withChecksum.SHA256)
+	assert.Equal(t, 2, cache.stores)
+
+	// A new modification time is a new version of the file
+	later := modTime.Add(time.Hour)
+	assert.NoError(t, os.Chtimes(path, later, later))
+	_, err = hasher.HashPath(path, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cache.stores)
+
+	_, err = hasher.HashPath(dir, false)
+	assert.Error(t, err)
+}
+
+func TestContentHashEqual(t *testing.T) {
+	fast := ContentHash{XXH64: 42}
+	assert.True(t, fast.Equal(ContentHash{XXH64: 42, SHA256: []byte{1}}))
+	assert.False(t, fast.Equal(ContentHash{XXH64: 7}))
+
+	// Checksums decide when both sides have one
+	assert.False(t, ContentHash{XXH64: 42, SHA256: []byte{1}}.Equal(ContentHash{XXH64: 42, SHA256: []byte{2}}))
+	assert.True(t, ContentHash{XXH64: 1, SHA256: []byte{3}}.Equal(ContentHash{XXH64: 1, SHA256: []byte{3}}))
+}
+
+func TestPartialHashReadsBothEnds(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 4*PartialHashSize)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+		return path
+	}
+	hasher := NewHasher(nil)
+	partial := func(path string) uint64 {
+		sum, err := hasher.PartialHash(path)
+		assert.NoError(t, err)
+		return sum
+	}
+
+	original := partial(write("original.iso", content))
+
+	// A change in the middle goes unnoticed, a change in the head or the tail does not
+	middle := bytes.Clone(content)
+	middle[2*PartialHashSize] ^= 0xff
+	assert.Equal(t, original, partial(write("middle.iso", middle)))
+
+	head := bytes.Clone(content)
+	head[0] ^= 0xff
+	assert.NotEqual(t, original, partial(write("head.iso", head)))
+
+	tail := bytes.Clone(content)
+	tail[len(tail)-1] ^= 0xff
+	assert.NotEqual(t, original, partial(write("tail.iso", tail)))
+
+	// A small file is hashed whole
+	small := content[:PartialHashSize+10]
+	assert.Equal(t, xxhash.Sum64(small), partial(write("small.txt", small)))
+
+	_, err := hasher.PartialHash(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
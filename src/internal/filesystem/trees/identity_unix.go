@@ -0,0 +1,17 @@
+//go:build !windows
+
+package trees
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileIdentity returns the device and inode of a file, which identify it whatever path reaches it.
+func FileIdentity(info os.FileInfo) (uint64, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), true
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package trees
+
+import "os"
+
+// FileIdentity is not available on Windows.
+func FileIdentity(info os.FileInfo) (uint64, uint64, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,305 @@
+package trees
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// PerceptualAlgorithm selects the perceptual hash images are compared by.
+type PerceptualAlgorithm string
+
+const (
+	PerceptualDHash PerceptualAlgorithm = "dhash" // Gradient between neighbouring pixels, fast, robust to resizing and re-encoding
+	PerceptualPHash PerceptualAlgorithm = "phash" // Low frequencies of the DCT, also robust to small edits, gamma and color changes
+)
+
+// ParsePerceptualAlgorithm validates a --algorithm value.
+func ParsePerceptualAlgorithm(value string) (PerceptualAlgorithm, error) {
+	switch algorithm := PerceptualAlgorithm(value); algorithm {
+	case PerceptualDHash, PerceptualPHash:
+		return algorithm, nil
+	case "":
+		return PerceptualPHash, nil
+	}
+	return "", fmt.Errorf("unknown perceptual hash %q, expected dhash or phash", value)
+}
+
+// PerceptualHash is a 64-bit fingerprint of how an image looks: the hashes of a resized or
+// re-encoded copy differ from the original's in a few bits only.
+type PerceptualHash uint64
+
+// Distance returns the Hamming distance between two hashes, the number of bits they differ in.
+func (h PerceptualHash) Distance(other PerceptualHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+func (h PerceptualHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// ImageHash holds the perceptual hashes of an image, and its size in pixels.
+type ImageHash struct {
+	DHash  PerceptualHash
+	PHash  PerceptualHash
+	Width  int
+	Height int
+}
+
+// Of returns the hash computed by algorithm.
+func (h ImageHash) Of(algorithm PerceptualAlgorithm) PerceptualHash {
+	if algorithm == PerceptualDHash {
+		return h.DHash
+	}
+	return h.PHash
+}
+
+// ImageHashCache keeps image hashes across runs. Implementations must be safe for concurrent use.
+type ImageHashCache interface {
+	LookupImageHash(key HashKey) (ImageHash, bool, error)
+	StoreImageHash(key HashKey, hash ImageHash) error
+}
+
+// ImageHasher computes the perceptual hashes of images, reusing the hashes of an ImageHashCache for
+// the files unchanged since they were hashed. It is safe for concurrent use.
+type ImageHasher struct {
+	cache ImageHashCache
+}
+
+// NewImageHasher returns an ImageHasher backed by cache, or decoding every image when cache is nil.
+func NewImageHasher(cache ImageHashCache) *ImageHasher {
+	return &ImageHasher{cache: cache}
+}
+
+// maxImagePixels bounds the images decoded, a decoded image takes a few bytes per pixel.
+const maxImagePixels = 100 << 20
+
+// imageExtensions are the formats the standard library decodes.
+var imageExtensions = []string{".gif", ".jpeg", ".jpg", ".png"}
+
+// IsHashableImage reports whether the name of a file says it is an image ImageHasher decodes.
+func IsHashableImage(path string) bool {
+	return slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// Hash returns the perceptual hashes of an image file, computing them on first use and keeping them
+// in the file metadata.
+func (h *ImageHasher) Hash(file *FileNode) (ImageHash, error) {
+	if file.Metadata.ImageHash != nil {
+		return *file.Metadata.ImageHash, nil
+	}
+
+	sum, err := h.HashPath(file.Path)
+	if err != nil {
+		return ImageHash{}, err
+	}
+	file.Metadata.ImageHash = &sum
+	return sum, nil
+}
+
+// HashPath returns the perceptual hashes of the image at path, see Hash.
+func (h *ImageHasher) HashPath(path string) (ImageHash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ImageHash{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ImageHash{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return ImageHash{}, fmt.Errorf("cannot hash %s, not a regular file", path)
+	}
+
+	key, cacheable := hashKey(info)
+	cacheable = cacheable && h.cache != nil
+	if cacheable {
+		cached, found, err := h.cache.LookupImageHash(key)
+		if err != nil {
+			return ImageHash{}, err
+		}
+		if found {
+			return cached, nil
+		}
+	}
+
+	// The header tells the size, an image too large to decode is refused before it is read
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return ImageHash{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return ImageHash{}, fmt.Errorf("cannot hash %s, %dx%d pixels is too large", path, config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ImageHash{}, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return ImageHash{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	sum := HashImage(img)
+
+	if after, err := file.Stat(); err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return sum, nil
+	}
+	if cacheable {
+		if err := h.cache.StoreImageHash(key, sum); err != nil {
+			return ImageHash{}, err
+		}
+	}
+	return sum, nil
+}
+
+// HashImage computes the perceptual hashes of a decoded image.
+func HashImage(img image.Image) ImageHash {
+	bounds := img.Bounds()
+	return ImageHash{
+		DHash:  differenceHash(img),
+		PHash:  dctHash(img),
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+}
+
+// differenceHash shrinks the image to 9x8 gray pixels and sets a bit for every pixel darker than its
+// right neighbour.
+func differenceHash(img image.Image) PerceptualHash {
+	const width, height = 9, 8
+	gray := luminance(img, width, height)
+
+	var hash PerceptualHash
+	for y := range height {
+		for x := range width - 1 {
+			if gray[y*width+x] < gray[y*width+x+1] {
+				hash |= 1 << (y*(width-1) + x)
+			}
+		}
+	}
+	return hash
+}
+
+// dctHash shrinks the image to 32x32 gray pixels and keeps the 8x8 lowest frequencies of their
+// discrete cosine transform, setting a bit for every frequency above their median.
+func dctHash(img image.Image) PerceptualHash {
+	const size, low = 32, 8
+	gray := luminance(img, size, size)
+
+	// The transform is separable, rows first, then the columns of the rows' low frequencies
+	var cosines [low][size]float64
+	for u := range low {
+		for x := range size {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	scale := func(u int) float64 {
+		if u == 0 {
+			return math.Sqrt(1.0 / size)
+		}
+		return math.Sqrt(2.0 / size)
+	}
+
+	var rows [size][low]float64
+	for y := range size {
+		for u := range low {
+			sum := 0.0
+			for x := range size {
+				sum += gray[y*size+x] * cosines[u][x]
+			}
+			rows[y][u] = sum * scale(u)
+		}
+	}
+	coefficients := make([]float64, 0, low*low)
+	for v := range low {
+		for u := range low {
+			sum := 0.0
+			for y := range size {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			coefficients = append(coefficients, sum*scale(v))
+		}
+	}
+
+	sorted := slices.Clone(coefficients)
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash PerceptualHash
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// luminance shrinks the image to width x height gray pixels, each the average luma of the area of the
+// image it covers, in row order.
+func luminance(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	luma := lumaFunc(img)
+	gray := make([]float64, width*height)
+	if bounds.Empty() {
+		return gray
+	}
+
+	// An image smaller than the target repeats its pixels
+	span := func(i, n, size int) (int, int) {
+		start := i * size / n
+		return start, max((i+1)*size/n, start+1)
+	}
+	for cy := range height {
+		y0, y1 := span(cy, height, bounds.Dy())
+		for cx := range width {
+			x0, x1 := span(cx, width, bounds.Dx())
+			sum := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += luma(bounds.Min.X+x, bounds.Min.Y+y)
+				}
+			}
+			gray[cy*width+cx] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return gray
+}
+
+// lumaFunc returns the luma of the pixels of img, from 0 to 255. JPEG images keep it as their Y
+// channel, the common formats are read from their pixel buffers rather than through At.
+func lumaFunc(img image.Image) func(x, y int) float64 {
+	rgb := func(r, g, b uint32) float64 {
+		return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+	}
+
+	switch img := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) float64 { return float64(img.Y[img.YOffset(x, y)]) }
+	case *image.Gray:
+		return func(x, y int) float64 { return float64(img.Pix[img.PixOffset(x, y)]) }
+	case *image.RGBA:
+		return func(x, y int) float64 {
+			pix := img.Pix[img.PixOffset(x, y):]
+			return rgb(uint32(pix[0]), uint32(pix[1]), uint32(pix[2]))
+		}
+	case *image.NRGBA:
+		return func(x, y int) float64 {
+			pix := img.Pix[img.PixOffset(x, y):]
+			return rgb(uint32(pix[0]), uint32(pix[1]), uint32(pix[2]))
+		}
+	}
+	return func(x, y int) float64 {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return rgb(r>>8, g>>8, b>>8)
+	}
+}
@@ -0,0 +1,148 @@
+package trees
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryImageHashCache is an ImageHashCache counting its stores.
+type memoryImageHashCache struct {
+	mu     sync.Mutex
+	hashes map[HashKey]ImageHash
+	stores int
+}
+
+func (c *memoryImageHashCache) LookupImageHash(key HashKey) (ImageHash, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.hashes[key]
+	return hash, ok, nil
+}
+
+func (c *memoryImageHashCache) StoreImageHash(key HashKey, hash ImageHash) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stores++
+	c.hashes[key] = hash
+	return nil
+}
+
+// landscape draws a photo-like picture: a sky gradient, a sun and hills.
+func landscape(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			c := color.RGBA{uint8(90 + 100*fy), uint8(140 + 60*fy), uint8(230 - 80*fx), 255}
+			if math.Hypot(fx-0.7, fy-0.25) < 0.12 {
+				c = color.RGBA{250, 220, 80, 255}
+			}
+			if fy > 0.6+0.1*math.Sin(fx*9) {
+				c = color.RGBA{40, uint8(120 - 60*fy), 50, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// checkerboard draws a picture with nothing in common with landscape.
+func checkerboard(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			if (x*5/width+y*3/height)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 230})
+			}
+		}
+	}
+	return img
+}
+
+// resize scales an image with nearest neighbour sampling, the crudest resize there is.
+func resize(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			resized.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+	return resized
+}
+
+func reencode(t *testing.T, img image.Image, quality int) image.Image {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}))
+	decoded, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+	return decoded
+}
+
+func TestHashImageMatchesCopies(t *testing.T) {
+	original := HashImage(landscape(640, 480))
+	assert.Equal(t, 640, original.Width)
+	assert.Equal(t, 480, original.Height)
+
+	copies := map[string]image.Image{
+		"resized":    resize(landscape(640, 480), 200, 150),
+		"re-encoded": reencode(t, landscape(640, 480), 40),
+		"both":       reencode(t, resize(landscape(640, 480), 320, 240), 60),
+	}
+	for name, img := range copies {
+		sum := HashImage(img)
+		for _, algorithm := range []PerceptualAlgorithm{PerceptualDHash, PerceptualPHash} {
+			assert.LessOrEqual(t, original.Of(algorithm).Distance(sum.Of(algorithm)), 6, "%s copy, %s", name, algorithm)
+		}
+	}
+
+	other := HashImage(checkerboard(640, 480))
+	for _, algorithm := range []PerceptualAlgorithm{PerceptualDHash, PerceptualPHash} {
+		assert.Greater(t, original.Of(algorithm).Distance(other.Of(algorithm)), 16, "different image, %s", algorithm)
+	}
+}
+
+func TestImageHasherReusesCachedHashes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.png")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(file, landscape(64, 48)))
+	assert.NoError(t, file.Close())
+
+	cache := &memoryImageHashCache{hashes: make(map[HashKey]ImageHash)}
+	hasher := NewImageHasher(cache)
+	node := &FileNode{Path: path}
+
+	sum, err := hasher.Hash(node)
+	assert.NoError(t, err)
+	assert.Equal(t, &sum, node.Metadata.ImageHash)
+	assert.Equal(t, 1, cache.stores)
+
+	again, err := hasher.HashPath(path)
+	assert.NoError(t, err)
+	assert.Equal(t, sum, again)
+	assert.Equal(t, 1, cache.stores)
+
+	notImage := filepath.Join(dir, "notes.png")
+	assert.NoError(t, os.WriteFile(notImage, []byte("not an image"), 0644))
+	_, err = hasher.HashPath(notImage)
+	assert.Error(t, err)
+}
+
+func TestIsHashableImage(t *testing.T) {
+	assert.True(t, IsHashableImage("/photos/IMG_0001.JPG"))
+	assert.True(t, IsHashableImage("scan.png"))
+	assert.False(t, IsHashableImage("vector.svg"))
+	assert.False(t, IsHashableImage("jpg"))
+}
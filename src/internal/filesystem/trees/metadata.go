@@ -9,13 +9,15 @@ import (
 
 // Metadata holds additional information for each node in the DirectoryTree
 type Metadata struct {
-	Size        int64       // Size of the file or directory
-	ModifiedAt  time.Time   // Last modified time
-	CreatedAt   time.Time   // Creation time (if available)
-	NodeType    string      // "file" or "directory"
-	Permissions os.FileMode // File permissions
-	Owner       string      // Owner of the file (if available)
-	Tags        []string    // Tags associated with the file or directory
+	Size        int64        // Size of the file or directory
+	ModifiedAt  time.Time    // Last modified time
+	CreatedAt   time.Time    // Creation time (if available)
+	NodeType    string       // "file" or "directory"
+	Permissions os.FileMode  // File permissions
+	Owner       string       // Owner of the file (if available)
+	Tags        []string     // Tags associated with the file or directory
+	Hash        *ContentHash // Content fingerprint, nil until a Hasher hashed the file
+	ImageHash   *ImageHash   // Perceptual fingerprint of an image, nil until an ImageHasher hashed the file
 }
 
 func NewMetadata(fileinfo os.FileInfo) Metadata {
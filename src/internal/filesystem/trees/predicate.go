@@ -0,0 +1,205 @@
+package trees
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now is swapped out in tests to make age predicates deterministic.
+var now = time.Now
+
+const (
+	tagPrefix = "tag:"
+
+	// Each satisfied predicate makes a match more specific than the same rule without it.
+	predicateSpecificity = 10
+)
+
+var predicateExpr = regexp.MustCompile(`^(size|age|modified|perm)\s*(>=|<=|==|!=|=|>|<|&)\s*(\S+)$`)
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// MetadataPredicate is a condition over a file's Metadata, e.g. `size>2GB` or `age>14d`.
+// All predicates of a category must hold for the category to match.
+type MetadataPredicate struct {
+	Field    string
+	Operator string
+	Value    string
+	eval     func(Metadata) bool
+}
+
+// IsPredicateEntry reports whether a `file_types` entry is a metadata predicate rather than an extension.
+func IsPredicateEntry(entry string) bool {
+	return strings.HasPrefix(entry, tagPrefix) || predicateExpr.MatchString(entry)
+}
+
+// NewMetadataPredicate parses a predicate entry. Supported forms:
+//
+//	size>2GB, size<=500KB       file size, binary units (B, KB, MB, GB, TB)
+//	age>14d, age<12h            time since last modification (s, m, h, d, w)
+//	modified>=2024-01-01        last modification date (YYYY-MM-DD)
+//	perm=0644, perm&0111        exact permission bits, or any of the given bits set
+//	tag:large                   tag present in Metadata.Tags
+func NewMetadataPredicate(entry string) (*MetadataPredicate, error) {
+	if strings.HasPrefix(entry, tagPrefix) {
+		tag := strings.TrimPrefix(entry, tagPrefix)
+		if tag == "" {
+			return nil, fmt.Errorf("empty tag predicate")
+		}
+		return &MetadataPredicate{Field: "tag", Operator: ":", Value: tag, eval: func(m Metadata) bool {
+			for _, t := range m.Tags {
+				if t == tag {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+
+	parts := predicateExpr.FindStringSubmatch(entry)
+	if parts == nil {
+		return nil, fmt.Errorf("invalid predicate %q", entry)
+	}
+
+	predicate := &MetadataPredicate{Field: parts[1], Operator: parts[2], Value: parts[3]}
+	if predicate.Operator == "==" {
+		predicate.Operator = "="
+	}
+
+	if predicate.Field != "perm" && predicate.Operator == "&" {
+		return nil, fmt.Errorf("operator & is only supported for perm, got %q", entry)
+	}
+
+	switch predicate.Field {
+	case "size":
+		size, err := parseSize(predicate.Value)
+		if err != nil {
+			return nil, err
+		}
+		predicate.eval = func(m Metadata) bool { return compareInt(m.Size, predicate.Operator, size) }
+	case "age":
+		age, err := ParseAge(predicate.Value)
+		if err != nil {
+			return nil, err
+		}
+		predicate.eval = func(m Metadata) bool {
+			return compareInt(int64(now().Sub(m.ModifiedAt)), predicate.Operator, int64(age))
+		}
+	case "modified":
+		date, err := time.ParseInLocation("2006-01-02", predicate.Value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date in %q: %w", entry, err)
+		}
+		predicate.eval = func(m Metadata) bool {
+			return compareInt(m.ModifiedAt.Unix(), predicate.Operator, date.Unix())
+		}
+	case "perm":
+		bits, err := strconv.ParseUint(predicate.Value, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission bits in %q: %w", entry, err)
+		}
+		predicate.eval = func(m Metadata) bool {
+			perm := int64(m.Permissions.Perm())
+			if predicate.Operator == "&" {
+				return perm&int64(bits) != 0
+			}
+			return compareInt(perm, predicate.Operator, int64(bits))
+		}
+	}
+
+	return predicate, nil
+}
+
+// Eval reports whether the metadata satisfies the predicate.
+func (p *MetadataPredicate) Eval(metadata Metadata) bool {
+	return p.eval(metadata)
+}
+
+func (p *MetadataPredicate) String() string {
+	if p.Field == "tag" {
+		return tagPrefix + p.Value
+	}
+	return p.Field + p.Operator + p.Value
+}
+
+func compareInt(actual int64, operator string, expected int64) bool {
+	switch operator {
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case "=":
+		return actual == expected
+	case "!=":
+		return actual != expected
+	}
+	return false
+}
+
+func parseSize(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	split := strings.IndexFunc(lower, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if split == -1 {
+		split = len(lower)
+	}
+
+	number, err := strconv.ParseFloat(lower[:split], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", value, err)
+	}
+
+	unit, ok := sizeUnits[lower[split:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit in %q", value)
+	}
+	return int64(number * float64(unit)), nil
+}
+
+// ParseAge parses a duration such as "90m", "36h", "14d" or "2w", days and weeks included.
+func ParseAge(value string) (time.Duration, error) {
+	if len(value) > 1 {
+		multiplier := time.Duration(0)
+		switch value[len(value)-1] {
+		case 'd':
+			multiplier = 24 * time.Hour
+		case 'w':
+			multiplier = 7 * 24 * time.Hour
+		}
+
+		if multiplier != 0 {
+			number, err := strconv.ParseFloat(value[:len(value)-1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q: %w", value, err)
+			}
+			return time.Duration(number * float64(multiplier)), nil
+		}
+	}
+
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q: %w", value, err)
+	}
+	return age, nil
+}
@@ -0,0 +1,173 @@
+package trees
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var regexExtension = regexp.MustCompile(`\\(\.[a-z0-9]+)\$?$`)
+
+// RuleOverlap reports two categories that can both claim the same files, and which one wins.
+type RuleOverlap struct {
+	Rule        string   `json:"rule"`        // The shared extension or pattern, "*" for predicate-only categories
+	Categories  []string `json:"categories"`  // The two overlapping category paths, in config order
+	Winner      string   `json:"winner"`      // Category that receives files matching both
+	Reason      string   `json:"reason"`      // Precedence step that decided: priority, specificity, depth or config order
+	Conditional bool     `json:"conditional"` // Set when predicates may keep the two categories apart
+}
+
+func (o RuleOverlap) String() string {
+	msg := fmt.Sprintf("%s and %s both match %s, %s wins by %s", o.Categories[0], o.Categories[1], o.Rule, o.Winner, o.Reason)
+	if o.Conditional {
+		msg += " (when both predicates hold)"
+	}
+	return msg
+}
+
+// ruleClaim is a single rule of a category, reduced to what is needed to detect overlaps.
+type ruleClaim struct {
+	rule        string
+	extension   string // Extension the rule targets, empty when unknown
+	wildcard    bool   // Predicate-only categories claim every file
+	specificity int
+}
+
+// Validate walks the tree and reports every pair of categories with overlapping extensions or
+// patterns. Overlaps are not errors, precedence is deterministic, but they are usually unintended.
+func (tree *FileTypeTree) Validate() []RuleOverlap {
+	var nodes []*FileTypeNode
+	collectRuleNodes(tree.Root, &nodes)
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Order < nodes[j].Order })
+
+	var overlaps []RuleOverlap
+	for i := 0; i < len(nodes); i++ {
+		for j := i + 1; j < len(nodes); j++ {
+			overlaps = append(overlaps, findOverlaps(nodes[i], nodes[j])...)
+		}
+	}
+	return overlaps
+}
+
+func collectRuleNodes(node *FileTypeNode, nodes *[]*FileTypeNode) {
+	if node.HasRules() {
+		*nodes = append(*nodes, node)
+	}
+	for _, child := range node.Children {
+		collectRuleNodes(child, nodes)
+	}
+}
+
+func findOverlaps(a, b *FileTypeNode) []RuleOverlap {
+	var overlaps []RuleOverlap
+	seen := make(map[string]bool)
+
+	for _, claimA := range ruleClaims(a) {
+		for _, claimB := range ruleClaims(b) {
+			rule, ok := overlappingRule(claimA, claimB)
+			if !ok || seen[rule] {
+				continue
+			}
+			seen[rule] = true
+
+			matchA := RuleMatch{Node: a, Specificity: claimA.specificity + len(a.Predicates)*predicateSpecificity}
+			matchB := RuleMatch{Node: b, Specificity: claimB.specificity + len(b.Predicates)*predicateSpecificity}
+
+			winner := b
+			if matchA.Outranks(matchB) {
+				winner = a
+			}
+
+			overlaps = append(overlaps, RuleOverlap{
+				Rule:        rule,
+				Categories:  []string{a.Path(), b.Path()},
+				Winner:      winner.Path(),
+				Reason:      precedenceReason(matchA, matchB),
+				Conditional: len(a.Predicates) > 0 || len(b.Predicates) > 0,
+			})
+		}
+	}
+	return overlaps
+}
+
+func ruleClaims(node *FileTypeNode) []ruleClaim {
+	var claims []ruleClaim
+	for _, ext := range node.Extensions {
+		claims = append(claims, ruleClaim{
+			rule:        ext,
+			extension:   strings.ToLower(ext),
+			specificity: extensionSpecificity + len(ext),
+		})
+	}
+	for _, pattern := range node.Patterns {
+		claims = append(claims, ruleClaim{
+			rule:        pattern.String(),
+			extension:   patternExtension(pattern),
+			specificity: pattern.Specificity(),
+		})
+	}
+	for _, mimeType := range node.MimeTypes {
+		claims = append(claims, ruleClaim{
+			rule:        mimePrefix + mimeType,
+			specificity: mimeSpecificity + len(strings.TrimSuffix(mimeType, "*")),
+		})
+	}
+	if len(claims) == 0 && len(node.Predicates) > 0 {
+		claims = append(claims, ruleClaim{rule: "*", wildcard: true})
+	}
+	return claims
+}
+
+func overlappingRule(a, b ruleClaim) (string, bool) {
+	switch {
+	case a.wildcard:
+		return b.rule, true
+	case b.wildcard:
+		return a.rule, true
+	case a.rule == b.rule:
+		return a.rule, true
+	case a.extension != "" && a.extension == b.extension:
+		// Prefer naming the pattern, it says more than the bare extension
+		if strings.HasPrefix(a.rule, ".") {
+			return b.rule, true
+		}
+		return a.rule, true
+	case a.extension != "" && b.extension != "" && strings.HasSuffix(a.extension, b.extension):
+		// Compound extensions overlap with their last part, ".tar.gz" with ".gz"
+		return a.rule, true
+	case a.extension != "" && b.extension != "" && strings.HasSuffix(b.extension, a.extension):
+		return b.rule, true
+	}
+	return "", false
+}
+
+// patternExtension guesses the extension a pattern is restricted to, e.g. ".png" for "Screenshot*.png".
+func patternExtension(pattern *NamePattern) string {
+	expr := strings.ToLower(pattern.Expr)
+
+	if pattern.Kind == RegexPattern {
+		if match := regexExtension.FindStringSubmatch(expr); match != nil {
+			return match[1]
+		}
+		return ""
+	}
+
+	dot := strings.LastIndex(expr, ".")
+	if dot == -1 || strings.ContainsAny(expr[dot:], "*?[]\\") {
+		return ""
+	}
+	return expr[dot:]
+}
+
+func precedenceReason(a, b RuleMatch) string {
+	switch {
+	case a.Node.Priority != b.Node.Priority:
+		return "priority"
+	case a.Specificity != b.Specificity:
+		return "specificity"
+	case a.Node.Depth() != b.Node.Depth():
+		return "depth"
+	}
+	return "config order"
+}
@@ -0,0 +1,87 @@
+// Package walk walks a directory tree over a bounded number of goroutines.
+package walk
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// errCanceled is returned by the visits skipped once another one failed.
+var errCanceled = errors.New("walk canceled")
+
+// Visit lists one directory and returns the subdirectories to walk next, in the order they should
+// be walked. It is called for different directories at once, but never twice for the same one.
+type Visit[T any] func(dir T) ([]T, error)
+
+// Walk calls visit on root and on every directory it returns, with at most jobs visits in flight.
+// A directory is always visited after its parent, and visiting it may start as soon as the parent
+// returns; a visit must only modify its own directory. With one job, directories are visited
+// depth first, in the order the visits return them.
+//
+// The first error stops the walk: visits that have not started yet are skipped, and Walk returns
+// the error of the first failed directory in walk order, among those visited.
+func Walk[T any](root T, jobs int, visit Visit[T]) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	w := &walker[T]{visit: visit, tokens: make(chan struct{}, jobs-1)}
+	return w.walk(root)
+}
+
+type walker[T any] struct {
+	visit  Visit[T]
+	tokens chan struct{} // One per extra goroutine, the caller of Walk is the first job
+	failed atomic.Bool
+}
+
+// walk visits a directory, then its subdirectories. A subdirectory gets a goroutine of its own
+// while there are jobs left, otherwise it is walked by the current one, so the number of
+// goroutines, and of directories read at once, never exceeds the number of jobs.
+func (w *walker[T]) walk(dir T) error {
+	if w.failed.Load() {
+		return errCanceled
+	}
+
+	children, err := w.visit(dir)
+	if err != nil {
+		w.failed.Store(true)
+		return err
+	}
+
+	errs := make([]error, len(children))
+	var wg sync.WaitGroup
+	for i, child := range children {
+		select {
+		case w.tokens <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() { <-w.tokens }()
+				defer wg.Done()
+				errs[i] = w.walk(child)
+			}()
+		default:
+			errs[i] = w.walk(child)
+		}
+	}
+	wg.Wait()
+
+	return firstError(errs)
+}
+
+// firstError returns the first error of the subdirectories, in walk order. A subdirectory skipped
+// because another one failed only counts when no other error is known at this level: the error
+// that canceled the walk is then reported by another branch.
+func firstError(errs []error) error {
+	var canceled error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, errCanceled):
+			canceled = err
+		default:
+			return err
+		}
+	}
+	return canceled
+}
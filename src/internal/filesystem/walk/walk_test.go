@@ -0,0 +1,127 @@
+package walk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dirNode is a directory as the indexer records it: its files, then its subdirectories.
+type dirNode struct {
+	path     string
+	files    []string
+	children []*dirNode
+}
+
+// generateTree creates width subdirectories per level down to depth, each holding files files.
+func generateTree(t testing.TB, width, depth, files int) string {
+	root := t.TempDir()
+	var fill func(dir string, level int)
+	fill = func(dir string, level int) {
+		for i := 0; i < files; i++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.txt", i)), []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < width; i++ {
+			child := filepath.Join(dir, fmt.Sprintf("d%d", i))
+			if err := os.Mkdir(child, 0755); err != nil {
+				t.Fatal(err)
+			}
+			fill(child, level+1)
+		}
+	}
+	fill(root, 0)
+	return root
+}
+
+// listDir reads a directory and stats its files, like buildTreeNodes does.
+func listDir(node *dirNode) ([]*dirNode, error) {
+	entries, err := os.ReadDir(node.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			node.children = append(node.children, &dirNode{path: filepath.Join(node.path, entry.Name())})
+			continue
+		}
+		if _, err := entry.Info(); err != nil {
+			return nil, err
+		}
+		node.files = append(node.files, entry.Name())
+	}
+	return node.children, nil
+}
+
+// walkSerial is the recursive walk buildTreeNodes used before Walk.
+func walkSerial(node *dirNode) error {
+	children, err := listDir(node)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := walkSerial(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWalkMatchesSerialWalk(t *testing.T) {
+	root := generateTree(t, 4, 3, 5)
+
+	expected := &dirNode{path: root}
+	assert.NoError(t, walkSerial(expected))
+
+	for _, jobs := range []int{0, 1, 3, 16} {
+		tree := &dirNode{path: root}
+		assert.NoError(t, Walk(tree, jobs, listDir))
+		assert.Equal(t, expected, tree, "jobs=%d", jobs)
+	}
+}
+
+func TestWalkVisitsInOrderWithOneJob(t *testing.T) {
+	root := generateTree(t, 3, 2, 0)
+
+	var visited []string
+	err := Walk(&dirNode{path: root}, 1, func(node *dirNode) ([]*dirNode, error) {
+		rel, _ := filepath.Rel(root, node.path)
+		visited = append(visited, rel)
+		return listDir(node)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{".", "d0", "d0/d0", "d0/d1", "d0/d2", "d1", "d1/d0", "d1/d1", "d1/d2", "d2", "d2/d0", "d2/d1", "d2/d2"}, visited)
+}
+
+func TestWalkStopsAtTheFirstError(t *testing.T) {
+	root := generateTree(t, 3, 2, 1)
+	errDenied := errors.New("denied")
+
+	failing := func(names ...string) Visit[*dirNode] {
+		return func(node *dirNode) ([]*dirNode, error) {
+			for _, name := range names {
+				if node.path == filepath.Join(root, name) {
+					return nil, fmt.Errorf("%s: %w", name, errDenied)
+				}
+			}
+			return listDir(node)
+		}
+	}
+
+	err := Walk(&dirNode{path: root}, 1, failing("d1/d2", "d2"))
+	assert.EqualError(t, err, "d1/d2: denied")
+
+	for _, jobs := range []int{2, 8} {
+		err = Walk(&dirNode{path: root}, jobs, failing("d0/d1", "d2/d0"))
+		assert.ErrorIs(t, err, errDenied, "jobs=%d", jobs)
+		assert.NotErrorIs(t, err, errCanceled, "jobs=%d", jobs)
+	}
+}
@@ -15,4 +15,6 @@ var (
 	DefaultWorkspaceDBPath     = filepath.Join(DefaultWorkspaceDotDir, "workspace.db")
 	DefaultWorkspaceConfigFile = filepath.Join(DefaultWorkspaceDotDir, "config.toml")
 	DefaultGlobalConfigFile    = filepath.Join(DefaultConfigPath, "config.toml")
+	// DefaultDirectoryConfigFile is the per-directory rules file, merged with the parent config for its subtree
+	DefaultDirectoryConfigFile = DefaultWorkspaceDotDir + ".toml"
 )